@@ -0,0 +1,306 @@
+package assembler
+
+import (
+	"bufio"
+	i "cvm/instruction"
+	"cvm/object"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+type statement struct {
+	line     int
+	kind     byte
+	operands []token
+}
+
+// Assemble translates textual source into instructions.
+// Every line holds an optional `label:`, an optional mnemonic with its operands and an optional `; comment`.
+// Jump, block and call targets may be given as labels or absolute instruction indices.
+//
+// Struct types are declared with `.type Name field:tag ...` and created with `struct.make Name`,
+// i32.load accepts `Name.field` for the index of a field.
+func Assemble(src string) ([]i.Instruction, error) {
+	m, err := AssembleModule("", src)
+	if err != nil {
+		return nil, err
+	}
+	return m.Code, nil
+}
+
+// AssembleModule is like Assemble but also records the source line of every instruction.
+func AssembleModule(name, src string) (*i.Module, error) {
+	var stmts []statement
+	var types []i.TypeDecl
+	labels := map[string]uint32{}
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	scanner.Buffer(nil, math.MaxInt32)
+	for line := 1; scanner.Scan(); line++ {
+		tokens, err := tokenize(scanner.Text())
+		if err != nil {
+			return nil, &Error{Line: line, Msg: err.Error()}
+		}
+		if len(tokens) > 0 && !tokens[0].quoted && tokens[0].text == ".type" {
+			typ, err := parseType(tokens[1:])
+			if err != nil {
+				return nil, &Error{Line: line, Msg: err.Error()}
+			}
+			if _, ok := lookupType(typ.Name, types); ok {
+				return nil, &Error{Line: line, Msg: fmt.Sprintf("type %s redeclared", typ.Name)}
+			}
+			types = append(types, typ)
+			continue
+		}
+		for len(tokens) > 0 && !tokens[0].quoted && strings.HasSuffix(tokens[0].text, ":") {
+			label := strings.TrimSuffix(tokens[0].text, ":")
+			if !isLabel(label) {
+				return nil, &Error{Line: line, Msg: fmt.Sprintf("invalid label name %q", label)}
+			}
+			if _, ok := labels[label]; ok {
+				return nil, &Error{Line: line, Msg: fmt.Sprintf("label %s redefined", label)}
+			}
+			labels[label] = uint32(len(stmts))
+			tokens = tokens[1:]
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+		if tokens[0].quoted {
+			return nil, &Error{Line: line, Msg: "expected mnemonic, got string literal"}
+		}
+		kind, ok := i.Lookup(tokens[0].text)
+		if !ok {
+			return nil, &Error{Line: line, Msg: fmt.Sprintf("unknown mnemonic %s", tokens[0].text)}
+		}
+		stmts = append(stmts, statement{line: line, kind: kind, operands: tokens[1:]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	m := &i.Module{
+		Types: types,
+		Code:  make([]i.Instruction, 0, len(stmts)),
+		Debug: &i.DebugInfo{Source: name, Lines: make([]uint32, 0, len(stmts))},
+	}
+	for _, stmt := range stmts {
+		instr, err := encode(stmt, labels, types)
+		if err != nil {
+			return nil, &Error{Line: stmt.line, Msg: err.Error()}
+		}
+		m.Code = append(m.Code, instr)
+		m.Debug.Lines = append(m.Debug.Lines, uint32(stmt.line))
+	}
+	return m, nil
+}
+
+func encode(stmt statement, labels map[string]uint32, types []i.TypeDecl) (i.Instruction, error) {
+	op, _ := i.Info(stmt.kind)
+	kinds := op.Operands
+	ops := stmt.operands
+	if len(kinds) == 0 || kinds[len(kinds)-1] != i.OPERAND_TAGS {
+		if len(ops) != len(kinds) {
+			return i.Instruction{}, fmt.Errorf("%s expects %d operands, got %d", op.Mnemonic, len(kinds), len(ops))
+		}
+	} else if len(ops) < len(kinds)-1 {
+		return i.Instruction{}, fmt.Errorf("%s expects at least %d operands, got %d", op.Mnemonic, len(kinds)-1, len(ops))
+	}
+	for ind, tok := range ops {
+		if tok.quoted && (ind >= len(kinds) || kinds[ind] != i.OPERAND_STRING) {
+			return i.Instruction{}, fmt.Errorf("unexpected string literal %q", tok.text)
+		}
+	}
+	instr := i.Instruction{Kind: stmt.kind}
+	for ind, k := range kinds {
+		if k == i.OPERAND_TAGS {
+			tags := make([]byte, 0, len(ops)-ind)
+			for _, tok := range ops[ind:] {
+				tag, err := parseTag(tok.text)
+				if err != nil {
+					return i.Instruction{}, err
+				}
+				tags = append(tags, tag)
+			}
+			instr.Operands = append(instr.Operands, i.StructNew(tags...).Operands...)
+			break
+		}
+		operand, err := encodeOperand(k, ops[ind], labels, types)
+		if err != nil {
+			return i.Instruction{}, err
+		}
+		instr.Operands = append(instr.Operands, operand...)
+	}
+	if _, err := instr.Format(nil); err != nil {
+		return i.Instruction{}, err
+	}
+	return instr, nil
+}
+
+// encodeOperand parses one operand of kind k, the encodings are the ones the instruction constructors produce.
+func encodeOperand(k i.Operand, tok token, labels map[string]uint32, types []i.TypeDecl) ([]byte, error) {
+	switch k {
+	case i.OPERAND_I32:
+		val, err := strconv.ParseInt(tok.text, 0, 32)
+		if err == nil {
+			return i.I32Load(int32(val)).Operands, nil
+		}
+		if name, field, ok := strings.Cut(tok.text, "."); ok {
+			ind, err := parseField(name, field, types)
+			if err != nil {
+				return nil, err
+			}
+			return i.I32Load(int32(ind)).Operands, nil
+		}
+		return nil, fmt.Errorf("invalid i32 %s", tok.text)
+	case i.OPERAND_F32:
+		val, err := strconv.ParseFloat(tok.text, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid f32 %s", tok.text)
+		}
+		return i.F32Load(float32(val)).Operands, nil
+	case i.OPERAND_I64:
+		val, err := strconv.ParseInt(tok.text, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid i64 %s", tok.text)
+		}
+		return i.I64Load(val).Operands, nil
+	case i.OPERAND_F64:
+		val, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid f64 %s", tok.text)
+		}
+		return i.F64Load(val).Operands, nil
+	case i.OPERAND_BOOL:
+		val, err := strconv.ParseBool(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bool %s", tok.text)
+		}
+		return i.BoolLoad(val).Operands, nil
+	case i.OPERAND_STRING:
+		if !tok.quoted {
+			return nil, fmt.Errorf("expected string literal, got %s", tok.text)
+		}
+		return i.StringLoad(tok.text).Operands, nil
+	case i.OPERAND_TAG, i.OPERAND_LIST:
+		tag, err := parseTag(tok.text)
+		if err != nil {
+			return nil, err
+		}
+		if k == i.OPERAND_LIST {
+			return i.ListNew(tag).Operands, nil
+		}
+		return []byte{tag}, nil
+	case i.OPERAND_ADDR:
+		addr, err := parseAddr(tok.text, labels)
+		if err != nil {
+			return nil, err
+		}
+		return i.Jump(addr).Operands, nil
+	case i.OPERAND_UINT, i.OPERAND_SLOT:
+		val, err := parseUint(strings.TrimPrefix(tok.text, "$"))
+		if err != nil {
+			return nil, err
+		}
+		return i.Load(val).Operands, nil
+	case i.OPERAND_TYPE:
+		typ, ok := lookupType(tok.text, types)
+		if !ok {
+			val, err := strconv.ParseUint(tok.text, 0, 32)
+			if err != nil || val >= uint64(len(types)) {
+				return nil, fmt.Errorf("unknown type %s", tok.text)
+			}
+			typ = uint32(val)
+		}
+		return i.StructMake(typ).Operands, nil
+	default:
+		return nil, fmt.Errorf("unknown operand kind %d", k)
+	}
+}
+
+func parseTag(name string) (byte, error) {
+	tag, ok := object.TagByName(name)
+	if !ok {
+		return 0, fmt.Errorf("unknown tag %s", name)
+	}
+	return tag, nil
+}
+
+// parseType parses the operands of a `.type Name field:tag ...` declaration.
+func parseType(ops []token) (i.TypeDecl, error) {
+	if len(ops) == 0 || ops[0].quoted || !i.IsIdent(ops[0].text) {
+		return i.TypeDecl{}, fmt.Errorf(".type expects a type name")
+	}
+	typ := i.TypeDecl{Name: ops[0].text}
+	for _, op := range ops[1:] {
+		name, tagName, ok := strings.Cut(op.text, ":")
+		if op.quoted || !ok || !i.IsIdent(name) {
+			return i.TypeDecl{}, fmt.Errorf("invalid field %s, expected name:tag", op.text)
+		}
+		if _, ok := typ.FieldIndex(name); ok {
+			return i.TypeDecl{}, fmt.Errorf("field %s redeclared in type %s", name, typ.Name)
+		}
+		tag, err := parseTag(tagName)
+		if err != nil {
+			return i.TypeDecl{}, err
+		}
+		if _, err := object.CreateDefault(tag); err != nil {
+			return i.TypeDecl{}, fmt.Errorf("invalid field %s: %v", name, err)
+		}
+		typ.Fields = append(typ.Fields, i.Field{Name: name, Tag: tag})
+	}
+	return typ, nil
+}
+
+func lookupType(name string, types []i.TypeDecl) (uint32, bool) {
+	for ind, typ := range types {
+		if typ.Name == name {
+			return uint32(ind), true
+		}
+	}
+	return 0, false
+}
+
+func parseField(name, field string, types []i.TypeDecl) (uint32, error) {
+	typ, ok := lookupType(name, types)
+	if !ok {
+		return 0, fmt.Errorf("unknown type %s", name)
+	}
+	ind, ok := types[typ].FieldIndex(field)
+	if !ok {
+		return 0, fmt.Errorf("type %s has no field %s", name, field)
+	}
+	return ind, nil
+}
+
+func parseUint(text string) (uint32, error) {
+	val, err := strconv.ParseUint(text, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid unsigned integer %s", text)
+	}
+	return uint32(val), nil
+}
+
+func parseAddr(text string, labels map[string]uint32) (uint32, error) {
+	if isLabel(text) {
+		addr, ok := labels[text]
+		if !ok {
+			return 0, fmt.Errorf("undefined label %s", text)
+		}
+		return addr, nil
+	}
+	addr, err := parseUint(strings.TrimSuffix(strings.TrimPrefix(text, "["), "]"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid target %s", text)
+	}
+	return addr, nil
+}
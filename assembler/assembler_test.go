@@ -0,0 +1,276 @@
+package assembler
+
+import (
+	"bytes"
+	"context"
+	"cvm"
+	i "cvm/instruction"
+	"cvm/object"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+const fibSource = `
+; fib(n) = fib(n-1) + fib(n-2)
+        i32.load 10
+        func.call fib, 1
+        halt
+fib:    new                     ; n -> local $0
+        local.load $0
+        i32.load 2
+        i32.lt
+        jumpc base
+        local.load 0
+        i32.load 1
+        i32.sub
+        func.call fib 1
+        local.load 0
+        i32.load 2
+        i32.sub
+        func.call fib 1
+        i32.add
+        func.ret 1
+base:
+        local.load 0
+        func.ret 1
+`
+
+func TestAssemble(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		src    string
+		instrs []i.Instruction
+	}{
+		{
+			desc: "test literals",
+			src: `
+				i32.load -42
+				i32.load 0x10
+				f32.load 1.5
+				bool.load true
+				string.load "hello; \"world\"\n"
+				list.new string
+				struct.new i32 list string
+				struct.new
+			`,
+			instrs: []i.Instruction{
+				i.I32Load(-42),
+				i.I32Load(16),
+				i.F32Load(1.5),
+				i.BoolLoad(true),
+				i.StringLoad("hello; \"world\"\n"),
+				i.ListNew(object.TAG_STRING),
+				i.StructNew(object.TAG_I32, object.TAG_LIST, object.TAG_STRING),
+				i.StructNew(),
+			},
+		},
+		{
+			desc: "test labels",
+			src: `
+				jump end
+			loop: i32.load 1
+				jumpc loop
+				block.block [4]
+			end:
+			inner: func.call loop 2
+				jumpnc 0
+			`,
+			instrs: []i.Instruction{
+				i.Jump(4),
+				i.I32Load(1),
+				i.JumpC(1),
+				i.BlockStart(4),
+				i.FuncCall(1, 2),
+				i.JumpNC(0),
+			},
+		},
+		{
+			desc: "test types",
+			src: `
+			.type Point x:i32 y:f32
+			.type Named p:struct
+				struct.make Named
+				i32.load Named.p
+				struct.make Point
+				i32.load Point.y
+				struct.make 0
+			`,
+			instrs: []i.Instruction{
+				i.StructMake(1),
+				i.I32Load(0),
+				i.StructMake(0),
+				i.I32Load(1),
+				i.StructMake(0),
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			instrs, err := Assemble(tC.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(instrs) != len(tC.instrs) {
+				t.Fatalf("got %d instructions, want %d", len(instrs), len(tC.instrs))
+			}
+			for ind := range instrs {
+				if instrs[ind].Kind != tC.instrs[ind].Kind || !bytes.Equal(instrs[ind].Operands, tC.instrs[ind].Operands) {
+					t.Fatalf("instruction %d: %v != %v", ind, instrs[ind], tC.instrs[ind])
+				}
+			}
+		})
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	testCases := []struct {
+		desc string
+		src  string
+		line int
+	}{
+		{desc: "test unknown mnemonic", src: "i32.load 1\ni32.foo", line: 2},
+		{desc: "test undefined label", src: "\n\njump nowhere", line: 3},
+		{desc: "test redefined label", src: "a:\na: halt", line: 2},
+		{desc: "test operand count", src: "i32.add 1", line: 1},
+		{desc: "test invalid i32", src: "i32.load 99999999999", line: 1},
+		{desc: "test unterminated string", src: "string.load \"abc", line: 1},
+		{desc: "test unknown tag", src: "list.new dict", line: 1},
+		{desc: "test invalid map key", src: "map.new f32 i32", line: 1},
+		{desc: "test unknown type", src: ".type T a:i32\nstruct.make U", line: 2},
+		{desc: "test unknown field", src: ".type T a:i32\ni32.load T.b", line: 2},
+		{desc: "test redeclared type", src: ".type T\n.type T", line: 2},
+		{desc: "test invalid field", src: ".type T a", line: 1},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			_, err := Assemble(tC.src)
+			var asmErr *Error
+			if !errors.As(err, &asmErr) {
+				t.Fatalf("expected assembler error, got %v", err)
+			}
+			if asmErr.Line != tC.line {
+				t.Fatalf("error at line %d, want %d: %v", asmErr.Line, tC.line, err)
+			}
+		})
+	}
+}
+
+func TestAssembleFib(t *testing.T) {
+	instrs, err := Assemble(fibSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := cvm.CVM{}
+	err = vm.Execute(context.TODO(), instrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := obj(object.CreateI32(55))
+	if !bytes.Equal(object.Bytes(vm.Stack[0]), object.Bytes(res)) {
+		t.Fatalf("%v != %v", vm.Stack[0], res)
+	}
+}
+
+func obj(obj object.CVMObject, err error) object.CVMObject {
+	return obj
+}
+
+func TestDisassemble(t *testing.T) {
+	fib, err := Assemble(fibSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		desc   string
+		instrs []i.Instruction
+	}{
+		{
+			desc:   "test fib",
+			instrs: fib,
+		},
+		{
+			desc: "test operands",
+			instrs: []i.Instruction{
+				i.I32Load(-7),
+				i.F32Load(0.1),
+				i.F32Load(float32(math.Inf(-1))),
+				i.I64Load(math.MinInt64),
+				i.F64Load(1e-300),
+				i.BoolLoad(false),
+				i.StringLoad("tab\t; \"quoted\""),
+				i.ListNew(object.TAG_LIST),
+				i.StructNew(object.TAG_STRING, object.TAG_BOOL),
+				i.MapNew(object.TAG_STRING, object.TAG_LIST),
+				i.BlockStart(14),
+				i.BlockLoad(1),
+				i.BlockSave(2),
+				i.BlockBr(),
+				i.BlockEnd(),
+				i.Load(3),
+				i.Save(4),
+				i.Free(5),
+				i.Jump(99),
+				i.FuncRet(2),
+				i.Halt(),
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			src, err := Disassemble(tC.instrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			instrs, err := Assemble(src)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, src)
+			}
+			if len(instrs) != len(tC.instrs) {
+				t.Fatalf("got %d instructions, want %d", len(instrs), len(tC.instrs))
+			}
+			for ind := range instrs {
+				if instrs[ind].Kind != tC.instrs[ind].Kind || !bytes.Equal(instrs[ind].Operands, tC.instrs[ind].Operands) {
+					t.Fatalf("instruction %d: %v != %v", ind, instrs[ind], tC.instrs[ind])
+				}
+			}
+		})
+	}
+}
+
+func TestDisassembleModule(t *testing.T) {
+	src := ".type Point x:i32 y:i32\n.type Line from:struct to:struct\nstruct.make Line\nstruct.make Point\n"
+	m, err := AssembleModule("types.cvms", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := DisassembleModule(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, ".type Line from:struct to:struct\n") || !strings.Contains(out, "struct.make  Point") {
+		t.Fatalf("unexpected disassembly:\n%s", out)
+	}
+	res, err := AssembleModule("types.cvms", out)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if len(res.Types) != 2 || res.Types[1].Name != "Line" || len(res.Code) != 2 || !bytes.Equal(res.Code[1].Operands, i.StructMake(0).Operands) {
+		t.Fatalf("round trip mismatch:\n%s", out)
+	}
+}
+
+func TestDisassembleInvalid(t *testing.T) {
+	instrs := []i.Instruction{
+		i.Halt(),
+		{Kind: i.OP_I32_LOAD, Operands: []byte{object.TAG_I32, 1}},
+	}
+	_, err := Disassemble(instrs)
+	if err == nil {
+		t.Fatal("expected error for truncated operands")
+	}
+	if str := instrs[1].String(); !strings.HasPrefix(str, "i32.load") {
+		t.Fatalf("unexpected string %q", str)
+	}
+}
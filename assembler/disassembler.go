@@ -0,0 +1,76 @@
+package assembler
+
+import (
+	i "cvm/instruction"
+	"cvm/object"
+	"fmt"
+	"strings"
+)
+
+// Disassemble renders a program as assembler source.
+// Every jump, block and call target inside the program gets a synthesized label,
+// and every instruction is annotated with its index, so Assemble(Disassemble(p)) reproduces p.
+// The exception is struct.make: without a module there are no type declarations,
+// its type index is printed as a number that Assemble rejects. Programs using struct types
+// round trip through DisassembleModule and AssembleModule.
+func Disassemble(instrs []i.Instruction) (string, error) {
+	return disassemble(instrs, nil)
+}
+
+// DisassembleModule is like Disassemble but also emits the type declarations of m
+// and refers to struct types by name.
+func DisassembleModule(m *i.Module) (string, error) {
+	var buf strings.Builder
+	for _, typ := range m.Types {
+		fmt.Fprintf(&buf, ".type %s", typ.Name)
+		for _, field := range typ.Fields {
+			fmt.Fprintf(&buf, " %s:%s", field.Name, object.TagsName(field.Tag))
+		}
+		fmt.Fprintln(&buf)
+	}
+	code, err := disassemble(m.Code, m.Types)
+	if err != nil {
+		return "", err
+	}
+	buf.WriteString(code)
+	return buf.String(), nil
+}
+
+func disassemble(instrs []i.Instruction, types []i.TypeDecl) (string, error) {
+	labels := map[uint32]string{}
+	for _, instr := range instrs {
+		addr, ok := instr.Target()
+		if !ok || addr > uint32(len(instrs)) {
+			continue
+		}
+		if instr.Kind == i.OP_FUNC_CALL || instr.Kind == i.OP_FUNC_LOAD || instr.Kind == i.OP_FUNC_TAILCALL {
+			labels[addr] = fmt.Sprintf("F%04d", addr)
+		} else if _, ok := labels[addr]; !ok {
+			labels[addr] = fmt.Sprintf("L%04d", addr)
+		}
+	}
+	label := func(addr uint32) string {
+		if name, ok := labels[addr]; ok {
+			return name
+		}
+		return fmt.Sprintf("%d", addr)
+	}
+	var buf strings.Builder
+	for ip, instr := range instrs {
+		if name, ok := labels[uint32(ip)]; ok {
+			fmt.Fprintf(&buf, "%s:\n", name)
+		}
+		str, err := instr.Format(label)
+		if err != nil {
+			return "", fmt.Errorf("instruction %04d: %w", ip, err)
+		}
+		if typ, err := instr.OperandI32(0); instr.Kind == i.OP_STRUCT_MAKE && err == nil && typ < uint32(len(types)) {
+			str = fmt.Sprintf("%-12s %s", i.Mnemonic(instr.Kind), types[typ].Name)
+		}
+		fmt.Fprintf(&buf, "\t%-32s ; %04d\n", str, ip)
+	}
+	if name, ok := labels[uint32(len(instrs))]; ok {
+		fmt.Fprintf(&buf, "%s:\n", name)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,70 @@
+package assembler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+// tokenize splits one source line into tokens, dropping `;` comments.
+// Operands may be separated by whitespace or commas; string literals use Go syntax.
+func tokenize(line string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(line); {
+		ch := line[i]
+		switch {
+		case ch == ';':
+			return tokens, nil
+		case ch == ' ' || ch == '\t' || ch == '\r' || ch == ',':
+			i++
+		case ch == '"':
+			end := i + 1
+			for ; end < len(line); end++ {
+				if line[end] == '\\' {
+					end++
+					continue
+				}
+				if line[end] == '"' {
+					break
+				}
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated string literal")
+			}
+			str, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string literal %s", line[i:end+1])
+			}
+			tokens = append(tokens, token{text: str, quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(line) && !strings.ContainsRune(" \t\r,;\"", rune(line[end])) {
+				end++
+			}
+			tokens = append(tokens, token{text: line[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+func isLabel(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, ch := range name {
+		switch {
+		case ch == '_' || ch == '.' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z'):
+		case i > 0 && ch >= '0' && ch <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package cvm
+
+import (
+	"context"
+	i "cvm/instruction"
+	"cvm/object"
+	"fmt"
+	"testing"
+)
+
+// fib computes fib(n) with the naive double recursion.
+func fib(n int32) []i.Instruction {
+	return []i.Instruction{
+		i.I32Load(n),
+		i.FuncCall(3, 1),
+		i.Halt(),
+		i.New(),
+		i.LocalLoad(0),
+		i.I32Load(2),
+		i.I32Lt(),
+		i.JumpC(18),
+		i.LocalLoad(0),
+		i.I32Load(1),
+		i.I32Sub(),
+		i.FuncCall(3, 1),
+		i.LocalLoad(0),
+		i.I32Load(2),
+		i.I32Sub(),
+		i.FuncCall(3, 1),
+		i.I32Add(),
+		i.FuncRet(1),
+		i.LocalLoad(0),
+		i.FuncRet(1),
+	}
+}
+
+// The recursive benchmarks run each program through Execute on a new vm, which links it every time,
+// through Execute on one vm, which reuses the linked program, and through Run with a program linked once.
+// Compare them with an older revision by running the same benchmark there and feeding both outputs to benchstat.
+func benchmarkProgram(b *testing.B, instrs []i.Instruction) {
+	b.Run("execute", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			vm := CVM{}
+			if err := vm.Execute(context.TODO(), instrs); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("execute again", func(b *testing.B) {
+		vm := CVM{}
+		for n := 0; n < b.N; n++ {
+			vm.SP = 0
+			if err := vm.Execute(context.TODO(), instrs); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("run", func(b *testing.B) {
+		p, err := Link(instrs, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for n := 0; n < b.N; n++ {
+			vm := CVM{}
+			if err := vm.Run(context.TODO(), p); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkFib(b *testing.B) {
+	benchmarkProgram(b, fib(20))
+}
+
+func BenchmarkCountdown(b *testing.B) {
+	benchmarkProgram(b, countdown(1000))
+}
+
+// loop runs body n times with the value init leaves in slot $0 and the counter in slot $1, then runs finish.
+func loop(n int32, init, body, finish []i.Instruction) []i.Instruction {
+	instrs := append([]i.Instruction{}, init...)
+	instrs = append(instrs, i.New(), i.I32Load(0), i.New())
+	start := uint32(len(instrs))
+	end := start + 4 + uint32(len(body)) + 5
+	instrs = append(instrs, i.Load(1), i.I32Load(n), i.I32Lt(), i.JumpNC(end))
+	instrs = append(instrs, body...)
+	instrs = append(instrs, i.Load(1), i.I32Load(1), i.I32Add(), i.Save(1), i.Jump(start))
+	return append(instrs, finish...)
+}
+
+// The building benchmarks compare growing a value, which copies it on every step,
+// with growing the same object in place through a reference.
+var buildBenchmarks = []struct {
+	name   string
+	instrs func(n int32) []i.Instruction
+}{
+	{
+		name: "list/value",
+		instrs: func(n int32) []i.Instruction {
+			return loop(n,
+				[]i.Instruction{i.ListNew(object.TAG_I32)},
+				[]i.Instruction{i.Load(0), i.Load(1), i.ListAppend(), i.Save(0)},
+				[]i.Instruction{i.Load(0), i.ListLength()})
+		},
+	},
+	{
+		name: "list/ref",
+		instrs: func(n int32) []i.Instruction {
+			return loop(n,
+				[]i.Instruction{i.ListNew(object.TAG_I32), i.RefNew()},
+				[]i.Instruction{i.Load(0), i.Load(1), i.RefAppend()},
+				[]i.Instruction{i.Load(0), i.RefLength()})
+		},
+	},
+	{
+		name: "string/value",
+		instrs: func(n int32) []i.Instruction {
+			return loop(n,
+				[]i.Instruction{i.StringLoad("")},
+				[]i.Instruction{i.Load(0), i.StringLoad("x"), i.StringConcat(), i.Save(0)},
+				[]i.Instruction{i.Load(0), i.StringLength()})
+		},
+	},
+	{
+		name: "string/ref",
+		instrs: func(n int32) []i.Instruction {
+			return loop(n,
+				[]i.Instruction{i.StringLoad(""), i.RefNew()},
+				[]i.Instruction{i.Load(0), i.StringLoad("x"), i.RefAppend()},
+				[]i.Instruction{i.Load(0), i.RefLength()})
+		},
+	},
+}
+
+func BenchmarkBuild(b *testing.B) {
+	for _, bm := range buildBenchmarks {
+		for _, n := range []int32{1000, 10000} {
+			instrs := bm.instrs(n)
+			b.Run(fmt.Sprintf("%s/%d", bm.name, n), func(b *testing.B) {
+				for run := 0; run < b.N; run++ {
+					vm := CVM{}
+					if err := vm.Execute(context.TODO(), instrs); err != nil {
+						b.Fatal(err)
+					}
+					if res, _ := object.ValueI32(vm.Stack[vm.SP-1]); res != n {
+						b.Fatalf("built %d items, want %d", res, n)
+					}
+				}
+			})
+		}
+	}
+}
@@ -1,34 +1,302 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"cvm"
+	"cvm/assembler"
 	i "cvm/instruction"
 	"cvm/object"
+	"cvm/verifier"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strings"
+)
+
+const usage = `usage: cvm <command> [flags] <file>
+
+commands:
+	run     execute a program (.cvmb module or .cvms source)
+	trace   execute a program and dump the vm state on exit
+	asm     assemble .cvms source into a .cvmb module
+	disasm  print a program as assembler source
+
+run "cvm <command> -h" for command flags
+`
+
+const (
+	EXIT_OK    = 0
+	EXIT_ERROR = 1
+	EXIT_USAGE = 2
 )
 
 func main() {
-	instrs := []i.Instruction{
-		i.StructNew(object.TAG_I32, object.TAG_LIST),
-		i.I32Load(1),
-		i.StructGet(),
-		i.Halt(),
-	}
-	for i, inst := range instrs {
-		fmt.Printf("%04d: %s\n", i, inst.String())
-	}
-	fmt.Println()
-	vm := cvm.CVM{}
-	// fl, err := os.Create("prof.prof")
-	// if err != nil {
-	// 	panic(err)
-	// }
-	// pprof.StartCPUProfile(fl)
-	err := vm.Execute(context.TODO(), instrs)
-	if err != nil {
-		panic(err)
-	}
-	// pprof.StopCPUProfile()
-	fmt.Println(vm.Trace())
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(EXIT_USAGE)
+	}
+	var code int
+	switch os.Args[1] {
+	case "run":
+		code = runCmd(os.Args[2:], false)
+	case "trace":
+		code = runCmd(os.Args[2:], true)
+	case "asm":
+		code = asmCmd(os.Args[2:])
+	case "disasm":
+		code = disasmCmd(os.Args[2:])
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "cvm: unknown command %q\n\n%s", os.Args[1], usage)
+		code = EXIT_USAGE
+	}
+	os.Exit(code)
+}
+
+func runCmd(args []string, trace bool) int {
+	name := "run"
+	if trace {
+		name = "trace"
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	stackLimit := fs.Uint("stack", cvm.STACK_SIZE, "maximum number of stack slots")
+	heapLimit := fs.Uint("heap", cvm.HEAP_SIZE, "maximum number of heap slots")
+	frameLimit := fs.Uint("frames", cvm.STACK_FRAME_SIZE, "maximum number of call and block frames")
+	objectLimit := fs.Uint("objects", cvm.OBJECT_HEAP_SIZE, "maximum number of objects on the object heap")
+	gcThreshold := fs.Uint("gc", cvm.GC_THRESHOLD, "number of live objects that triggers the first collection")
+	cpuProfile := fs.String("cpuprofile", "", "write cpu profile to `file`")
+	memProfile := fs.String("memprofile", "", "write heap profile to `file` on exit")
+	fuel := fs.Uint64("fuel", 0, "maximum number of instructions to execute, 0 means unlimited")
+	timeout := fs.Duration("timeout", 0, "abort execution after `duration`, 0 means no timeout")
+	verify := fs.Bool("verify", true, "statically verify the program before running it")
+	path, ok := parseFlags(fs, args)
+	if !ok {
+		return EXIT_USAGE
+	}
+	m, err := loadModule(path)
+	if err != nil {
+		return fail(err)
+	}
+
+	vm := cvm.NewVM(cvm.Options{
+		StackLimit:  *stackLimit,
+		HeapLimit:   *heapLimit,
+		FrameLimit:  *frameLimit,
+		ObjectLimit: *objectLimit,
+		GCThreshold: *gcThreshold,
+		FuelLimit:   *fuel,
+	})
+	if *verify {
+		natives := make([]verifier.Signature, 0, len(vm.Natives))
+		for _, native := range vm.Natives {
+			natives = append(natives, verifier.Signature{Params: native.Params, Results: native.Results})
+		}
+		if err := verifier.Verify(m.Code, natives...); err != nil {
+			return fail(fmt.Errorf("%s: verification failed:\n%w", path, err))
+		}
+	}
+	if *cpuProfile != "" {
+		fl, err := os.Create(*cpuProfile)
+		if err != nil {
+			return fail(err)
+		}
+		defer fl.Close()
+		if err := pprof.StartCPUProfile(fl); err != nil {
+			return fail(err)
+		}
+		defer pprof.StopCPUProfile()
+	}
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	err = vm.ExecuteModule(ctx, m)
+	if trace {
+		fmt.Fprintln(os.Stderr, vm.Trace())
+	}
+	if *memProfile != "" {
+		fl, err := os.Create(*memProfile)
+		if err != nil {
+			return fail(err)
+		}
+		defer fl.Close()
+		if err := pprof.WriteHeapProfile(fl); err != nil {
+			return fail(err)
+		}
+	}
+	var vmErr *cvm.VMError
+	if errors.As(err, &vmErr) {
+		return failVM(m, vmErr)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return exitCode(vm)
+}
+
+// failVM prints the call stack of a runtime error, with source lines when the module has debug info.
+func failVM(m *i.Module, err *cvm.VMError) int {
+	fmt.Fprintf(os.Stderr, "cvm: %v\n", err.Err)
+	for _, entry := range err.Stack {
+		fmt.Fprintf(os.Stderr, "\tat %04d %s", entry.IP, entry.Op)
+		if m.Debug != nil && entry.IP < uint32(len(m.Debug.Lines)) {
+			fmt.Fprintf(os.Stderr, " (%s:%d)", m.Debug.Source, m.Debug.Lines[entry.IP])
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+	return EXIT_ERROR
+}
+
+// exitCode derives the process exit code from the value left on top of the stack:
+// an i32 in 0..255 is used as is, other i32 values map to EXIT_ERROR because the OS keeps only 8 bits
+// and 256 would report success, a bool maps true to success, anything else is success.
+func exitCode(vm *cvm.CVM) int {
+	if vm.SP == 0 {
+		return EXIT_OK
+	}
+	top := vm.Stack[vm.SP-1]
+	switch top.Tag {
+	case object.TAG_I32:
+		val, err := object.ValueI32(top)
+		if err != nil {
+			return fail(err)
+		}
+		if val < 0 || val > 255 {
+			return EXIT_ERROR
+		}
+		return int(val)
+	case object.TAG_BOOL:
+		val, err := object.ValueBool(top)
+		if err != nil {
+			return fail(err)
+		}
+		if !val {
+			return EXIT_ERROR
+		}
+	}
+	return EXIT_OK
+}
+
+func asmCmd(args []string) int {
+	fs := flag.NewFlagSet("asm", flag.ContinueOnError)
+	out := fs.String("o", "", "output `file` (default: input with .cvmb extension)")
+	strip := fs.Bool("strip", false, "omit the debug section")
+	path, ok := parseFlags(fs, args)
+	if !ok {
+		return EXIT_USAGE
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return fail(err)
+	}
+	m, err := assembler.AssembleModule(filepath.Base(path), string(src))
+	if err != nil {
+		return fail(fmt.Errorf("%s:%w", path, err))
+	}
+	if *strip {
+		m.Debug = nil
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(path, filepath.Ext(path)) + ".cvmb"
+	}
+	var buf bytes.Buffer
+	if err := i.Encode(&buf, m); err != nil {
+		return fail(err)
+	}
+	if err := os.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+		return fail(err)
+	}
+	return EXIT_OK
+}
+
+func disasmCmd(args []string) int {
+	fs := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	out := fs.String("o", "", "output `file` (default: stdout)")
+	path, ok := parseFlags(fs, args)
+	if !ok {
+		return EXIT_USAGE
+	}
+	m, err := loadModule(path)
+	if err != nil {
+		return fail(err)
+	}
+	src, err := assembler.DisassembleModule(m)
+	if err != nil {
+		return fail(err)
+	}
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		fl, err := os.Create(*out)
+		if err != nil {
+			return fail(err)
+		}
+		defer fl.Close()
+		w = fl
+	}
+	if _, err := io.WriteString(w, src); err != nil {
+		return fail(err)
+	}
+	return EXIT_OK
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) (string, bool) {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: cvm %s [flags] <file>\n", fs.Name())
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return "", false
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return "", false
+	}
+	// flags may also follow the file, as in cvm asm prog.cvms -o prog.cvmb
+	path := fs.Arg(0)
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return "", false
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return "", false
+	}
+	return path, true
+}
+
+// loadModule reads assembler source for .cvms files and a binary module otherwise.
+func loadModule(path string) (*i.Module, error) {
+	if filepath.Ext(path) == ".cvms" {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		m, err := assembler.AssembleModule(filepath.Base(path), string(src))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", path, err)
+		}
+		return m, nil
+	}
+	fl, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fl.Close()
+	m, err := i.Decode(fl)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+func fail(err error) int {
+	fmt.Fprintf(os.Stderr, "cvm: %v\n", err)
+	return EXIT_ERROR
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"cvm"
+	"cvm/object"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	testCases := []struct {
+		desc string
+		top  object.CVMObject
+		code int
+	}{
+		{desc: "test zero", top: obj(object.CreateI32(0)), code: EXIT_OK},
+		{desc: "test in range", top: obj(object.CreateI32(255)), code: 255},
+		{desc: "test above range", top: obj(object.CreateI32(256)), code: EXIT_ERROR},
+		{desc: "test negative", top: obj(object.CreateI32(-1)), code: EXIT_ERROR},
+		{desc: "test false", top: obj(object.CreateBool(false)), code: EXIT_ERROR},
+		{desc: "test other", top: obj(object.CreateString("x")), code: EXIT_OK},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm := &cvm.CVM{Stack: []object.CVMObject{tC.top}, SP: 1}
+			if code := exitCode(vm); code != tC.code {
+				t.Fatalf("exit code %d, expected %d", code, tC.code)
+			}
+		})
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	testCases := []struct {
+		desc string
+		args []string
+		path string
+		ok   bool
+		out  string
+	}{
+		{desc: "test flags before file", args: []string{"-o", "a.cvmb", "a.cvms"}, path: "a.cvms", ok: true, out: "a.cvmb"},
+		{desc: "test flags after file", args: []string{"a.cvms", "-o", "a.cvmb"}, path: "a.cvms", ok: true, out: "a.cvmb"},
+		{desc: "test no file", args: []string{"-o", "a.cvmb"}},
+		{desc: "test extra file", args: []string{"a.cvms", "b.cvms"}},
+		{desc: "test extra file after flags", args: []string{"a.cvms", "-o", "a.cvmb", "b.cvms"}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			fs := flag.NewFlagSet("asm", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			out := fs.String("o", "", "")
+			path, ok := parseFlags(fs, tC.args)
+			if ok != tC.ok || path != tC.path || (ok && *out != tC.out) {
+				t.Fatalf("got %q %v -o %q, expected %q %v -o %q", path, ok, *out, tC.path, tC.ok, tC.out)
+			}
+		})
+	}
+}
+
+func TestAsmOutputAfterFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "prog.cvms")
+	out := filepath.Join(dir, "out.cvmb")
+	if err := os.WriteFile(src, []byte("i32.load 1\nprintln\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := asmCmd([]string{src, "-o", out}); code != EXIT_OK {
+		t.Fatalf("exit code %d", code)
+	}
+	if _, err := os.Stat(out); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "prog.cvmb")); !os.IsNotExist(err) {
+		t.Fatal("output written to the default path")
+	}
+}
+
+func obj(obj object.CVMObject, err error) object.CVMObject {
+	return obj
+}
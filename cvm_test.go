@@ -3,9 +3,15 @@ package cvm
 import (
 	"bytes"
 	"context"
+	"cvm/assembler"
 	i "cvm/instruction"
 	"cvm/object"
+	"cvm/verifier"
+	"errors"
+	"math"
+	"strings"
 	"testing"
+	"time"
 )
 
 func obj(obj object.CVMObject, err error) object.CVMObject {
@@ -180,6 +186,95 @@ func TestI32(t *testing.T) {
 			},
 			result: obj(object.CreateBool(true)),
 		},
+		{
+			desc: "test i32 remainder",
+			instrs: []i.Instruction{
+				i.I32Load(-7),
+				i.I32Load(3),
+				i.I32Rem(),
+			},
+			result: obj(object.CreateI32(-1)),
+		},
+		{
+			desc: "test i32 and",
+			instrs: []i.Instruction{
+				i.I32Load(0b1100),
+				i.I32Load(0b1010),
+				i.I32And(),
+			},
+			result: obj(object.CreateI32(0b1000)),
+		},
+		{
+			desc: "test i32 or",
+			instrs: []i.Instruction{
+				i.I32Load(0b1100),
+				i.I32Load(0b1010),
+				i.I32Or(),
+			},
+			result: obj(object.CreateI32(0b1110)),
+		},
+		{
+			desc: "test i32 xor",
+			instrs: []i.Instruction{
+				i.I32Load(0b1100),
+				i.I32Load(0b1010),
+				i.I32Xor(),
+			},
+			result: obj(object.CreateI32(0b0110)),
+		},
+		{
+			desc: "test i32 not",
+			instrs: []i.Instruction{
+				i.I32Load(0),
+				i.I32Not(),
+			},
+			result: obj(object.CreateI32(-1)),
+		},
+		{
+			desc: "test i32 shift left",
+			instrs: []i.Instruction{
+				i.I32Load(1),
+				i.I32Load(31),
+				i.I32Shl(),
+			},
+			result: obj(object.CreateI32(math.MinInt32)),
+		},
+		{
+			desc: "test i32 shift left wraps count",
+			instrs: []i.Instruction{
+				i.I32Load(1),
+				i.I32Load(33),
+				i.I32Shl(),
+			},
+			result: obj(object.CreateI32(2)),
+		},
+		{
+			desc: "test i32 shift negative count",
+			instrs: []i.Instruction{
+				i.I32Load(1),
+				i.I32Load(-1),
+				i.I32Shl(),
+			},
+			result: obj(object.CreateI32(math.MinInt32)),
+		},
+		{
+			desc: "test i32 arithmetic shift right",
+			instrs: []i.Instruction{
+				i.I32Load(-8),
+				i.I32Load(1),
+				i.I32Shr(),
+			},
+			result: obj(object.CreateI32(-4)),
+		},
+		{
+			desc: "test i32 logical shift right",
+			instrs: []i.Instruction{
+				i.I32Load(-8),
+				i.I32Load(28),
+				i.I32Shru(),
+			},
+			result: obj(object.CreateI32(15)),
+		},
 	}
 	for _, tC := range testCases {
 		t.Run(tC.desc, func(t *testing.T) {
@@ -363,6 +458,384 @@ func TestF32(t *testing.T) {
 			},
 			result: obj(object.CreateBool(true)),
 		},
+		{
+			desc: "test f32 sqrt",
+			instrs: []i.Instruction{
+				i.F32Load(2.25),
+				i.F32Sqrt(),
+			},
+			result: obj(object.CreateF32(1.5)),
+		},
+		{
+			desc: "test f32 pow",
+			instrs: []i.Instruction{
+				i.F32Load(2),
+				i.F32Load(10),
+				i.F32Pow(),
+			},
+			result: obj(object.CreateF32(1024)),
+		},
+		{
+			desc: "test f32 floor",
+			instrs: []i.Instruction{
+				i.F32Load(-1.5),
+				i.F32Floor(),
+			},
+			result: obj(object.CreateF32(-2)),
+		},
+		{
+			desc: "test f32 ceil",
+			instrs: []i.Instruction{
+				i.F32Load(-1.5),
+				i.F32Ceil(),
+			},
+			result: obj(object.CreateF32(-1)),
+		},
+		{
+			desc: "test f32 round half away from zero",
+			instrs: []i.Instruction{
+				i.F32Load(-2.5),
+				i.F32Round(),
+			},
+			result: obj(object.CreateF32(-3)),
+		},
+		{
+			desc: "test f32 abs",
+			instrs: []i.Instruction{
+				i.F32Load(-0.5),
+				i.F32Abs(),
+			},
+			result: obj(object.CreateF32(0.5)),
+		},
+		{
+			desc: "test f32 min",
+			instrs: []i.Instruction{
+				i.F32Load(1),
+				i.F32Load(-1),
+				i.F32Min(),
+			},
+			result: obj(object.CreateF32(-1)),
+		},
+		{
+			desc: "test f32 max",
+			instrs: []i.Instruction{
+				i.F32Load(1),
+				i.F32Load(-1),
+				i.F32Max(),
+			},
+			result: obj(object.CreateF32(1)),
+		},
+		{
+			desc: "test f32 sin",
+			instrs: []i.Instruction{
+				i.F32Load(0),
+				i.F32Sin(),
+			},
+			result: obj(object.CreateF32(0)),
+		},
+		{
+			desc: "test f32 cos",
+			instrs: []i.Instruction{
+				i.F32Load(0),
+				i.F32Cos(),
+			},
+			result: obj(object.CreateF32(1)),
+		},
+		{
+			desc: "test f32 exp",
+			instrs: []i.Instruction{
+				i.F32Load(0),
+				i.F32Exp(),
+			},
+			result: obj(object.CreateF32(1)),
+		},
+		{
+			desc: "test f32 log",
+			instrs: []i.Instruction{
+				i.F32Load(1),
+				i.F32Log(),
+			},
+			result: obj(object.CreateF32(0)),
+		},
+		{
+			desc: "test f32 is_nan",
+			instrs: []i.Instruction{
+				i.F32Load(-1),
+				i.F32Sqrt(),
+				i.F32IsNaN(),
+			},
+			result: obj(object.CreateBool(true)),
+		},
+		{
+			desc: "test f32 is_inf",
+			instrs: []i.Instruction{
+				i.F32Load(0),
+				i.F32Log(),
+				i.F32IsInf(),
+			},
+			result: obj(object.CreateBool(true)),
+		},
+		{
+			desc: "test f32 max propagates nan",
+			instrs: []i.Instruction{
+				i.F32Load(-1),
+				i.F32Sqrt(),
+				i.F32Load(1),
+				i.F32Max(),
+				i.F32IsNaN(),
+			},
+			result: obj(object.CreateBool(true)),
+		},
+		{
+			desc: "test f32 nan to string",
+			instrs: []i.Instruction{
+				i.F32Load(-1),
+				i.F32Log(),
+				i.ToString(),
+			},
+			result: obj(object.CreateString("NaN")),
+		},
+		{
+			desc: "test f32 inf to string",
+			instrs: []i.Instruction{
+				i.F32Load(0),
+				i.F32Log(),
+				i.ToString(),
+			},
+			result: obj(object.CreateString("-Inf")),
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm := CVM{}
+			err := vm.Execute(context.TODO(), tC.instrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(object.Bytes(vm.Stack[0]), object.Bytes(tC.result)) {
+				t.Fatalf("%v != %v", vm.Stack[0], tC.result)
+			}
+		})
+	}
+}
+
+func TestI64(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		instrs []i.Instruction
+		result object.CVMObject
+	}{
+		{
+			desc: "test i64 addition beyond i32",
+			instrs: []i.Instruction{
+				i.I64Load(math.MaxInt32),
+				i.I64Load(10),
+				i.I64Add(),
+			},
+			result: obj(object.CreateI64(math.MaxInt32 + 10)),
+		},
+		{
+			desc: "test i64 subtraction",
+			instrs: []i.Instruction{
+				i.I64Load(10),
+				i.I64Load(-20),
+				i.I64Sub(),
+			},
+			result: obj(object.CreateI64(30)),
+		},
+		{
+			desc: "test i64 multiplication",
+			instrs: []i.Instruction{
+				i.I64Load(1 << 40),
+				i.I64Load(-3),
+				i.I64Mul(),
+			},
+			result: obj(object.CreateI64(-3 << 40)),
+		},
+		{
+			desc: "test i64 division",
+			instrs: []i.Instruction{
+				i.I64Load(1 << 40),
+				i.I64Load(1 << 20),
+				i.I64Div(),
+			},
+			result: obj(object.CreateI64(1 << 20)),
+		},
+		{
+			desc: "test i64 negation",
+			instrs: []i.Instruction{
+				i.I64Load(math.MinInt64 + 1),
+				i.I64Neg(),
+			},
+			result: obj(object.CreateI64(math.MaxInt64)),
+		},
+		{
+			desc: "test i64 less",
+			instrs: []i.Instruction{
+				i.I64Load(1 << 40),
+				i.I64Load(1 << 41),
+				i.I64Lt(),
+			},
+			result: obj(object.CreateBool(true)),
+		},
+		{
+			desc: "test i64 equal",
+			instrs: []i.Instruction{
+				i.I64Load(1 << 40),
+				i.I64Load(1 << 40),
+				i.I64Eq(),
+			},
+			result: obj(object.CreateBool(true)),
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm := CVM{}
+			err := vm.Execute(context.TODO(), tC.instrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(object.Bytes(vm.Stack[0]), object.Bytes(tC.result)) {
+				t.Fatalf("%v != %v", vm.Stack[0], tC.result)
+			}
+		})
+	}
+	t.Run("test i64 division by zero", func(t *testing.T) {
+		vm := CVM{}
+		err := vm.Execute(context.TODO(), []i.Instruction{i.I64Load(1), i.I64Load(0), i.I64Div()})
+		if !errors.Is(err, object.ErrDivisionByZero) {
+			t.Fatalf("expected division by zero, got %v", err)
+		}
+	})
+}
+
+func TestF64(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		instrs []i.Instruction
+		result object.CVMObject
+	}{
+		{
+			desc: "test f64 addition",
+			instrs: []i.Instruction{
+				i.F64Load(0.5),
+				i.F64Load(0.25),
+				i.F64Add(),
+			},
+			result: obj(object.CreateF64(0.75)),
+		},
+		{
+			desc: "test f64 division",
+			instrs: []i.Instruction{
+				i.F64Load(1),
+				i.F64Load(3),
+				i.F64Div(),
+			},
+			result: obj(object.CreateF64(1.0 / 3)),
+		},
+		{
+			desc: "test f64 negation",
+			instrs: []i.Instruction{
+				i.F64Load(2.5),
+				i.F64Neg(),
+			},
+			result: obj(object.CreateF64(-2.5)),
+		},
+		{
+			desc: "test f64 greater or equal",
+			instrs: []i.Instruction{
+				i.F64Load(1e300),
+				i.F64Load(1e300),
+				i.F64Geq(),
+			},
+			result: obj(object.CreateBool(true)),
+		},
+		{
+			desc: "test f64 not equal",
+			instrs: []i.Instruction{
+				i.F64Load(1e-300),
+				i.F64Load(0),
+				i.F64Neq(),
+			},
+			result: obj(object.CreateBool(true)),
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm := CVM{}
+			err := vm.Execute(context.TODO(), tC.instrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(object.Bytes(vm.Stack[0]), object.Bytes(tC.result)) {
+				t.Fatalf("%v != %v", vm.Stack[0], tC.result)
+			}
+		})
+	}
+}
+
+func TestNumericConversion(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		instrs []i.Instruction
+		result object.CVMObject
+	}{
+		{
+			desc: "test i32 to i64",
+			instrs: []i.Instruction{
+				i.I32Load(-5),
+				i.ToI64(),
+			},
+			result: obj(object.CreateI64(-5)),
+		},
+		{
+			desc: "test i64 to i32 truncates",
+			instrs: []i.Instruction{
+				i.I64Load(1<<32 + 7),
+				i.ToI32(),
+			},
+			result: obj(object.CreateI32(7)),
+		},
+		{
+			desc: "test f64 to i64",
+			instrs: []i.Instruction{
+				i.F64Load(-2.75),
+				i.ToI64(),
+			},
+			result: obj(object.CreateI64(-2)),
+		},
+		{
+			desc: "test i64 to f64",
+			instrs: []i.Instruction{
+				i.I64Load(1 << 53),
+				i.ToF64(),
+			},
+			result: obj(object.CreateF64(1 << 53)),
+		},
+		{
+			desc: "test f32 to f64",
+			instrs: []i.Instruction{
+				i.F32Load(1.5),
+				i.ToF64(),
+			},
+			result: obj(object.CreateF64(1.5)),
+		},
+		{
+			desc: "test f64 to f32",
+			instrs: []i.Instruction{
+				i.F64Load(0.5),
+				i.ToF32(),
+			},
+			result: obj(object.CreateF32(0.5)),
+		},
+		{
+			desc: "test i64 to string",
+			instrs: []i.Instruction{
+				i.I64Load(math.MinInt64),
+				i.ToString(),
+			},
+			result: obj(object.CreateString("-9223372036854775808")),
+		},
 	}
 	for _, tC := range testCases {
 		t.Run(tC.desc, func(t *testing.T) {
@@ -377,3 +850,1876 @@ func TestF32(t *testing.T) {
 		})
 	}
 }
+
+func TestConversionError(t *testing.T) {
+	vm := CVM{}
+	err := vm.Execute(context.TODO(), []i.Instruction{i.StringLoad("x"), i.ToBool()})
+	if !errors.Is(err, object.ErrTypeMismatch) || !strings.Contains(err.Error(), "can't convert string to bool") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func countdown(n int32) []i.Instruction {
+	return []i.Instruction{
+		i.I32Load(n),
+		i.FuncCall(3, 1),
+		i.Halt(),
+		i.New(),
+		i.LocalLoad(0),
+		i.I32Load(0),
+		i.I32Eq(),
+		i.JumpC(13),
+		i.LocalLoad(0),
+		i.I32Load(1),
+		i.I32Sub(),
+		i.FuncCall(3, 1),
+		i.FuncRet(1),
+		i.LocalLoad(0),
+		i.FuncRet(1),
+	}
+}
+
+func TestOptions(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		opts   Options
+		instrs []i.Instruction
+		fail   bool
+	}{
+		{
+			desc:   "test default limits",
+			instrs: countdown(1000),
+		},
+		{
+			desc:   "test default frame limit",
+			instrs: countdown(5000),
+			fail:   true,
+		},
+		{
+			desc:   "test deep recursion",
+			opts:   Options{FrameLimit: 100000, HeapLimit: 100000},
+			instrs: countdown(50000),
+		},
+		{
+			desc:   "test small stack",
+			opts:   Options{StackLimit: 2},
+			instrs: []i.Instruction{i.I32Load(1), i.I32Load(2), i.I32Load(3)},
+			fail:   true,
+		},
+		{
+			desc:   "test small heap",
+			opts:   Options{HeapLimit: 10},
+			instrs: countdown(20),
+			fail:   true,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm := NewVM(tC.opts)
+			err := vm.Execute(context.TODO(), tC.instrs)
+			if tC.fail {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(object.Bytes(vm.Stack[0]), object.Bytes(obj(object.CreateI32(0)))) {
+				t.Fatalf("%v != (i32)0", vm.Stack[0])
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		instrs []i.Instruction
+		kind   ErrorKind
+		ip     uint32
+		stack  []uint32
+		target error
+	}{
+		{
+			desc:   "test stack underflow",
+			instrs: []i.Instruction{i.I32Load(1), i.I32Add()},
+			kind:   ERR_STACK_UNDERFLOW,
+			ip:     1,
+			stack:  []uint32{1},
+			target: ErrStackUnderflow,
+		},
+		{
+			desc:   "test type mismatch",
+			instrs: []i.Instruction{i.I32Load(1), i.F32Load(1), i.I32Add()},
+			kind:   ERR_TYPE_MISMATCH,
+			ip:     2,
+			stack:  []uint32{2},
+			target: object.ErrTypeMismatch,
+		},
+		{
+			desc:   "test index out of range",
+			instrs: []i.Instruction{i.ListNew(object.TAG_I32), i.I32Load(3), i.ListGet()},
+			kind:   ERR_INDEX_OUT_OF_RANGE,
+			ip:     2,
+			stack:  []uint32{2},
+			target: object.ErrIndexOutOfRange,
+		},
+		{
+			desc:   "test concat of i32",
+			instrs: []i.Instruction{i.I32Load(1), i.I32Load(2), i.StringConcat()},
+			kind:   ERR_TYPE_MISMATCH,
+			ip:     2,
+			stack:  []uint32{2},
+			target: object.ErrTypeMismatch,
+		},
+		{
+			desc:   "test concat of string and bool",
+			instrs: []i.Instruction{i.StringLoad("x"), i.BoolLoad(true), i.StringConcat()},
+			kind:   ERR_TYPE_MISMATCH,
+			ip:     2,
+			stack:  []uint32{2},
+			target: object.ErrTypeMismatch,
+		},
+		{
+			desc:   "test negative format argument count",
+			instrs: []i.Instruction{i.StringLoad("x"), i.I32Load(-1), i.StringFormat()},
+			kind:   ERR_STACK_UNDERFLOW,
+			ip:     2,
+			stack:  []uint32{2},
+			target: ErrStackUnderflow,
+		},
+		{
+			desc:   "test division by zero",
+			instrs: []i.Instruction{i.I32Load(1), i.I32Load(0), i.I32Div()},
+			kind:   ERR_DIVISION_BY_ZERO,
+			ip:     2,
+			stack:  []uint32{2},
+			target: object.ErrDivisionByZero,
+		},
+		{
+			desc:   "test remainder by zero",
+			instrs: []i.Instruction{i.I32Load(1), i.I32Load(0), i.I32Rem()},
+			kind:   ERR_DIVISION_BY_ZERO,
+			ip:     2,
+			stack:  []uint32{2},
+			target: object.ErrDivisionByZero,
+		},
+		{
+			desc:   "test heap slot",
+			instrs: []i.Instruction{i.Load(4)},
+			kind:   ERR_INVALID_SLOT,
+			stack:  []uint32{0},
+			target: ErrInvalidSlot,
+		},
+		{
+			desc:   "test unknown instruction",
+			instrs: []i.Instruction{{Kind: 0xff}},
+			kind:   ERR_UNKNOWN_INSTRUCTION,
+			stack:  []uint32{0},
+			target: ErrUnknownInstruction,
+		},
+		{
+			desc:   "test invalid operand",
+			instrs: []i.Instruction{i.I32Load(1), {Kind: i.OP_JUMP}},
+			kind:   ERR_INVALID_OPERAND,
+			ip:     1,
+			stack:  []uint32{1},
+			target: ErrInvalidOperand,
+		},
+		{
+			desc:   "test empty struct.new operands",
+			instrs: []i.Instruction{{Kind: i.OP_STRUCT_NEW, Operands: []byte{}}},
+			kind:   ERR_INVALID_OPERAND,
+			stack:  []uint32{0},
+			target: ErrInvalidOperand,
+		},
+		{
+			desc:   "test empty list.new operands",
+			instrs: []i.Instruction{{Kind: i.OP_LIST_NEW}, i.ListLength()},
+			kind:   ERR_INVALID_OPERAND,
+			stack:  []uint32{0},
+			target: ErrInvalidOperand,
+		},
+		{
+			desc:   "test trailing operand bytes",
+			instrs: []i.Instruction{{Kind: i.OP_I32_LOAD, Operands: append(i.I32Load(1).Operands, 0)}},
+			kind:   ERR_INVALID_OPERAND,
+			stack:  []uint32{0},
+			target: ErrInvalidOperand,
+		},
+		{
+			desc:   "test nil reference",
+			instrs: []i.Instruction{i.StructNew(object.TAG_REF), i.I32Load(0), i.StructGet(), i.RefGet()},
+			kind:   ERR_INVALID_SLOT,
+			ip:     3,
+			stack:  []uint32{3},
+			target: ErrInvalidSlot,
+		},
+		{
+			desc: "test call stack",
+			instrs: []i.Instruction{
+				i.FuncCall(2, 0),
+				i.Halt(),
+				i.BlockStart(6),
+				i.FuncCall(5, 0),
+				i.FuncRet(0),
+				i.Pop(),
+			},
+			kind:   ERR_STACK_UNDERFLOW,
+			ip:     5,
+			stack:  []uint32{5, 3, 0},
+			target: ErrStackUnderflow,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm := CVM{}
+			err := vm.Execute(context.TODO(), tC.instrs)
+			var vmErr *VMError
+			if !errors.As(err, &vmErr) {
+				t.Fatalf("expected VMError, got %v", err)
+			}
+			if !errors.Is(err, tC.target) {
+				t.Fatalf("expected %v, got %v", tC.target, err)
+			}
+			if vmErr.Kind != tC.kind || vmErr.IP != tC.ip {
+				t.Fatalf("got %s at %d, want %s at %d", vmErr.Kind, vmErr.IP, tC.kind, tC.ip)
+			}
+			if len(vmErr.Stack) != len(tC.stack) {
+				t.Fatalf("unexpected stack trace\n%s", vmErr.StackTrace())
+			}
+			for ind, ip := range tC.stack {
+				if vmErr.Stack[ind].IP != ip {
+					t.Fatalf("unexpected stack trace\n%s", vmErr.StackTrace())
+				}
+			}
+		})
+	}
+}
+
+func TestStringConcatShared(t *testing.T) {
+	// the concatenated strings come out of a list and a map and must leave them intact
+	testCases := []struct {
+		desc string
+		src  string
+		out  string
+	}{
+		{
+			desc: "test list",
+			src: `
+	list.new    string
+	string.load "ab"
+	list.append
+	string.load "cd"
+	list.append
+	new
+	load        $0
+	i32.load    0
+	list.get
+	string.load "x"
+	string.concat
+	println
+	load        $0
+	println
+`,
+			out: "abx\n[ ab cd ]\n",
+		},
+		{
+			desc: "test map",
+			src: `
+	map.new     string string
+	string.load "a"
+	string.load "ab"
+	map.set
+	string.load "b"
+	string.load "cd"
+	map.set
+	new
+	load        $0
+	string.load "a"
+	map.get
+	string.load "x"
+	string.concat
+	println
+	load        $0
+	println
+`,
+			out: "abx\n{ a: ab b: cd }\n",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			instrs, err := assembler.Assemble(tC.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			vm := &CVM{Stdout: &out}
+			if err := vm.Execute(context.TODO(), instrs); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tC.out {
+				t.Fatalf("%q != %q", out.String(), tC.out)
+			}
+		})
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	loop := []i.Instruction{i.Null(), i.Jump(0)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	vm := NewVM(Options{})
+	err := vm.Execute(ctx, loop)
+	var vmErr *VMError
+	if !errors.As(err, &vmErr) || vmErr.Kind != ERR_CANCELED {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	vm = NewVM(Options{FuelLimit: 101})
+	err = vm.Execute(context.TODO(), loop)
+	if !errors.As(err, &vmErr) || vmErr.Kind != ERR_FUEL_EXHAUSTED || !errors.Is(err, ErrFuelExhausted) {
+		t.Fatalf("expected fuel exhaustion, got %v", err)
+	}
+	if vmErr.IP != 1 || vm.Steps != 101 {
+		t.Fatalf("stopped at %d after %d steps", vmErr.IP, vm.Steps)
+	}
+
+	vm = NewVM(Options{FuelLimit: 3})
+	err = vm.Execute(context.TODO(), []i.Instruction{i.I32Load(1), i.I32Load(2), i.I32Add()})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestIO(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		input  string
+		instrs []i.Instruction
+		output string
+	}{
+		{
+			desc: "test print",
+			instrs: []i.Instruction{
+				i.I32Load(42),
+				i.Print(),
+				i.StringLoad(" and "),
+				i.Println(),
+			},
+			output: "42 and \n",
+		},
+		{
+			desc: "test printf",
+			instrs: []i.Instruction{
+				i.StringLoad("%. + %. = %."),
+				i.I32Load(1),
+				i.I32Load(2),
+				i.BoolLoad(true),
+				i.I32Load(3),
+				i.Printf(),
+			},
+			output: "1 + 2 = true",
+		},
+		{
+			desc:  "test multi-line read",
+			input: "first\nsecond\nthird",
+			instrs: []i.Instruction{
+				i.Read(),
+				i.Read(),
+				i.Read(),
+				i.StringConcat(),
+				i.StringConcat(),
+				i.Print(),
+			},
+			output: "first\nsecond\nthird",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			var out bytes.Buffer
+			vm := NewVM(Options{Stdout: &out, Stdin: strings.NewReader(tC.input)})
+			err := vm.Execute(context.TODO(), tC.instrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tC.output {
+				t.Fatalf("%q != %q", out.String(), tC.output)
+			}
+		})
+	}
+}
+
+// valueReader is not comparable, so the vm must not compare Stdin values.
+type valueReader struct {
+	parts []string
+	r     *strings.Reader
+}
+
+func (v valueReader) Read(p []byte) (int, error) {
+	return v.r.Read(p)
+}
+
+func TestSetStdin(t *testing.T) {
+	var out bytes.Buffer
+	vm := NewVM(Options{Stdout: &out, Stdin: valueReader{r: strings.NewReader("first\nsecond\n")}})
+	instrs := []i.Instruction{i.Read(), i.Read(), i.StringConcat(), i.Print()}
+	if err := vm.Execute(context.TODO(), instrs); err != nil {
+		t.Fatal(err)
+	}
+	vm.SetStdin(strings.NewReader("third\n"))
+	if err := vm.Execute(context.TODO(), []i.Instruction{i.Read(), i.Print()}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "first\nsecond\nthird\n" {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+}
+
+func TestNative(t *testing.T) {
+	vm := NewVM(Options{})
+	_, err := vm.RegisterNative("greet", []byte{object.TAG_STRING, object.TAG_I32}, []byte{object.TAG_STRING},
+		func(ctx context.Context, vm *CVM, args []object.CVMObject) ([]object.CVMObject, error) {
+			name, err := object.ValueString(args[0])
+			if err != nil {
+				return nil, err
+			}
+			n, err := object.ValueI32(args[1])
+			if err != nil {
+				return nil, err
+			}
+			return []object.CVMObject{obj(object.CreateString(strings.Repeat("hi "+name+"!", int(n))))}, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail, err := vm.RegisterNative("fail", nil, nil, func(ctx context.Context, vm *CVM, args []object.CVMObject) ([]object.CVMObject, error) {
+		return nil, errors.New("boom")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.RegisterNative("fail", nil, nil, nil); err == nil {
+		t.Fatal("expected duplicate registration error")
+	}
+	if ind, ok := vm.NativeIndex("fail"); !ok || ind != fail {
+		t.Fatalf("native fail not found")
+	}
+
+	err = vm.Execute(context.TODO(), []i.Instruction{
+		i.StringLoad("bob"),
+		i.I32Load(2),
+		i.NativeCall(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := obj(object.CreateString("hi bob!hi bob!")); !bytes.Equal(object.Bytes(vm.Stack[0]), object.Bytes(res)) {
+		t.Fatalf("%v != %v", vm.Stack[0], res)
+	}
+
+	testCases := []struct {
+		desc   string
+		instrs []i.Instruction
+		kind   ErrorKind
+	}{
+		{desc: "test argument tags", instrs: []i.Instruction{i.I32Load(2), i.I32Load(2), i.NativeCall(0)}, kind: ERR_TYPE_MISMATCH},
+		{desc: "test argument count", instrs: []i.Instruction{i.I32Load(2), i.NativeCall(0)}, kind: ERR_STACK_UNDERFLOW},
+		{desc: "test unknown native", instrs: []i.Instruction{i.NativeCall(7)}, kind: ERR_UNKNOWN_NATIVE},
+		{desc: "test native error", instrs: []i.Instruction{i.NativeCall(fail)}, kind: ERR_NATIVE},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm.SP = 0
+			err := vm.Execute(context.TODO(), tC.instrs)
+			var vmErr *VMError
+			if !errors.As(err, &vmErr) || vmErr.Kind != tC.kind {
+				t.Fatalf("expected %s, got %v", tC.kind, err)
+			}
+		})
+	}
+}
+
+func TestStruct(t *testing.T) {
+	mixed := i.StructNew(object.TAG_STRING, object.TAG_I32, object.TAG_LIST, object.TAG_BOOL, object.TAG_STRUCT)
+	testCases := []struct {
+		desc   string
+		instrs []i.Instruction
+		result object.CVMObject
+	}{
+		{
+			desc: "test struct get default",
+			instrs: []i.Instruction{
+				mixed,
+				i.I32Load(3),
+				i.StructGet(),
+			},
+			result: obj(object.CreateBool(false)),
+		},
+		{
+			desc: "test struct set after string",
+			instrs: []i.Instruction{
+				mixed,
+				i.I32Load(0),
+				i.StringLoad("hello"),
+				i.StructSet(),
+				i.I32Load(1),
+				i.I32Load(42),
+				i.StructSet(),
+				i.I32Load(1),
+				i.StructGet(),
+			},
+			result: obj(object.CreateI32(42)),
+		},
+		{
+			desc: "test struct set keeps other fields",
+			instrs: []i.Instruction{
+				mixed,
+				i.I32Load(3),
+				i.BoolLoad(true),
+				i.StructSet(),
+				i.I32Load(0),
+				i.StringLoad("hello"),
+				i.StructSet(),
+				i.I32Load(3),
+				i.StructGet(),
+			},
+			result: obj(object.CreateBool(true)),
+		},
+		{
+			desc: "test nested struct",
+			instrs: []i.Instruction{
+				mixed,
+				i.I32Load(4),
+				i.StructNew(object.TAG_F32, object.TAG_STRING),
+				i.I32Load(1),
+				i.StringLoad("inner"),
+				i.StructSet(),
+				i.StructSet(),
+				i.I32Load(3),
+				i.BoolLoad(true),
+				i.StructSet(),
+				i.I32Load(4),
+				i.StructGet(),
+				i.I32Load(1),
+				i.StructGet(),
+			},
+			result: obj(object.CreateString("inner")),
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm := CVM{}
+			err := vm.Execute(context.TODO(), tC.instrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(object.Bytes(vm.Stack[0]), object.Bytes(tC.result)) {
+				t.Fatalf("%v != %v", vm.Stack[0], tC.result)
+			}
+		})
+	}
+
+	vm := CVM{}
+	err := vm.Execute(context.TODO(), []i.Instruction{mixed, i.I32Load(1), i.StringLoad("nope"), i.StructSet()})
+	var vmErr *VMError
+	if !errors.As(err, &vmErr) || vmErr.Kind != ERR_TYPE_MISMATCH {
+		t.Fatalf("expected type mismatch, got %v", err)
+	}
+}
+
+func TestStructTypes(t *testing.T) {
+	vm := CVM{}
+	err := vm.ExecuteModule(context.TODO(), &i.Module{
+		Types: []i.TypeDecl{
+			{Name: "Point", Fields: []i.Field{{Name: "x", Tag: object.TAG_I32}, {Name: "y", Tag: object.TAG_I32}}},
+			{Name: "Label", Fields: []i.Field{{Name: "at", Tag: object.TAG_STRUCT}, {Name: "text", Tag: object.TAG_STRING}}},
+		},
+		Code: []i.Instruction{
+			i.StructMake(1),
+			i.I32Load(0),
+			i.StructMake(0),
+			i.I32Load(0),
+			i.I32Load(1),
+			i.StructSet(),
+			i.I32Load(1),
+			i.I32Load(2),
+			i.StructSet(),
+			i.StructSet(),
+			i.I32Load(1),
+			i.StringLoad("origin"),
+			i.StructSet(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	str, err := object.String(vm.Stack[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `Label{at: Point{x: 1, y: 2}, text: "origin"}`; str != want {
+		t.Fatalf("%s != %s", str, want)
+	}
+
+	err = vm.Execute(context.TODO(), []i.Instruction{i.StructMake(5)})
+	var vmErr *VMError
+	if !errors.As(err, &vmErr) || vmErr.Kind != ERR_UNKNOWN_TYPE {
+		t.Fatalf("expected unknown type, got %v", err)
+	}
+}
+
+func TestPrintStruct(t *testing.T) {
+	src := `
+.type Point x:i32 y:i32
+	struct.make Point
+	i32.load    Point.x
+	i32.load    1
+	struct.set
+	i32.load    Point.y
+	i32.load    2
+	struct.set
+	new
+	load        $0
+	println
+	load        $0
+	to_string
+	println
+`
+	m, err := assembler.AssembleModule("", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify(m.Code); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	vm := &CVM{Stdout: &out}
+	if err := vm.ExecuteModule(context.TODO(), m); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Point{x: 1, y: 2}\nPoint{x: 1, y: 2}\n"; out.String() != want {
+		t.Fatalf("%q != %q", out.String(), want)
+	}
+}
+
+func TestMap(t *testing.T) {
+	keys, err := object.CreateList(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.Data[0] = object.TAG_STRING
+	for ind, key := range []string{"a", "b"} {
+		keys, err = object.InsertList(keys, obj(object.CreateI32(int32(ind))), obj(object.CreateString(key)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	set := func(key string, val int32) []i.Instruction {
+		return []i.Instruction{i.StringLoad(key), i.I32Load(val), i.MapSet()}
+	}
+	ab := func(tail ...i.Instruction) []i.Instruction {
+		res := []i.Instruction{i.MapNew(object.TAG_STRING, object.TAG_I32)}
+		res = append(res, set("b", 2)...)
+		res = append(res, set("a", 1)...)
+		return append(res, tail...)
+	}
+	testCases := []struct {
+		desc   string
+		instrs []i.Instruction
+		result object.CVMObject
+	}{
+		{
+			desc:   "test map get",
+			instrs: ab(i.StringLoad("b"), i.MapGet()),
+			result: obj(object.CreateI32(2)),
+		},
+		{
+			desc:   "test map overwrite",
+			instrs: append(ab(set("a", 5)...), i.MapLen()),
+			result: obj(object.CreateI32(2)),
+		},
+		{
+			desc:   "test map delete",
+			instrs: ab(i.StringLoad("a"), i.MapDelete(), i.StringLoad("a"), i.MapHas()),
+			result: obj(object.CreateBool(false)),
+		},
+		{
+			desc:   "test map delete missing",
+			instrs: ab(i.StringLoad("c"), i.MapDelete(), i.MapLen()),
+			result: obj(object.CreateI32(2)),
+		},
+		{
+			desc:   "test map keys are sorted",
+			instrs: ab(i.MapKeys()),
+			result: keys,
+		},
+		{
+			desc:   "test map format",
+			instrs: append([]i.Instruction{i.StringLoad("%.")}, ab(i.I32Load(1), i.StringFormat())...),
+			result: obj(object.CreateString("{ a: 1 b: 2 }")),
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm := CVM{}
+			err := vm.Execute(context.TODO(), tC.instrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(object.Bytes(vm.Stack[0]), object.Bytes(tC.result)) {
+				t.Fatalf("%v != %v", vm.Stack[0], tC.result)
+			}
+		})
+	}
+
+	for _, tC := range []struct {
+		desc   string
+		instrs []i.Instruction
+		kind   ErrorKind
+	}{
+		{desc: "test missing key", instrs: ab(i.StringLoad("c"), i.MapGet()), kind: ERR_KEY_NOT_FOUND},
+		{desc: "test key tag", instrs: ab(i.I32Load(1), i.MapHas()), kind: ERR_TYPE_MISMATCH},
+		{desc: "test value tag", instrs: ab(i.StringLoad("c"), i.BoolLoad(true), i.MapSet()), kind: ERR_TYPE_MISMATCH},
+	} {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm := CVM{}
+			err := vm.Execute(context.TODO(), tC.instrs)
+			var vmErr *VMError
+			if !errors.As(err, &vmErr) || vmErr.Kind != tC.kind {
+				t.Fatalf("expected %s, got %v", tC.kind, err)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		instrs []i.Instruction
+		result object.CVMObject
+	}{
+		{
+			desc: "test string length counts bytes",
+			instrs: []i.Instruction{
+				i.StringLoad("héllo wörld"),
+				i.StringLength(),
+			},
+			result: obj(object.CreateI32(13)),
+		},
+		{
+			desc: "test string rune length",
+			instrs: []i.Instruction{
+				i.StringLoad("héllo wörld"),
+				i.StringRuneLength(),
+			},
+			result: obj(object.CreateI32(11)),
+		},
+		{
+			desc: "test string slice",
+			instrs: []i.Instruction{
+				i.StringLoad("héllo wörld"),
+				i.I32Load(0),
+				i.I32Load(6),
+				i.StringSlice(),
+			},
+			result: obj(object.CreateString("héllo")),
+		},
+		{
+			desc: "test string rune slice",
+			instrs: []i.Instruction{
+				i.StringLoad("héllo wörld"),
+				i.I32Load(6),
+				i.I32Load(11),
+				i.StringRuneSlice(),
+			},
+			result: obj(object.CreateString("wörld")),
+		},
+		{
+			desc: "test string index",
+			instrs: []i.Instruction{
+				i.StringLoad("héllo wörld"),
+				i.StringLoad("wö"),
+				i.StringIndex(),
+			},
+			result: obj(object.CreateI32(7)),
+		},
+		{
+			desc: "test string rune index",
+			instrs: []i.Instruction{
+				i.StringLoad("héllo wörld"),
+				i.StringLoad("wö"),
+				i.StringRuneIndex(),
+			},
+			result: obj(object.CreateI32(6)),
+		},
+		{
+			desc: "test string index missing",
+			instrs: []i.Instruction{
+				i.StringLoad("héllo wörld"),
+				i.StringLoad("x"),
+				i.StringIndex(),
+			},
+			result: obj(object.CreateI32(-1)),
+		},
+		{
+			desc: "test string char at",
+			instrs: []i.Instruction{
+				i.StringLoad("héllo wörld"),
+				i.I32Load(1),
+				i.StringCharAt(),
+			},
+			result: obj(object.CreateString("é")),
+		},
+		{
+			desc: "test string rune at",
+			instrs: []i.Instruction{
+				i.StringLoad("héllo wörld"),
+				i.I32Load(7),
+				i.StringRuneAt(),
+			},
+			result: obj(object.CreateString("ö")),
+		},
+		{
+			desc: "test string contains",
+			instrs: []i.Instruction{
+				i.StringLoad("héllo wörld"),
+				i.StringLoad("lo w"),
+				i.StringContains(),
+			},
+			result: obj(object.CreateBool(true)),
+		},
+		{
+			desc: "test string has prefix",
+			instrs: []i.Instruction{
+				i.StringLoad("héllo wörld"),
+				i.StringLoad("hé"),
+				i.StringHasPrefix(),
+			},
+			result: obj(object.CreateBool(true)),
+		},
+		{
+			desc: "test string has suffix",
+			instrs: []i.Instruction{
+				i.StringLoad("héllo wörld"),
+				i.StringLoad("hé"),
+				i.StringHasSuffix(),
+			},
+			result: obj(object.CreateBool(false)),
+		},
+		{
+			desc: "test string replace",
+			instrs: []i.Instruction{
+				i.StringLoad("a-b-c"),
+				i.StringLoad("-"),
+				i.StringLoad("+"),
+				i.StringReplace(),
+			},
+			result: obj(object.CreateString("a+b+c")),
+		},
+		{
+			desc: "test string trim",
+			instrs: []i.Instruction{
+				i.StringLoad("\t hi \n"),
+				i.StringTrim(),
+			},
+			result: obj(object.CreateString("hi")),
+		},
+		{
+			desc: "test string upper",
+			instrs: []i.Instruction{
+				i.StringLoad("héllo wörld"),
+				i.StringUpper(),
+			},
+			result: obj(object.CreateString("HÉLLO WÖRLD")),
+		},
+		{
+			desc: "test string lower",
+			instrs: []i.Instruction{
+				i.StringLoad("ÄB"),
+				i.StringLower(),
+			},
+			result: obj(object.CreateString("äb")),
+		},
+		{
+			desc: "test string join",
+			instrs: []i.Instruction{
+				i.StringLoad("a b c"),
+				i.StringLoad(" "),
+				i.StringSplit(),
+				i.StringLoad(", "),
+				i.StringJoin(),
+			},
+			result: obj(object.CreateString("a, b, c")),
+		},
+		{
+			desc: "test string join empty list",
+			instrs: []i.Instruction{
+				i.ListNew(object.TAG_STRING),
+				i.StringLoad(","),
+				i.StringJoin(),
+			},
+			result: obj(object.CreateString("")),
+		},
+		{
+			desc: "test string compare",
+			instrs: []i.Instruction{
+				i.StringLoad("abc"),
+				i.StringLoad("abd"),
+				i.StringCompare(),
+			},
+			result: obj(object.CreateI32(-1)),
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm := CVM{}
+			err := vm.Execute(context.TODO(), tC.instrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(object.Bytes(vm.Stack[0]), object.Bytes(tC.result)) {
+				t.Fatalf("%v != %v", vm.Stack[0], tC.result)
+			}
+		})
+	}
+
+	for _, tC := range []struct {
+		desc   string
+		instrs []i.Instruction
+	}{
+		{desc: "test slice inside rune", instrs: []i.Instruction{i.StringLoad("é"), i.I32Load(1), i.I32Load(2), i.StringSlice()}},
+		{desc: "test slice reversed", instrs: []i.Instruction{i.StringLoad("abc"), i.I32Load(2), i.I32Load(1), i.StringSlice()}},
+		{desc: "test rune at end", instrs: []i.Instruction{i.StringLoad("abc"), i.I32Load(3), i.StringRuneAt()}},
+		{desc: "test char at negative", instrs: []i.Instruction{i.StringLoad("abc"), i.I32Load(-1), i.StringCharAt()}},
+	} {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm := CVM{}
+			err := vm.Execute(context.TODO(), tC.instrs)
+			var vmErr *VMError
+			if !errors.As(err, &vmErr) || vmErr.Kind != ERR_INDEX_OUT_OF_RANGE {
+				t.Fatalf("expected index out of range, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	format := func(str string, args ...i.Instruction) []i.Instruction {
+		instrs := append([]i.Instruction{i.StringLoad(str)}, args...)
+		return append(instrs, i.I32Load(int32(len(args))), i.StringFormat())
+	}
+	testCases := []struct {
+		desc   string
+		instrs []i.Instruction
+		result string
+	}{
+		{desc: "test legacy placeholder", instrs: format("%. and %.", i.I32Load(1), i.BoolLoad(true)), result: "1 and true"},
+		{desc: "test legacy placeholder before text", instrs: format("%..", i.StringLoad("end")), result: "end."},
+		{desc: "test literal percent", instrs: format("100%% %d%%", i.I32Load(5)), result: "100% 5%"},
+		{desc: "test width", instrs: format("[%5d]", i.I32Load(42)), result: "[   42]"},
+		{desc: "test left alignment", instrs: format("[%-5d]", i.I32Load(42)), result: "[42   ]"},
+		{desc: "test zero padding", instrs: format("[%05d]", i.I32Load(-42)), result: "[-0042]"},
+		{desc: "test plus sign", instrs: format("%+d", i.I64Load(7)), result: "+7"},
+		{desc: "test radix", instrs: format("%x %X %o %b %#x", i.I32Load(255), i.I32Load(255), i.I32Load(8), i.I32Load(5), i.I32Load(255)), result: "ff FF 10 101 0xff"},
+		{desc: "test precision", instrs: format("%.2f", i.F32Load(3.14159)), result: "3.14"},
+		{desc: "test width and precision", instrs: format("[%8.3f]", i.F64Load(2.5)), result: "[   2.500]"},
+		{desc: "test exponent", instrs: format("%.1e", i.F64Load(1500)), result: "1.5e+03"},
+		{desc: "test nan", instrs: format("[%5.2f]", i.F32Load(float32(math.NaN()))), result: "[  NaN]"},
+		{desc: "test strings", instrs: format("%s %q [%-4s] %.3s", i.StringLoad("a"), i.StringLoad("b\n"), i.StringLoad("c"), i.StringLoad("abcdef")), result: "a \"b\\n\" [c   ] abc"},
+		{desc: "test s on non-strings", instrs: format("%s", i.I32Load(3)), result: "3"},
+		{desc: "test bool", instrs: format("%t", i.BoolLoad(false)), result: "false"},
+		{desc: "test value", instrs: format("%v-%v", i.I32Load(1), i.StringLoad("x")), result: "1-x"},
+		{desc: "test positional arguments", instrs: format("%[2]d %[1]d %d", i.I32Load(1), i.I32Load(2)), result: "2 1 2"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm := CVM{}
+			err := vm.Execute(context.TODO(), tC.instrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := object.ValueString(vm.Stack[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res != tC.result {
+				t.Fatalf("%q != %q", res, tC.result)
+			}
+		})
+	}
+
+	for _, tC := range []struct {
+		desc   string
+		instrs []i.Instruction
+		kind   ErrorKind
+	}{
+		{desc: "test verb type mismatch", instrs: format("%d", i.StringLoad("a")), kind: ERR_TYPE_MISMATCH},
+		{desc: "test quote non-string", instrs: format("%q", i.I32Load(1)), kind: ERR_TYPE_MISMATCH},
+		{desc: "test missing argument", instrs: format("%d %d", i.I32Load(1)), kind: ERR_RUNTIME},
+		{desc: "test unused argument", instrs: format("%d", i.I32Load(1), i.I32Load(2)), kind: ERR_RUNTIME},
+		{desc: "test unknown verb", instrs: format("%y", i.I32Load(1)), kind: ERR_RUNTIME},
+		{desc: "test trailing percent", instrs: format("%"), kind: ERR_RUNTIME},
+	} {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm := CVM{}
+			err := vm.Execute(context.TODO(), tC.instrs)
+			var vmErr *VMError
+			if !errors.As(err, &vmErr) || vmErr.Kind != tC.kind {
+				t.Fatalf("expected %v, got %v", tC.kind, err)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	list := func(tag byte, items ...object.CVMObject) object.CVMObject {
+		res := obj(object.CreateList(nil))
+		res.Data[0] = tag
+		for _, item := range items {
+			res = obj(object.AppendList(res, item))
+		}
+		return res
+	}
+	i32s := func(vals ...int32) []i.Instruction {
+		instrs := []i.Instruction{i.ListNew(object.TAG_I32)}
+		for _, val := range vals {
+			instrs = append(instrs, i.I32Load(val), i.ListAppend())
+		}
+		return instrs
+	}
+	strs := func(vals ...string) []i.Instruction {
+		instrs := []i.Instruction{i.ListNew(object.TAG_STRING)}
+		for _, val := range vals {
+			instrs = append(instrs, i.StringLoad(val), i.ListAppend())
+		}
+		return instrs
+	}
+	seq := func(parts ...[]i.Instruction) []i.Instruction {
+		var instrs []i.Instruction
+		for _, part := range parts {
+			instrs = append(instrs, part...)
+		}
+		return instrs
+	}
+	i32 := func(val int32) object.CVMObject { return obj(object.CreateI32(val)) }
+	str := func(val string) object.CVMObject { return obj(object.CreateString(val)) }
+	point := obj(object.CreateNamedStruct("Point", []string{"x", "y"}, []byte{object.TAG_I32, object.TAG_STRING}))
+	testCases := []struct {
+		desc   string
+		instrs []i.Instruction
+		result object.CVMObject
+	}{
+		{desc: "test append", instrs: i32s(1, 2, 3), result: list(object.TAG_I32, i32(1), i32(2), i32(3))},
+		{desc: "test get string", instrs: seq(strs("a", "bc", "def"), []i.Instruction{i.I32Load(2), i.ListGet()}), result: str("def")},
+		{desc: "test insert string", instrs: seq(strs("a", "c"), []i.Instruction{i.I32Load(1), i.StringLoad("b"), i.ListInsert()}), result: list(object.TAG_STRING, str("a"), str("b"), str("c"))},
+		{desc: "test remove string", instrs: seq(strs("a", "b", "c"), []i.Instruction{i.I32Load(1), i.ListRemove()}), result: list(object.TAG_STRING, str("a"), str("c"))},
+		{desc: "test replace string", instrs: seq(strs("a", "b"), []i.Instruction{i.I32Load(0), i.StringLoad("long"), i.ListReplace()}), result: list(object.TAG_STRING, str("long"), str("b"))},
+		{desc: "test pop item", instrs: seq(strs("a", "b"), []i.Instruction{i.ListPop()}), result: str("b")},
+		{desc: "test pop list", instrs: seq(strs("a", "b"), []i.Instruction{i.ListPop(), i.Pop()}), result: list(object.TAG_STRING, str("a"))},
+		{desc: "test slice", instrs: seq(i32s(1, 2, 3, 4), []i.Instruction{i.I32Load(1), i.I32Load(3), i.ListSlice()}), result: list(object.TAG_I32, i32(2), i32(3))},
+		{desc: "test empty slice", instrs: seq(strs("a"), []i.Instruction{i.I32Load(1), i.I32Load(1), i.ListSlice()}), result: list(object.TAG_STRING)},
+		{desc: "test concat", instrs: seq(strs("a"), strs("b", "c"), []i.Instruction{i.ListConcat()}), result: list(object.TAG_STRING, str("a"), str("b"), str("c"))},
+		{desc: "test reverse", instrs: seq(strs("a", "bb", "ccc"), []i.Instruction{i.ListReverse()}), result: list(object.TAG_STRING, str("ccc"), str("bb"), str("a"))},
+		{desc: "test index", instrs: seq(strs("a", "b", "b"), []i.Instruction{i.StringLoad("b"), i.ListIndex()}), result: i32(1)},
+		{desc: "test index missing", instrs: seq(i32s(1, 2), []i.Instruction{i.I32Load(3), i.ListIndex()}), result: i32(-1)},
+		{desc: "test contains", instrs: seq(i32s(1, 2), []i.Instruction{i.I32Load(2), i.ListContains()}), result: obj(object.CreateBool(true))},
+		{desc: "test sort i32", instrs: seq(i32s(3, -1, 2, 0), []i.Instruction{i.ListSort()}), result: list(object.TAG_I32, i32(-1), i32(0), i32(2), i32(3))},
+		{desc: "test sort string", instrs: seq(strs("pear", "apple", "fig"), []i.Instruction{i.ListSort()}), result: list(object.TAG_STRING, str("apple"), str("fig"), str("pear"))},
+		{
+			desc: "test sort f64",
+			instrs: []i.Instruction{
+				i.ListNew(object.TAG_F64),
+				i.F64Load(2.5), i.ListAppend(),
+				i.F64Load(-1), i.ListAppend(),
+				i.F64Load(0.5), i.ListAppend(),
+				i.ListSort(),
+			},
+			result: list(object.TAG_F64, obj(object.CreateF64(-1)), obj(object.CreateF64(0.5)), obj(object.CreateF64(2.5))),
+		},
+		{
+			desc: "test nested lists",
+			instrs: []i.Instruction{
+				i.ListNew(object.TAG_LIST),
+				i.ListNew(object.TAG_I32), i.ListAppend(),
+				i.ListNew(object.TAG_I32), i.I32Load(7), i.ListAppend(), i.ListAppend(),
+				i.I32Load(1), i.ListGet(),
+				i.I32Load(0), i.ListGet(),
+			},
+			result: i32(7),
+		},
+		{
+			desc: "test struct items",
+			instrs: []i.Instruction{
+				i.ListNew(object.TAG_STRUCT),
+				i.StructNew(object.TAG_I32, object.TAG_STRING), i.ListAppend(),
+				i.StructNew(object.TAG_I32, object.TAG_STRING), i.I32Load(0), i.I32Load(5), i.StructSet(), i.ListAppend(),
+				i.I32Load(1), i.ListGet(),
+				i.I32Load(0), i.StructGet(),
+			},
+			result: i32(5),
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm := CVM{}
+			err := vm.Execute(context.TODO(), tC.instrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			top := vm.Stack[vm.SP-1]
+			if !bytes.Equal(object.Bytes(top), object.Bytes(tC.result)) {
+				t.Fatalf("%v != %v", top, tC.result)
+			}
+		})
+	}
+
+	structs := list(object.TAG_STRUCT, point, point)
+	if res, err := object.String(structs); err != nil || !strings.Contains(res, `Point{x: 0, y: ""}`) {
+		t.Fatalf("struct list renders as %q, %v", res, err)
+	}
+	if size, err := object.Size(structs); err != nil || size != len(object.Bytes(structs)) {
+		t.Fatalf("struct list size %d != %d, %v", size, len(object.Bytes(structs)), err)
+	}
+
+	for _, tC := range []struct {
+		desc   string
+		instrs []i.Instruction
+		kind   ErrorKind
+	}{
+		{desc: "test pop empty", instrs: []i.Instruction{i.ListNew(object.TAG_I32), i.ListPop()}, kind: ERR_INDEX_OUT_OF_RANGE},
+		{desc: "test slice past end", instrs: seq(i32s(1), []i.Instruction{i.I32Load(0), i.I32Load(2), i.ListSlice()}), kind: ERR_INDEX_OUT_OF_RANGE},
+		{desc: "test append wrong item", instrs: seq(i32s(), []i.Instruction{i.StringLoad("a"), i.ListAppend()}), kind: ERR_TYPE_MISMATCH},
+		{desc: "test concat different items", instrs: seq(i32s(), strs(), []i.Instruction{i.ListConcat()}), kind: ERR_TYPE_MISMATCH},
+		{desc: "test sort bool", instrs: []i.Instruction{i.ListNew(object.TAG_BOOL), i.ListSort()}, kind: ERR_TYPE_MISMATCH},
+		{desc: "test get negative", instrs: seq(i32s(1), []i.Instruction{i.I32Load(-1), i.ListGet()}), kind: ERR_INDEX_OUT_OF_RANGE},
+	} {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm := CVM{}
+			err := vm.Execute(context.TODO(), tC.instrs)
+			var vmErr *VMError
+			if !errors.As(err, &vmErr) || vmErr.Kind != tC.kind {
+				t.Fatalf("expected %v, got %v", tC.kind, err)
+			}
+		})
+	}
+}
+
+func TestRef(t *testing.T) {
+	list := func(tag byte, items ...object.CVMObject) object.CVMObject {
+		res := obj(object.CreateList(nil))
+		res.Data[0] = tag
+		for _, item := range items {
+			res = obj(object.AppendList(res, item))
+		}
+		return res
+	}
+	i32 := func(val int32) object.CVMObject { return obj(object.CreateI32(val)) }
+	// slot $0 holds a reference to a list of 1 2 3
+	ref123 := []i.Instruction{
+		i.ListNew(object.TAG_I32),
+		i.RefNew(),
+		i.New(),
+		i.Load(0), i.I32Load(1), i.RefAppend(),
+		i.Load(0), i.I32Load(2), i.RefAppend(),
+		i.Load(0), i.I32Load(3), i.RefAppend(),
+	}
+	seq := func(instrs ...i.Instruction) []i.Instruction {
+		return append(append([]i.Instruction{}, ref123...), instrs...)
+	}
+	testCases := []struct {
+		desc   string
+		instrs []i.Instruction
+		result object.CVMObject
+	}{
+		{desc: "test append", instrs: seq(i.Load(0), i.RefGet()), result: list(object.TAG_I32, i32(1), i32(2), i32(3))},
+		{desc: "test length", instrs: seq(i.Load(0), i.RefLength()), result: i32(3)},
+		{desc: "test at", instrs: seq(i.Load(0), i.I32Load(1), i.RefAt()), result: i32(2)},
+		{
+			desc:   "test shared reference",
+			instrs: seq(i.Load(0), i.New(), i.Load(1), i.I32Load(4), i.RefAppend(), i.Load(0), i.RefGet()),
+			result: list(object.TAG_I32, i32(1), i32(2), i32(3), i32(4)),
+		},
+		{
+			desc:   "test copy is independent",
+			instrs: seq(i.Load(0), i.RefCopy(), i.New(), i.Load(1), i.I32Load(4), i.RefAppend(), i.Load(0), i.RefGet()),
+			result: list(object.TAG_I32, i32(1), i32(2), i32(3)),
+		},
+		{
+			desc:   "test get is a snapshot",
+			instrs: seq(i.Load(0), i.RefGet(), i.Load(0), i.I32Load(0), i.RefRemove()),
+			result: list(object.TAG_I32, i32(1), i32(2), i32(3)),
+		},
+		{
+			desc:   "test insert remove replace",
+			instrs: seq(i.Load(0), i.I32Load(0), i.I32Load(0), i.RefInsert(), i.Load(0), i.I32Load(2), i.RefRemove(), i.Load(0), i.I32Load(2), i.I32Load(9), i.RefReplace(), i.Load(0), i.RefGet()),
+			result: list(object.TAG_I32, i32(0), i32(1), i32(9)),
+		},
+		{
+			desc:   "test set",
+			instrs: seq(i.Load(0), i.ListNew(object.TAG_I32), i.RefSet(), i.Load(0), i.RefLength()),
+			result: i32(0),
+		},
+		{
+			desc: "test string append",
+			instrs: []i.Instruction{
+				i.StringLoad("ab"), i.RefNew(), i.New(),
+				i.Load(0), i.StringLoad("cd"), i.RefAppend(),
+				i.Load(0), i.StringLoad("é"), i.RefAppend(),
+				i.Load(0), i.RefGet(),
+			},
+			result: obj(object.CreateString("abcdé")),
+		},
+		{
+			desc: "test struct field",
+			instrs: []i.Instruction{
+				i.StructNew(object.TAG_I32, object.TAG_STRING), i.RefNew(), i.New(),
+				i.Load(0), i.I32Load(1), i.StringLoad("x"), i.RefReplace(),
+				i.Load(0), i.I32Load(1), i.RefAt(),
+			},
+			result: obj(object.CreateString("x")),
+		},
+		{
+			desc: "test map entries",
+			instrs: []i.Instruction{
+				i.MapNew(object.TAG_STRING, object.TAG_I32), i.RefNew(), i.New(),
+				i.Load(0), i.StringLoad("a"), i.I32Load(1), i.RefReplace(),
+				i.Load(0), i.StringLoad("b"), i.I32Load(2), i.RefReplace(),
+				i.Load(0), i.StringLoad("a"), i.RefRemove(),
+				i.Load(0), i.StringLoad("b"), i.RefAt(),
+				i.Load(0), i.RefLength(),
+				i.I32Add(),
+			},
+			result: i32(3),
+		},
+		{
+			desc: "test references survive returns",
+			instrs: []i.Instruction{
+				i.FuncCall(3, 0),
+				i.RefGet(),
+				i.Halt(),
+				i.StringLoad("kept"),
+				i.RefNew(),
+				i.FuncRet(1),
+			},
+			result: obj(object.CreateString("kept")),
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm := CVM{}
+			err := vm.Execute(context.TODO(), tC.instrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			top := vm.Stack[vm.SP-1]
+			if !bytes.Equal(object.Bytes(top), object.Bytes(tC.result)) {
+				t.Fatalf("%v != %v", top, tC.result)
+			}
+		})
+	}
+
+	for _, tC := range []struct {
+		desc   string
+		opts   Options
+		instrs []i.Instruction
+		kind   ErrorKind
+	}{
+		{desc: "test object heap full", opts: Options{ObjectLimit: 1}, instrs: []i.Instruction{i.I32Load(1), i.RefNew(), i.I32Load(2), i.RefNew()}, kind: ERR_HEAP_OVERFLOW},
+		{desc: "test append wrong item", instrs: seq(i.Load(0), i.StringLoad("a"), i.RefAppend()), kind: ERR_TYPE_MISMATCH},
+		{desc: "test set wrong type", instrs: seq(i.Load(0), i.I32Load(1), i.RefSet()), kind: ERR_TYPE_MISMATCH},
+		{desc: "test at out of range", instrs: seq(i.Load(0), i.I32Load(3), i.RefAt()), kind: ERR_INDEX_OUT_OF_RANGE},
+		{desc: "test append to i32", instrs: []i.Instruction{i.I32Load(1), i.RefNew(), i.I32Load(2), i.RefAppend()}, kind: ERR_TYPE_MISMATCH},
+	} {
+		t.Run(tC.desc, func(t *testing.T) {
+			vm := NewVM(tC.opts)
+			err := vm.Execute(context.TODO(), tC.instrs)
+			var vmErr *VMError
+			if !errors.As(err, &vmErr) || vmErr.Kind != tC.kind {
+				t.Fatalf("expected %v, got %v", tC.kind, err)
+			}
+		})
+	}
+}
+
+func TestGC(t *testing.T) {
+	i32 := func(val int32) object.CVMObject { return obj(object.CreateI32(val)) }
+	// allocate count objects and drop them right away
+	garbage := func(count int32) []i.Instruction {
+		return []i.Instruction{
+			i.I32Load(count), i.New(),
+			i.Load(0), i.I32Load(0), i.I32Gt(), i.JumpNC(14),
+			i.I32Load(7), i.RefNew(), i.Pop(),
+			i.Load(0), i.I32Load(1), i.I32Sub(), i.Save(0),
+			i.Jump(2),
+		}
+	}
+
+	vm := NewVM(Options{ObjectLimit: 8})
+	if err := vm.Execute(context.TODO(), garbage(100)); err != nil {
+		t.Fatal(err)
+	}
+	if vm.GC.Collections == 0 || vm.GC.Freed < 92 || len(vm.Objects) > 9 {
+		t.Fatalf("unexpected collector state %+v with %d objects", vm.GC, len(vm.Objects))
+	}
+
+	vm = NewVM(Options{GCThreshold: 4})
+	if err := vm.Execute(context.TODO(), garbage(100)); err != nil {
+		t.Fatal(err)
+	}
+	if vm.GC.Collections < 20 || len(vm.Objects) > 5 {
+		t.Fatalf("unexpected collector state %+v with %d objects", vm.GC, len(vm.Objects))
+	}
+
+	// 3 is garbage, $0 refers to 1 and the stack to a list holding a reference to 2
+	vm = &CVM{}
+	err := vm.Execute(context.TODO(), []i.Instruction{
+		i.I32Load(3), i.RefNew(), i.Pop(),
+		i.I32Load(1), i.RefNew(), i.New(),
+		i.ListNew(object.TAG_REF), i.I32Load(2), i.RefNew(), i.ListAppend(), i.RefNew(),
+		i.GC(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.GC.Collections != 1 || vm.GC.Freed != 1 || vm.GC.Live != 3 {
+		t.Fatalf("unexpected collector state %+v", vm.GC)
+	}
+	err = vm.Execute(context.TODO(), []i.Instruction{
+		i.RefGet(), i.I32Load(0), i.ListGet(), i.RefGet(),
+		i.Load(0), i.RefGet(),
+		i.I32Load(4), i.RefNew(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ind, want := range []object.CVMObject{i32(2), i32(1), obj(object.CreateRef(1))} {
+		if !bytes.Equal(object.Bytes(vm.Stack[ind]), object.Bytes(want)) {
+			t.Fatalf("stack %d: %v != %v", ind, vm.Stack[ind], want)
+		}
+	}
+	if len(vm.Objects) != 5 {
+		t.Fatalf("freed handle not reused, have %d objects", len(vm.Objects))
+	}
+}
+
+func TestSlotReuse(t *testing.T) {
+	// every iteration stores a reference in a new slot and frees it again, more often than the heap has slots
+	loop := `
+	i32.load     0
+	new
+loop:
+	list.new     i32
+	ref.new
+	new
+	free         $1
+	gc
+	load         $0
+	i32.load     1
+	i32.add
+	save         $0
+	load         $0
+	i32.load     5000
+	i32.lt
+	jumpc        loop
+	load         $0
+`
+	instrs, err := assembler.Assemble(loop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := &CVM{}
+	if err := vm.Execute(context.TODO(), instrs); err != nil {
+		t.Fatal(err)
+	}
+	if vm.HP != 2 || !bytes.Equal(object.Bytes(vm.Stack[0]), object.Bytes(obj(object.CreateI32(5000)))) {
+		t.Fatalf("unexpected heap %d and result %v", vm.HP, vm.Stack[0])
+	}
+
+	// f must not place its local in the freed global slot, the slot is reused once f returned
+	frames := `
+	i32.load     1
+	new
+	i32.load     2
+	new
+	free         $0
+	free         $0
+	func.call    f 0
+	i32.load     4
+	new
+	i32.load     5
+	new
+	halt
+f:
+	i32.load     3
+	new
+	local.load   0
+	func.ret     1
+`
+	instrs, err = assembler.Assemble(frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm = &CVM{}
+	if err := vm.Execute(context.TODO(), instrs); err != nil {
+		t.Fatal(err)
+	}
+	if vm.HP != 3 || vm.SP != 1 {
+		t.Fatalf("unexpected heap %d and stack %d", vm.HP, vm.SP)
+	}
+	for ind, val := range []int32{4, 2, 5} {
+		if !bytes.Equal(object.Bytes(vm.Heap[ind]), object.Bytes(obj(object.CreateI32(val)))) {
+			t.Fatalf("slot %d: %v != (i32)%d", ind, vm.Heap[ind], val)
+		}
+	}
+	if !bytes.Equal(object.Bytes(vm.Stack[0]), object.Bytes(obj(object.CreateI32(3)))) {
+		t.Fatalf("%v != (i32)3", vm.Stack[0])
+	}
+}
+
+func TestFunc(t *testing.T) {
+	// apply calls every function of a list with 7 and 3
+	apply := `
+	list.new     func
+	func.load    add 2 1
+	list.append
+	func.load    sub 2 1
+	list.append
+	func.load    max 2 1
+	list.append
+	new
+	i32.load     0
+	new
+loop:
+	load         $1
+	load         $0
+	list.length
+	i32.lt
+	jumpnc       end
+	i32.load     7
+	i32.load     3
+	load         $0
+	load         $1
+	list.get
+	func.call_indirect 2 1
+	println
+	load         $1
+	i32.load     1
+	i32.add
+	save         $1
+	jump         loop
+end:
+	halt
+add:
+	i32.add
+	func.ret     1
+sub:
+	i32.sub
+	func.ret     1
+max:
+	new
+	new
+	local.load   0
+	local.load   1
+	i32.lt
+	jumpc        second
+	local.load   0
+	func.ret     1
+second:
+	local.load   1
+	func.ret     1
+`
+	instrs, err := assembler.Assemble(apply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify(instrs); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	vm := &CVM{Stdout: &out}
+	if err := vm.Execute(context.TODO(), instrs); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "10\n4\n7\n" {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+	if str, _ := object.String(vm.Heap[0]); str != "(list.func)[3]{ (func)@28(2:1) (func)@30(2:1) (func)@32(2:1) }" {
+		t.Fatalf("unexpected function list %s", str)
+	}
+
+	testCases := []struct {
+		desc string
+		src  string
+		kind ErrorKind
+	}{
+		{desc: "test argument count", src: "i32.load 1\nfunc.load f 2 1\nfunc.call_indirect 1 1\nhalt\nf: func.ret 1", kind: ERR_ARITY_MISMATCH},
+		{desc: "test result count", src: "func.load f 0 1\nfunc.call_indirect 0 1\nhalt\nf: func.ret 0", kind: ERR_ARITY_MISMATCH},
+		{desc: "test nil function", src: ".type T f:func\nstruct.make T\ni32.load T.f\nstruct.get\nfunc.call_indirect 0 0", kind: ERR_NIL_FUNCTION},
+		{desc: "test not a function", src: "i32.load 1\nfunc.call_indirect 0 0", kind: ERR_TYPE_MISMATCH},
+		{desc: "test argument count beyond stack", src: "i32.load 1\nfunc.call f 5\nhalt\nf: func.ret 0", kind: ERR_STACK_UNDERFLOW},
+		{desc: "test negative argument count", src: "func.load f 0 0\nfunc.call_indirect 0xffffffff 0\nhalt\nf: func.ret 0", kind: ERR_STACK_UNDERFLOW},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			m, err := assembler.AssembleModule("", tC.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = (&CVM{}).ExecuteModule(context.TODO(), m)
+			var vmErr *VMError
+			if !errors.As(err, &vmErr) || vmErr.Kind != tC.kind {
+				t.Fatalf("expected %v, got %v", tC.kind, err)
+			}
+		})
+	}
+}
+
+func TestClosure(t *testing.T) {
+	// next captures its count by value and the total as a shared cell,
+	// the last closure is only reachable from its frame while gc runs
+	counter := `
+	i32.load     0
+	ref.new
+	new
+	func.load    next 0 1
+	i32.load     0
+	i32.load     10
+	load         $0
+	func.closure 3
+	new
+	load         $1
+	func.call_indirect 0 1
+	println
+	load         $1
+	func.call_indirect 0 1
+	println
+	func.load    next 0 1
+	i32.load     0
+	i32.load     5
+	load         $0
+	func.closure 3
+	func.call_indirect 0 1
+	println
+	load         $0
+	ref.get
+	println
+	halt
+next:
+	gc
+	closure.load 0
+	closure.load 1
+	i32.add
+	closure.save 0
+	closure.load 2
+	closure.load 2
+	ref.get
+	closure.load 0
+	i32.add
+	ref.set
+	closure.load 0
+	func.ret     1
+`
+	instrs, err := assembler.Assemble(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify(instrs); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	vm := &CVM{Stdout: &out}
+	if err := vm.Execute(context.TODO(), instrs); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "10\n20\n5\n35\n" {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+	if str, _ := object.String(vm.Heap[1]); str != "(func)@26(0:1)&2" {
+		t.Fatalf("unexpected closure %s", str)
+	}
+	env, err := vm.Deref(obj(object.CreateRef(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if str, _ := object.String(*env); str != "struct{ i32 i32 ref }{ (i32)20 (i32)10 (ref)&1 }" {
+		t.Fatalf("unexpected environment %s", str)
+	}
+
+	testCases := []struct {
+		desc string
+		src  string
+		kind ErrorKind
+	}{
+		{desc: "test plain function", src: "func.load f 0 1\nfunc.call_indirect 0 1\nhalt\nf: closure.load 0\nfunc.ret 1", kind: ERR_NO_CLOSURE},
+		{desc: "test capture out of range", src: "func.load f 0 1\ni32.load 1\nfunc.closure 1\nfunc.call_indirect 0 1\nhalt\nf: closure.load 1\nfunc.ret 1", kind: ERR_INDEX_OUT_OF_RANGE},
+		{desc: "test capture type", src: "func.load f 0 0\ni32.load 1\nfunc.closure 1\nfunc.call_indirect 0 0\nhalt\nf: bool.load true\nclosure.save 0\nfunc.ret 0", kind: ERR_TYPE_MISMATCH},
+		{desc: "test nil function", src: ".type T f:func\nstruct.make T\ni32.load T.f\nstruct.get\nfunc.closure 0", kind: ERR_NIL_FUNCTION},
+		{desc: "test outside of function", src: "closure.load 0", kind: ERR_FRAME_UNDERFLOW},
+		{desc: "test negative capture count", src: "func.load f 0 0\nfunc.closure 0xffffffff\nhalt\nf: func.ret 0", kind: ERR_STACK_UNDERFLOW},
+		{desc: "test capture count beyond stack", src: "func.load f 0 0\nfunc.closure 0x7fffffff\nhalt\nf: func.ret 0", kind: ERR_STACK_UNDERFLOW},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			m, err := assembler.AssembleModule("", tC.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = (&CVM{}).ExecuteModule(context.TODO(), m)
+			var vmErr *VMError
+			if !errors.As(err, &vmErr) || vmErr.Kind != tC.kind {
+				t.Fatalf("expected %v, got %v", tC.kind, err)
+			}
+		})
+	}
+}
+
+func TestTailCall(t *testing.T) {
+	// sum adds n, n-1, ... 1 to acc, far deeper than the frame stack
+	sum := `
+	i32.load     50000
+	i32.load     0
+	func.call    sum 2
+	println
+	halt
+sum:
+	new
+	new
+	local.load   1
+	i32.load     1
+	i32.lt
+	jumpc        done
+	block.block  done
+	local.load   1
+	i32.load     1
+	i32.sub
+	local.load   0
+	local.load   1
+	i32.add
+	func.tailcall sum 2
+done:
+	local.load   0
+	func.ret     1
+`
+	instrs, err := assembler.Assemble(sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify(instrs); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	vm := &CVM{Stdout: &out}
+	if err := vm.Execute(context.TODO(), instrs); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "1250025000\n" {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+	if vm.FP != 0 || vm.SP != 0 || vm.HP != 0 {
+		t.Fatalf("unexpected frame %d, stack %d, heap %d after return", vm.FP, vm.SP, vm.HP)
+	}
+
+	// a closure tail calling its own entry point keeps its captured step
+	steps := `
+	func.load    steps 2 1
+	i32.load     10
+	func.closure 1
+	new
+	i32.load     5000
+	i32.load     0
+	load         $0
+	func.call_indirect 2 1
+	println
+	halt
+steps:
+	new
+	new
+	local.load   1
+	i32.load     1
+	i32.lt
+	jumpc        done
+	local.load   1
+	i32.load     1
+	i32.sub
+	local.load   0
+	closure.load 0
+	i32.add
+	func.tailcall steps 2
+done:
+	local.load   0
+	func.ret     1
+`
+	instrs, err = assembler.Assemble(steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify(instrs); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	vm = &CVM{Stdout: &out}
+	if err := vm.Execute(context.TODO(), instrs); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "50000\n" {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+
+	testCases := []struct {
+		desc string
+		src  string
+		kind ErrorKind
+	}{
+		{desc: "test without tail call", src: strings.Replace(sum, "func.tailcall sum 2", "func.call sum 2\nfunc.ret 1", 1), kind: ERR_FRAME_OVERFLOW},
+		{desc: "test result count", src: "func.load f 0 1\nfunc.call_indirect 0 1\nhalt\nf: func.tailcall g 0\ng: func.ret 0", kind: ERR_ARITY_MISMATCH},
+		{desc: "test argument count", src: "func.call f 0\nhalt\nf: func.tailcall f 1", kind: ERR_STACK_UNDERFLOW},
+		{desc: "test outside of function", src: "func.tailcall f 0\nf: func.ret 0", kind: ERR_FRAME_UNDERFLOW},
+		{desc: "test negative argument count", src: "func.call f 0\nhalt\nf: func.tailcall f 0xffffffff", kind: ERR_STACK_UNDERFLOW},
+		{desc: "test closure calling another function", src: "func.load f 0 1\ni32.load 1\nfunc.closure 1\nfunc.call_indirect 0 1\nhalt\nf: func.tailcall g 0\ng: closure.load 0\nfunc.ret 1", kind: ERR_NO_CLOSURE},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			m, err := assembler.AssembleModule("", tC.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = (&CVM{}).ExecuteModule(context.TODO(), m)
+			var vmErr *VMError
+			if !errors.As(err, &vmErr) || vmErr.Kind != tC.kind {
+				t.Fatalf("expected %v, got %v", tC.kind, err)
+			}
+		})
+	}
+}
+
+func TestLink(t *testing.T) {
+	p, err := Link(countdown(100), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := CVM{}
+	for run := 0; run < 2; run++ {
+		vm.SP = 0
+		if err := vm.Run(context.TODO(), p); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(object.Bytes(vm.Stack[0]), object.Bytes(obj(object.CreateI32(0)))) {
+			t.Fatalf("run %d: %v != (i32)0", run, vm.Stack[0])
+		}
+	}
+}
+
+func TestExecuteRelinks(t *testing.T) {
+	instrs := []i.Instruction{i.I32Load(1), i.I32Load(2), i.I32Add()}
+	vm := CVM{}
+	for run, want := range []int32{3, 3, 4} {
+		if run == 2 {
+			instrs[1] = i.I32Load(3)
+		}
+		vm.SP = 0
+		if err := vm.Execute(context.TODO(), instrs); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(object.Bytes(vm.Stack[0]), object.Bytes(obj(object.CreateI32(want)))) {
+			t.Fatalf("run %d: %v != (i32)%d", run, vm.Stack[0], want)
+		}
+	}
+}
+
+func TestRunAllocs(t *testing.T) {
+	// the i32 results of fib(15) share a few chunks, comparisons don't allocate at all
+	p, err := Link(fib(15), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := CVM{}
+	allocs := testing.AllocsPerRun(10, func() {
+		vm.SP = 0
+		if err := vm.Run(context.TODO(), p); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 10 {
+		t.Fatalf("%v allocations per run", allocs)
+	}
+	if !bytes.Equal(object.Bytes(vm.Stack[0]), object.Bytes(obj(object.CreateI32(610)))) {
+		t.Fatalf("%v != (i32)610", vm.Stack[0])
+	}
+}
+
+// clampOp clamps the i32 on top of the stack to the range given by its two operands.
+var clampOp = func() byte {
+	err := RegisterOpcode(i.Opcode{
+		Kind:     i.OP_CUSTOM_FIRST,
+		Mnemonic: "i32.clamp",
+		Operands: []i.Operand{i.OPERAND_I32, i.OPERAND_I32},
+		Effect:   i.Effect{Pop: []byte{object.TAG_I32}, Push: []byte{object.TAG_I32}},
+	}, func(ctx context.Context, vm *CVM, instr *i.Instruction) error {
+		lo, err := instr.OperandI32(0)
+		if err != nil {
+			return err
+		}
+		hi, err := instr.OperandI32(1)
+		if err != nil {
+			return err
+		}
+		val, err := vm.Pop(ctx)
+		if err != nil {
+			return err
+		}
+		num, err := object.ValueI32(val)
+		if err != nil {
+			return err
+		}
+		return vm.Push(ctx, obj(object.CreateI32(min(max(num, int32(lo)), int32(hi)))))
+	})
+	if err != nil {
+		panic(err)
+	}
+	return i.OP_CUSTOM_FIRST
+}()
+
+func TestCustomOpcode(t *testing.T) {
+	src := "i32.load 42\ni32.clamp -5 10\ni32.load -42\ni32.clamp -5 10\n"
+	instrs, err := assembler.Assemble(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instrs[1].Kind != clampOp || instrs[1].String() != "i32.clamp    -5 10" {
+		t.Fatalf("unexpected instruction %s", instrs[1].String())
+	}
+	if err := verifier.Verify(instrs); err != nil {
+		t.Fatal(err)
+	}
+	vm := CVM{}
+	if err := vm.Execute(context.TODO(), instrs); err != nil {
+		t.Fatal(err)
+	}
+	for ind, want := range []int32{10, -5} {
+		if !bytes.Equal(object.Bytes(vm.Stack[ind]), object.Bytes(obj(object.CreateI32(want)))) {
+			t.Fatalf("%v != (i32)%d", vm.Stack[ind], want)
+		}
+	}
+
+	if err := verifier.Verify([]i.Instruction{{Kind: clampOp, Operands: i.I32Load(1).Operands}}); err == nil {
+		t.Fatal("expected diagnostic for missing operand")
+	}
+	if err := verifier.Verify([]i.Instruction{i.StringLoad("a"), instrs[1]}); err == nil {
+		t.Fatal("expected diagnostic for type mismatch")
+	}
+	err = vm.Execute(context.TODO(), []i.Instruction{i.BoolLoad(true), instrs[1]})
+	var vmErr *VMError
+	if !errors.As(err, &vmErr) || vmErr.Kind != ERR_TYPE_MISMATCH {
+		t.Fatalf("expected type mismatch, got %v", err)
+	}
+	if err := RegisterOpcode(i.Opcode{Kind: clampOp + 1, Mnemonic: "i32.clamp"}, func(context.Context, *CVM, *i.Instruction) error { return nil }); err == nil {
+		t.Fatal("expected error for taken mnemonic")
+	}
+}
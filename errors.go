@@ -0,0 +1,186 @@
+package cvm
+
+import (
+	"bytes"
+	"cvm/instruction"
+	"cvm/object"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrStackUnderflow     = errors.New("stack is empty")
+	ErrStackOverflow      = errors.New("stack overflow")
+	ErrFrameUnderflow     = errors.New("stack frame is empty")
+	ErrFrameOverflow      = errors.New("stack frame overflow")
+	ErrHeapOverflow       = errors.New("heap overflow")
+	ErrInvalidSlot        = errors.New("invalid heap slot")
+	ErrUnknownInstruction = errors.New("unknown instruction")
+	ErrCanceled           = errors.New("execution canceled")
+	ErrFuelExhausted      = errors.New("fuel exhausted")
+	ErrUnknownNative      = errors.New("unknown native")
+	ErrNative             = errors.New("native")
+	ErrUnknownType        = errors.New("unknown type")
+	ErrInvalidOperand     = errors.New("invalid operand")
+	ErrArityMismatch      = errors.New("arity mismatch")
+	ErrNilFunction        = errors.New("nil function")
+	ErrNoClosure          = errors.New("not in a closure")
+)
+
+type ErrorKind byte
+
+const (
+	ERR_RUNTIME ErrorKind = iota
+	ERR_STACK_UNDERFLOW
+	ERR_STACK_OVERFLOW
+	ERR_FRAME_UNDERFLOW
+	ERR_FRAME_OVERFLOW
+	ERR_HEAP_OVERFLOW
+	ERR_INVALID_SLOT
+	ERR_TYPE_MISMATCH
+	ERR_INDEX_OUT_OF_RANGE
+	ERR_UNKNOWN_INSTRUCTION
+	ERR_CANCELED
+	ERR_FUEL_EXHAUSTED
+	ERR_UNKNOWN_NATIVE
+	ERR_NATIVE
+	ERR_UNKNOWN_TYPE
+	ERR_KEY_NOT_FOUND
+	ERR_DIVISION_BY_ZERO
+	ERR_INVALID_OPERAND
+	ERR_ARITY_MISMATCH
+	ERR_NIL_FUNCTION
+	ERR_NO_CLOSURE
+)
+
+var errorKindString = map[ErrorKind]string{
+	ERR_RUNTIME:             "runtime error",
+	ERR_STACK_UNDERFLOW:     "stack underflow",
+	ERR_STACK_OVERFLOW:      "stack overflow",
+	ERR_FRAME_UNDERFLOW:     "frame underflow",
+	ERR_FRAME_OVERFLOW:      "frame overflow",
+	ERR_HEAP_OVERFLOW:       "heap overflow",
+	ERR_INVALID_SLOT:        "invalid heap slot",
+	ERR_TYPE_MISMATCH:       "type mismatch",
+	ERR_INDEX_OUT_OF_RANGE:  "index out of range",
+	ERR_UNKNOWN_INSTRUCTION: "unknown instruction",
+	ERR_CANCELED:            "canceled",
+	ERR_FUEL_EXHAUSTED:      "fuel exhausted",
+	ERR_UNKNOWN_NATIVE:      "unknown native",
+	ERR_NATIVE:              "native error",
+	ERR_UNKNOWN_TYPE:        "unknown type",
+	ERR_KEY_NOT_FOUND:       "key not found",
+	ERR_DIVISION_BY_ZERO:    "division by zero",
+	ERR_INVALID_OPERAND:     "invalid operand",
+	ERR_ARITY_MISMATCH:      "arity mismatch",
+	ERR_NIL_FUNCTION:        "nil function",
+	ERR_NO_CLOSURE:          "not in a closure",
+}
+
+var errorKinds = []struct {
+	err  error
+	kind ErrorKind
+}{
+	{ErrNative, ERR_NATIVE},
+	{ErrStackUnderflow, ERR_STACK_UNDERFLOW},
+	{ErrStackOverflow, ERR_STACK_OVERFLOW},
+	{ErrFrameUnderflow, ERR_FRAME_UNDERFLOW},
+	{ErrFrameOverflow, ERR_FRAME_OVERFLOW},
+	{ErrHeapOverflow, ERR_HEAP_OVERFLOW},
+	{ErrInvalidSlot, ERR_INVALID_SLOT},
+	{object.ErrTypeMismatch, ERR_TYPE_MISMATCH},
+	{object.ErrIndexOutOfRange, ERR_INDEX_OUT_OF_RANGE},
+	{object.ErrKeyNotFound, ERR_KEY_NOT_FOUND},
+	{object.ErrDivisionByZero, ERR_DIVISION_BY_ZERO},
+	{ErrUnknownInstruction, ERR_UNKNOWN_INSTRUCTION},
+	{ErrCanceled, ERR_CANCELED},
+	{ErrFuelExhausted, ERR_FUEL_EXHAUSTED},
+	{ErrUnknownNative, ERR_UNKNOWN_NATIVE},
+	{ErrUnknownType, ERR_UNKNOWN_TYPE},
+	{ErrInvalidOperand, ERR_INVALID_OPERAND},
+	{ErrArityMismatch, ERR_ARITY_MISMATCH},
+	{ErrNilFunction, ERR_NIL_FUNCTION},
+	{ErrNoClosure, ERR_NO_CLOSURE},
+}
+
+func (k ErrorKind) String() string {
+	return errorKindString[k]
+}
+
+// StackEntry is one level of the call stack: the instruction executing in that function.
+type StackEntry struct {
+	IP uint32
+	Op string
+}
+
+// VMError is returned by Execute for every failure inside the program.
+// Stack lists the failing instruction first, followed by the func.call sites of the active functions.
+type VMError struct {
+	IP    uint32
+	Op    string
+	Kind  ErrorKind
+	Err   error
+	Stack []StackEntry
+}
+
+func (e *VMError) Error() string {
+	return fmt.Sprintf("%04d %s: %v", e.IP, e.Op, e.Err)
+}
+
+func (e *VMError) Unwrap() error {
+	return e.Err
+}
+
+func (e *VMError) StackTrace() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%v\n", e.Err)
+	for _, entry := range e.Stack {
+		fmt.Fprintf(&buf, "\tat %04d %s\n", entry.IP, entry.Op)
+	}
+	return buf.String()
+}
+
+// newError wraps err as *VMError raised at ip, with the failing instruction as the only stack entry.
+func newError(instrs []instruction.Instruction, ip uint32, err error) *VMError {
+	var vmErr *VMError
+	if errors.As(err, &vmErr) {
+		return vmErr
+	}
+	vmErr = &VMError{
+		IP:   ip,
+		Kind: ERR_RUNTIME,
+		Err:  err,
+	}
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			vmErr.Kind = k.kind
+			break
+		}
+	}
+	if ip < uint32(len(instrs)) {
+		vmErr.Op = instruction.Mnemonic(instrs[ip].Kind)
+	}
+	vmErr.Stack = append(vmErr.Stack, StackEntry{IP: ip, Op: vmErr.Op})
+	return vmErr
+}
+
+func (vm *CVM) newError(instrs []instruction.Instruction, ip uint32, err error) error {
+	var vmErr *VMError
+	if errors.As(err, &vmErr) {
+		return err
+	}
+	vmErr = newError(instrs, ip, err)
+	for i := int(vm.FP) - 1; i >= 0; i-- {
+		fr := vm.StackFrame[i]
+		if fr.FrameOffset == -1 || fr.ReturnIP == 0 {
+			continue
+		}
+		call := fr.ReturnIP - 1
+		entry := StackEntry{IP: call}
+		if call < uint32(len(instrs)) {
+			entry.Op = instruction.Mnemonic(instrs[call].Kind)
+		}
+		vmErr.Stack = append(vmErr.Stack, entry)
+	}
+	return vmErr
+}
@@ -7,6 +7,12 @@ type Frame struct {
 	HeapOffset  int
 	FrameOffset int
 	ReturnIP    uint32
+	// Results is the number of values func.ret has to return to a func.call_indirect, -1 for other frames.
+	Results int
+	// Entry is the address of the function running in the frame, func.tailcall keeps Env for calls to it.
+	Entry uint32
+	// Env is the handle of the environment of the closure running in the frame, 0 for other frames.
+	Env uint32
 }
 
 func (f *Frame) String() string {
@@ -0,0 +1,83 @@
+package cvm
+
+import (
+	"context"
+	"cvm/object"
+)
+
+// GC_THRESHOLD is the number of live objects at which Alloc runs the first collection,
+// later collections run whenever the live objects doubled since the previous one.
+const GC_THRESHOLD = 1024
+
+// GCStats describes the work of the object heap collector.
+type GCStats struct {
+	// Collections is the number of collections run so far.
+	Collections uint64
+	// Freed is the number of objects reclaimed by all collections.
+	Freed uint64
+	// Live is the number of objects that survived the last collection.
+	Live int
+}
+
+// Collect runs a mark and sweep collection of the object heap. Roots are the values on the stack
+// and in the heap slots, which hold the globals and the locals of every frame, and the environments
+// of the running closures, objects are kept alive by the references stored in them. Freed handles
+// are reused by Alloc and the heap shrinks when its last objects are freed.
+// References held by the host outside of the vm are not roots, Alloc may reuse their handles.
+func (vm *CVM) Collect(ctx context.Context) error {
+	return vm.collect()
+}
+
+// collect is Collect with extra roots, values an instruction popped but has not stored yet.
+func (vm *CVM) collect(roots ...object.CVMObject) error {
+	if len(vm.Objects) == 0 {
+		return nil
+	}
+	marked := make([]bool, len(vm.Objects))
+	var work []uint32
+	mark := func(handle uint32) {
+		if handle < uint32(len(marked)) && !marked[handle] {
+			marked[handle] = true
+			work = append(work, handle)
+		}
+	}
+	for _, objs := range [][]object.CVMObject{vm.Stack[:vm.SP], vm.Heap[:vm.HP], roots} {
+		for _, obj := range objs {
+			if err := object.Refs(obj, mark); err != nil {
+				return err
+			}
+		}
+	}
+	for _, fr := range vm.StackFrame[:vm.FP] {
+		mark(fr.Env)
+	}
+	for len(work) > 0 {
+		handle := work[len(work)-1]
+		work = work[:len(work)-1]
+		if err := object.Refs(vm.Objects[handle], mark); err != nil {
+			return err
+		}
+	}
+
+	before := len(vm.Objects) - 1 - len(vm.free)
+	size := len(vm.Objects)
+	for size > 1 && !marked[size-1] {
+		size--
+	}
+	clear(vm.Objects[size:])
+	vm.Objects = vm.Objects[:size]
+	// handles are pushed from the top, so Alloc hands out the lowest free handle first
+	vm.free = vm.free[:0]
+	for handle := size - 1; handle > 0; handle-- {
+		if !marked[handle] {
+			vm.Objects[handle] = object.CVMObject{}
+			vm.free = append(vm.free, uint32(handle))
+		}
+	}
+	live := len(vm.Objects) - 1 - len(vm.free)
+	vm.GC.Collections++
+	vm.GC.Freed += uint64(before - live)
+	vm.GC.Live = live
+	vm.nextGC = max(2*uint(live), limit(vm.GCThreshold, GC_THRESHOLD))
+	return nil
+}
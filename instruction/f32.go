@@ -45,3 +45,45 @@ func F32Eq() Instruction {
 func F32Neq() Instruction {
 	return Instruction{Kind: OP_F32_NEQ}
 }
+func F32Sqrt() Instruction {
+	return Instruction{Kind: OP_F32_SQRT}
+}
+func F32Pow() Instruction {
+	return Instruction{Kind: OP_F32_POW}
+}
+func F32Floor() Instruction {
+	return Instruction{Kind: OP_F32_FLOOR}
+}
+func F32Ceil() Instruction {
+	return Instruction{Kind: OP_F32_CEIL}
+}
+func F32Round() Instruction {
+	return Instruction{Kind: OP_F32_ROUND}
+}
+func F32Abs() Instruction {
+	return Instruction{Kind: OP_F32_ABS}
+}
+func F32Min() Instruction {
+	return Instruction{Kind: OP_F32_MIN}
+}
+func F32Max() Instruction {
+	return Instruction{Kind: OP_F32_MAX}
+}
+func F32Sin() Instruction {
+	return Instruction{Kind: OP_F32_SIN}
+}
+func F32Cos() Instruction {
+	return Instruction{Kind: OP_F32_COS}
+}
+func F32Exp() Instruction {
+	return Instruction{Kind: OP_F32_EXP}
+}
+func F32Log() Instruction {
+	return Instruction{Kind: OP_F32_LOG}
+}
+func F32IsNaN() Instruction {
+	return Instruction{Kind: OP_F32_IS_NAN}
+}
+func F32IsInf() Instruction {
+	return Instruction{Kind: OP_F32_IS_INF}
+}
@@ -0,0 +1,47 @@
+package instruction
+
+import (
+	"cvm/object"
+	"encoding/binary"
+	"math"
+)
+
+func F64Load(x float64) Instruction {
+	buf := make([]byte, 0, 9)
+	buf = append(buf, object.TAG_F64)
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(x))
+	return Instruction{Kind: OP_F64_LOAD, Operands: buf}
+}
+func F64Neg() Instruction {
+	return Instruction{Kind: OP_F64_NEG}
+}
+func F64Add() Instruction {
+	return Instruction{Kind: OP_F64_ADD}
+}
+func F64Sub() Instruction {
+	return Instruction{Kind: OP_F64_SUB}
+}
+func F64Mul() Instruction {
+	return Instruction{Kind: OP_F64_MUL}
+}
+func F64Div() Instruction {
+	return Instruction{Kind: OP_F64_DIV}
+}
+func F64Lt() Instruction {
+	return Instruction{Kind: OP_F64_LT}
+}
+func F64Gt() Instruction {
+	return Instruction{Kind: OP_F64_GT}
+}
+func F64Leq() Instruction {
+	return Instruction{Kind: OP_F64_LEQ}
+}
+func F64Geq() Instruction {
+	return Instruction{Kind: OP_F64_GEQ}
+}
+func F64Eq() Instruction {
+	return Instruction{Kind: OP_F64_EQ}
+}
+func F64Neq() Instruction {
+	return Instruction{Kind: OP_F64_NEQ}
+}
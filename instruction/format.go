@@ -0,0 +1,169 @@
+package instruction
+
+import (
+	"bytes"
+	"cvm/object"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Format renders the instruction in assembler syntax.
+// Branch and call targets are passed through label when it is not nil.
+// Operands that the constructors of this package would not produce are reported as errors.
+func (i *Instruction) Format(label func(addr uint32) string) (string, error) {
+	op := opcodes[i.Kind]
+	if op == nil {
+		return "", fmt.Errorf("unknown instruction of kind 0x%02x", i.Kind)
+	}
+	if label == nil {
+		label = func(addr uint32) string {
+			return strconv.FormatUint(uint64(addr), 10)
+		}
+	}
+	var args []string
+	rest := i.Operands
+	for _, k := range op.Operands {
+		arg, size, err := formatOperand(k, rest, label)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, arg...)
+		rest = rest[size:]
+	}
+	if len(rest) > 0 {
+		return "", fmt.Errorf("non-canonical operands for %s", op.Mnemonic)
+	}
+	if i.Kind == OP_MAP_NEW && !object.IsMapKey(i.Operands[0]) {
+		return "", fmt.Errorf("invalid map key tag %s", object.TagsName(i.Operands[0]))
+	}
+	if len(args) == 0 {
+		return op.Mnemonic, nil
+	}
+	return fmt.Sprintf("%-12s %s", op.Mnemonic, strings.Join(args, " ")), nil
+}
+
+// formatOperand renders the operand of kind k at the start of data and returns its encoded size.
+// Operands are re-encoded and compared, so only the canonical encoding is accepted.
+func formatOperand(k Operand, data []byte, label func(addr uint32) string) ([]string, int, error) {
+	var args []string
+	var canonical []byte
+	switch k {
+	case OPERAND_I32, OPERAND_ADDR, OPERAND_UINT, OPERAND_SLOT, OPERAND_TYPE:
+		if len(data) < 5 || data[0] != object.TAG_I32 {
+			return nil, 0, fmt.Errorf("invalid i32 operand")
+		}
+		val := binary.LittleEndian.Uint32(data[1:5])
+		switch k {
+		case OPERAND_I32:
+			args = append(args, strconv.FormatInt(int64(int32(val)), 10))
+		case OPERAND_ADDR:
+			args = append(args, label(val))
+		case OPERAND_SLOT:
+			args = append(args, fmt.Sprintf("$%d", val))
+		default:
+			args = append(args, strconv.FormatUint(uint64(val), 10))
+		}
+		canonical = I32Load(int32(val)).Operands
+	case OPERAND_F32:
+		if len(data) < 5 || data[0] != object.TAG_F32 {
+			return nil, 0, fmt.Errorf("invalid f32 operand")
+		}
+		val := math.Float32frombits(binary.LittleEndian.Uint32(data[1:5]))
+		args = append(args, strconv.FormatFloat(float64(val), 'g', -1, 32))
+		canonical = F32Load(val).Operands
+	case OPERAND_I64:
+		if len(data) < 9 || data[0] != object.TAG_I64 {
+			return nil, 0, fmt.Errorf("invalid i64 operand")
+		}
+		val := int64(binary.LittleEndian.Uint64(data[1:9]))
+		args = append(args, strconv.FormatInt(val, 10))
+		canonical = I64Load(val).Operands
+	case OPERAND_F64:
+		if len(data) < 9 || data[0] != object.TAG_F64 {
+			return nil, 0, fmt.Errorf("invalid f64 operand")
+		}
+		val := math.Float64frombits(binary.LittleEndian.Uint64(data[1:9]))
+		args = append(args, strconv.FormatFloat(val, 'g', -1, 64))
+		canonical = F64Load(val).Operands
+	case OPERAND_BOOL:
+		if len(data) < 2 || data[0] != object.TAG_BOOL {
+			return nil, 0, fmt.Errorf("invalid bool operand")
+		}
+		val := data[1] > 0
+		args = append(args, strconv.FormatBool(val))
+		canonical = BoolLoad(val).Operands
+	case OPERAND_STRING:
+		if len(data) < 6 || data[0] != object.TAG_STRING || data[1] != object.TAG_I32 {
+			return nil, 0, fmt.Errorf("invalid string operand")
+		}
+		ln := int(binary.LittleEndian.Uint32(data[2:6]))
+		if ln > len(data)-6 {
+			return nil, 0, fmt.Errorf("string length %d out of range", ln)
+		}
+		val := string(data[6 : 6+ln])
+		args = append(args, strconv.Quote(val))
+		canonical = StringLoad(val).Operands
+	case OPERAND_TAG:
+		if len(data) == 0 {
+			return nil, 0, fmt.Errorf("missing tag")
+		}
+		if _, err := object.CreateDefault(data[0]); err != nil {
+			return nil, 0, fmt.Errorf("invalid tag %s", object.TagsName(data[0]))
+		}
+		args = append(args, object.TagsName(data[0]))
+		canonical = data[:1]
+	case OPERAND_LIST:
+		if len(data) == 0 {
+			return nil, 0, fmt.Errorf("missing list item tag")
+		}
+		if _, ok := object.TagByName(object.TagsName(data[0])); !ok {
+			return nil, 0, fmt.Errorf("unknown list item tag %v", data[0])
+		}
+		args = append(args, object.TagsName(data[0]))
+		canonical = ListNew(data[0]).Operands
+	case OPERAND_TAGS:
+		if len(data) < 6 || data[0] != object.TAG_STRUCT || data[1] != object.TAG_I32 {
+			return nil, 0, fmt.Errorf("invalid struct operand")
+		}
+		ln := int(binary.LittleEndian.Uint32(data[2:6]))
+		if ln >= len(data)-6 {
+			return nil, 0, fmt.Errorf("struct field count %d out of range", ln)
+		}
+		tags := data[6 : 6+ln]
+		for _, tag := range tags {
+			if _, err := object.CreateDefault(tag); err != nil {
+				return nil, 0, err
+			}
+			args = append(args, object.TagsName(tag))
+		}
+		canonical = StructNew(tags...).Operands
+	default:
+		return nil, 0, fmt.Errorf("unknown operand kind %d", k)
+	}
+	if !bytes.HasPrefix(data, canonical) {
+		return nil, 0, fmt.Errorf("non-canonical operand")
+	}
+	return args, len(canonical), nil
+}
+
+// Target returns the instruction index a jump, block or call refers to.
+func (i *Instruction) Target() (uint32, bool) {
+	op := opcodes[i.Kind]
+	if op == nil || len(op.Operands) == 0 || op.Operands[0] != OPERAND_ADDR {
+		return 0, false
+	}
+	addr, err := i.OperandI32(0)
+	return addr, err == nil
+}
+
+// OperandI32 decodes the n-th i32 operand of jumps, calls, returns, slot accesses and type references.
+func (i *Instruction) OperandI32(n int) (uint32, error) {
+	off := n * 5
+	if len(i.Operands) < off+5 || i.Operands[off] != object.TAG_I32 {
+		return 0, fmt.Errorf("invalid i32 operand #%d", n)
+	}
+	return binary.LittleEndian.Uint32(i.Operands[off+1 : off+5]), nil
+}
@@ -19,3 +19,65 @@ func FuncRet(x uint32) Instruction {
 	buf = binary.LittleEndian.AppendUint32(buf, x)
 	return Instruction{Kind: OP_FUNC_RET, Operands: buf}
 }
+
+// FuncTailCall calls the function at addr with args values in place of the running function,
+// which returns what the called function returns. A closure calling its own entry point keeps its captured values.
+func FuncTailCall(addr uint32, args uint32) Instruction {
+	buf := make([]byte, 0, 10)
+	buf = append(buf, object.TAG_I32)
+	buf = binary.LittleEndian.AppendUint32(buf, addr)
+	buf = append(buf, object.TAG_I32)
+	buf = binary.LittleEndian.AppendUint32(buf, args)
+	return Instruction{Kind: OP_FUNC_TAILCALL, Operands: buf}
+}
+
+// FuncLoad pushes a function value for the function at addr taking params values and returning results values.
+func FuncLoad(addr, params, results uint32) Instruction {
+	buf := make([]byte, 0, 15)
+	for _, val := range []uint32{addr, params, results} {
+		buf = append(buf, object.TAG_I32)
+		buf = binary.LittleEndian.AppendUint32(buf, val)
+	}
+	return Instruction{Kind: OP_FUNC_LOAD, Operands: buf}
+}
+
+// FuncCallIndirect pops a function value and calls it with the params values below it,
+// the function has to take params values and return results values.
+func FuncCallIndirect(params, results uint32) Instruction {
+	buf := make([]byte, 0, 10)
+	for _, val := range []uint32{params, results} {
+		buf = append(buf, object.TAG_I32)
+		buf = binary.LittleEndian.AppendUint32(buf, val)
+	}
+	return Instruction{Kind: OP_FUNC_CALL_INDIRECT, Operands: buf}
+}
+
+// FuncClosure pops n values and the function value below them and pushes a closure of the function
+// capturing the values.
+func FuncClosure(n uint32) Instruction {
+	return closureOp(OP_FUNC_CLOSURE, n)
+}
+
+// ClosureLoad pushes the captured value x of the running closure.
+func ClosureLoad(x uint32) Instruction {
+	return closureOp(OP_CLOSURE_LOAD, x)
+}
+
+// ClosureSave pops a value into the captured value x of the running closure.
+func ClosureSave(x uint32) Instruction {
+	return closureOp(OP_CLOSURE_SAVE, x)
+}
+
+func closureOp(kind byte, x uint32) Instruction {
+	buf := make([]byte, 0, 5)
+	buf = append(buf, object.TAG_I32)
+	buf = binary.LittleEndian.AppendUint32(buf, x)
+	return Instruction{Kind: kind, Operands: buf}
+}
+
+func NativeCall(ind uint32) Instruction {
+	buf := make([]byte, 0, 5)
+	buf = append(buf, object.TAG_I32)
+	buf = binary.LittleEndian.AppendUint32(buf, ind)
+	return Instruction{Kind: OP_NATIVE_CALL, Operands: buf}
+}
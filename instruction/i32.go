@@ -44,3 +44,27 @@ func I32Eq() Instruction {
 func I32Neq() Instruction {
 	return Instruction{Kind: OP_I32_NEQ}
 }
+func I32Rem() Instruction {
+	return Instruction{Kind: OP_I32_REM}
+}
+func I32And() Instruction {
+	return Instruction{Kind: OP_I32_AND}
+}
+func I32Or() Instruction {
+	return Instruction{Kind: OP_I32_OR}
+}
+func I32Xor() Instruction {
+	return Instruction{Kind: OP_I32_XOR}
+}
+func I32Not() Instruction {
+	return Instruction{Kind: OP_I32_NOT}
+}
+func I32Shl() Instruction {
+	return Instruction{Kind: OP_I32_SHL}
+}
+func I32Shr() Instruction {
+	return Instruction{Kind: OP_I32_SHR}
+}
+func I32Shru() Instruction {
+	return Instruction{Kind: OP_I32_SHRU}
+}
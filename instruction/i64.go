@@ -0,0 +1,46 @@
+package instruction
+
+import (
+	"cvm/object"
+	"encoding/binary"
+)
+
+func I64Load(x int64) Instruction {
+	buf := make([]byte, 0, 9)
+	buf = append(buf, object.TAG_I64)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(x))
+	return Instruction{Kind: OP_I64_LOAD, Operands: buf}
+}
+func I64Neg() Instruction {
+	return Instruction{Kind: OP_I64_NEG}
+}
+func I64Add() Instruction {
+	return Instruction{Kind: OP_I64_ADD}
+}
+func I64Sub() Instruction {
+	return Instruction{Kind: OP_I64_SUB}
+}
+func I64Mul() Instruction {
+	return Instruction{Kind: OP_I64_MUL}
+}
+func I64Div() Instruction {
+	return Instruction{Kind: OP_I64_DIV}
+}
+func I64Lt() Instruction {
+	return Instruction{Kind: OP_I64_LT}
+}
+func I64Gt() Instruction {
+	return Instruction{Kind: OP_I64_GT}
+}
+func I64Leq() Instruction {
+	return Instruction{Kind: OP_I64_LEQ}
+}
+func I64Geq() Instruction {
+	return Instruction{Kind: OP_I64_GEQ}
+}
+func I64Eq() Instruction {
+	return Instruction{Kind: OP_I64_EQ}
+}
+func I64Neq() Instruction {
+	return Instruction{Kind: OP_I64_NEQ}
+}
@@ -1,7 +1,6 @@
 package instruction
 
 import (
-	"bytes"
 	"cvm/object"
 	"encoding/binary"
 	"fmt"
@@ -93,95 +92,120 @@ const (
 
 	OP_LOCAL_LOAD
 	OP_LOCAL_SAVE
-)
 
-var instrKindString = map[byte]string{
-	OP_NULL: "null",
-	OP_HALT: "halt",
-
-	OP_I32_LOAD: "i32.load",
-	OP_I32_NEG:  "i32.neg",
-	OP_I32_ADD:  "i32.add",
-	OP_I32_SUB:  "i32.sub",
-	OP_I32_MUL:  "i32.mul",
-	OP_I32_DIV:  "i32.div",
-	OP_I32_LT:   "i32.lt",
-	OP_I32_GT:   "i32.gt",
-	OP_I32_LEQ:  "i32.leq",
-	OP_I32_GEQ:  "i32.geq",
-	OP_I32_EQ:   "i32.eq",
-	OP_I32_NEQ:  "i32.neq",
-
-	OP_BOOL_LOAD: "bool.load",
-	OP_BOOL_NOT:  "bool.not",
-	OP_BOOL_AND:  "bool.and",
-	OP_BOOL_OR:   "bool.or",
-	OP_BOOL_NAND: "bool.nand",
-	OP_BOOL_NOR:  "bool.nor",
-	OP_BOOL_XOR:  "bool.xor",
-
-	OP_F32_LOAD: "f32.load",
-	OP_F32_NEG:  "f32.neg",
-	OP_F32_ADD:  "f32.add",
-	OP_F32_SUB:  "f32.sub",
-	OP_F32_MUL:  "f32.mul",
-	OP_F32_DIV:  "f32.div",
-	OP_F32_LT:   "f32.lt",
-	OP_F32_GT:   "f32.gt",
-	OP_F32_LEQ:  "f32.leq",
-	OP_F32_GEQ:  "f32.geq",
-	OP_F32_EQ:   "f32.eq",
-	OP_F32_NEQ:  "f32.neq",
-
-	OP_LIST_NEW:     "list.new",
-	OP_LIST_LENGTH:  "list.length",
-	OP_LIST_GET:     "list.get",
-	OP_LIST_REMOVE:  "list.remove",
-	OP_LIST_INSERT:  "list.insert",
-	OP_LIST_REPLACE: "list.replace",
-
-	OP_STRING_LOAD:   "string.load",
-	OP_STRING_CONCAT: "string.concat",
-	OP_STRING_SPLIT:  "string.split",
-	OP_STRING_FORMAT: "string.format",
-	OP_STRING_LENGTH: "string.length",
-
-	OP_STRUCT_NEW: "struct.new",
-	OP_STRUCT_GET: "struct.get",
-	OP_STRUCT_SET: "struct.set",
-
-	OP_TO_STRING: "to_string",
-	OP_TO_BOOL:   "to_bool",
-	OP_TO_I32:    "to_i32",
-	OP_TO_F32:    "to_f32",
-
-	OP_JUMP:   "jump",
-	OP_JUMPC:  "jumpc",
-	OP_JUMPNC: "jumpnc",
-
-	OP_BLOCK_START: "block.block",
-	OP_BLOCK_END:   "block.end",
-	OP_BLOCK_BR:    "block.br",
-	OP_BLOCK_LOAD:  "block.load",
-	OP_BLOCK_SAVE:  "block.save",
-
-	OP_LOAD: "load",
-	OP_NEW:  "new",
-	OP_SAVE: "save",
-	OP_POP:  "pop",
-	OP_FREE: "free",
-
-	OP_PRINT:   "print",
-	OP_PRINTF:  "printf",
-	OP_PRINTLN: "println",
-	OP_READ:    "read",
-
-	OP_FUNC_CALL: "func.call",
-	OP_FUNC_RET:  "func.ret",
-
-	OP_LOCAL_LOAD: "local.load",
-	OP_LOCAL_SAVE: "local.save",
-}
+	OP_NATIVE_CALL
+
+	OP_STRUCT_MAKE
+
+	OP_MAP_NEW
+	OP_MAP_GET
+	OP_MAP_SET
+	OP_MAP_DELETE
+	OP_MAP_HAS
+	OP_MAP_LEN
+	OP_MAP_KEYS
+
+	OP_I64_LOAD
+	OP_I64_NEG
+	OP_I64_ADD
+	OP_I64_SUB
+	OP_I64_MUL
+	OP_I64_DIV
+	OP_I64_LT
+	OP_I64_GT
+	OP_I64_LEQ
+	OP_I64_GEQ
+	OP_I64_EQ
+	OP_I64_NEQ
+
+	OP_F64_LOAD
+	OP_F64_NEG
+	OP_F64_ADD
+	OP_F64_SUB
+	OP_F64_MUL
+	OP_F64_DIV
+	OP_F64_LT
+	OP_F64_GT
+	OP_F64_LEQ
+	OP_F64_GEQ
+	OP_F64_EQ
+	OP_F64_NEQ
+
+	OP_TO_I64
+	OP_TO_F64
+
+	OP_I32_REM
+	OP_I32_AND
+	OP_I32_OR
+	OP_I32_XOR
+	OP_I32_NOT
+	OP_I32_SHL
+	OP_I32_SHR
+	OP_I32_SHRU
+
+	OP_F32_SQRT
+	OP_F32_POW
+	OP_F32_FLOOR
+	OP_F32_CEIL
+	OP_F32_ROUND
+	OP_F32_ABS
+	OP_F32_MIN
+	OP_F32_MAX
+	OP_F32_SIN
+	OP_F32_COS
+	OP_F32_EXP
+	OP_F32_LOG
+	OP_F32_IS_NAN
+	OP_F32_IS_INF
+
+	OP_STRING_RUNE_LENGTH
+	OP_STRING_SLICE
+	OP_STRING_RUNE_SLICE
+	OP_STRING_INDEX
+	OP_STRING_RUNE_INDEX
+	OP_STRING_CHAR_AT
+	OP_STRING_RUNE_AT
+	OP_STRING_CONTAINS
+	OP_STRING_HAS_PREFIX
+	OP_STRING_HAS_SUFFIX
+	OP_STRING_REPLACE
+	OP_STRING_TRIM
+	OP_STRING_UPPER
+	OP_STRING_LOWER
+	OP_STRING_JOIN
+	OP_STRING_COMPARE
+
+	OP_LIST_APPEND
+	OP_LIST_POP
+	OP_LIST_SLICE
+	OP_LIST_CONCAT
+	OP_LIST_REVERSE
+	OP_LIST_INDEX
+	OP_LIST_CONTAINS
+	OP_LIST_SORT
+
+	OP_REF_NEW
+	OP_REF_GET
+	OP_REF_SET
+	OP_REF_COPY
+	OP_REF_LENGTH
+	OP_REF_AT
+	OP_REF_APPEND
+	OP_REF_INSERT
+	OP_REF_REMOVE
+	OP_REF_REPLACE
+
+	OP_GC
+
+	OP_FUNC_LOAD
+	OP_FUNC_CALL_INDIRECT
+
+	OP_FUNC_CLOSURE
+	OP_CLOSURE_LOAD
+	OP_CLOSURE_SAVE
+
+	OP_FUNC_TAILCALL
+)
 
 type Instruction struct {
 	Kind     byte
@@ -189,41 +213,11 @@ type Instruction struct {
 }
 
 func (i *Instruction) String() string {
-	var buf bytes.Buffer
-	fmt.Fprintf(&buf, "%-12s", instrKindString[i.Kind])
-	switch i.Kind {
-	case OP_I32_LOAD, OP_F32_LOAD, OP_BOOL_LOAD, OP_STRING_LOAD, OP_LIST_NEW, OP_STRUCT_NEW:
-		obj, err := object.CreateObject(i.Operands)
-		if err != nil {
-			panic(err)
-		}
-		str, err := object.String(obj)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Fprintf(&buf, " %s", str)
-	case OP_JUMP, OP_JUMPC, OP_JUMPNC, OP_BLOCK_START, OP_FUNC_CALL:
-		obj, err := object.CreateObject(i.Operands[:4])
-		if err != nil {
-			panic(err)
-		}
-		val, err := object.ValueI32(obj)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Fprintf(&buf, " [%d]", val)
-	case OP_LOAD, OP_BLOCK_LOAD, OP_LOCAL_LOAD, OP_SAVE, OP_BLOCK_SAVE, OP_LOCAL_SAVE:
-		obj, err := object.CreateObject(i.Operands)
-		if err != nil {
-			panic(err)
-		}
-		val, err := object.ValueI32(obj)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Fprintf(&buf, " $%d", val)
+	str, err := i.Format(nil)
+	if err != nil {
+		return fmt.Sprintf("%-12s <%s>", Mnemonic(i.Kind), err)
 	}
-	return buf.String()
+	return str
 }
 
 func Null() Instruction {
@@ -310,6 +304,14 @@ func ToF32() Instruction {
 	return Instruction{Kind: OP_TO_F32}
 }
 
+func ToI64() Instruction {
+	return Instruction{Kind: OP_TO_I64}
+}
+
+func ToF64() Instruction {
+	return Instruction{Kind: OP_TO_F64}
+}
+
 func ToBool() Instruction {
 	return Instruction{Kind: OP_TO_BOOL}
 }
@@ -0,0 +1,82 @@
+package instruction
+
+import (
+	"bytes"
+	"cvm/object"
+	"testing"
+)
+
+func TestMnemonics(t *testing.T) {
+	names := map[string]bool{}
+	for ind, op := range builtins {
+		if int(op.Kind) != ind {
+			t.Fatalf("opcode %s has kind %d, want %d", op.Mnemonic, op.Kind, ind)
+		}
+		if !isMnemonic(op.Mnemonic) {
+			t.Fatalf("opcode %d has invalid mnemonic %q", op.Kind, op.Mnemonic)
+		}
+		if names[op.Mnemonic] {
+			t.Fatalf("duplicate mnemonic %s", op.Mnemonic)
+		}
+		names[op.Mnemonic] = true
+	}
+	if len(builtins) > int(OP_CUSTOM_FIRST) {
+		t.Fatalf("builtin opcodes overlap the custom range")
+	}
+}
+
+var customOp = func() byte {
+	err := Register(Opcode{
+		Kind:     OP_CUSTOM_FIRST,
+		Mnemonic: "test.scale",
+		Operands: []Operand{OPERAND_I32, OPERAND_SLOT},
+		Effect:   Effect{Pop: []byte{object.TAG_I32}, Push: []byte{object.TAG_I32}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return OP_CUSTOM_FIRST
+}()
+
+func TestRegister(t *testing.T) {
+	operands := append(I32Load(-3).Operands, Load(2).Operands...)
+	instr := Instruction{Kind: customOp, Operands: operands}
+	if str := instr.String(); str != "test.scale   -3 $2" {
+		t.Fatalf("unexpected format %q", str)
+	}
+	if kind, ok := Lookup("test.scale"); !ok || kind != customOp {
+		t.Fatalf("custom mnemonic not found")
+	}
+	m := &Module{Code: []Instruction{instr, Halt()}}
+	var buf bytes.Buffer
+	if err := Encode(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	res, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code[0].String() != instr.String() {
+		t.Fatalf("unexpected decoded instruction %s", res.Code[0].String())
+	}
+
+	testCases := []struct {
+		desc string
+		op   Opcode
+	}{
+		{desc: "test builtin range", op: Opcode{Kind: OP_I32_ADD, Mnemonic: "test.add"}},
+		{desc: "test taken kind", op: Opcode{Kind: customOp, Mnemonic: "test.other"}},
+		{desc: "test taken mnemonic", op: Opcode{Kind: customOp + 1, Mnemonic: "i32.add"}},
+		{desc: "test invalid mnemonic", op: Opcode{Kind: customOp + 1, Mnemonic: "test add"}},
+		{desc: "test variadic", op: Opcode{Kind: customOp + 1, Mnemonic: "test.call", Variadic: true}},
+		{desc: "test pooled operand", op: Opcode{Kind: customOp + 1, Mnemonic: "test.str", Operands: []Operand{OPERAND_STRING}}},
+		{desc: "test branch target", op: Opcode{Kind: customOp + 1, Mnemonic: "test.jump", Operands: []Operand{OPERAND_ADDR}}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			if err := Register(tC.op); err == nil {
+				t.Fatalf("expected error")
+			}
+		})
+	}
+}
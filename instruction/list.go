@@ -27,3 +27,27 @@ func ListRemove() Instruction {
 func ListReplace() Instruction {
 	return Instruction{Kind: OP_LIST_REPLACE}
 }
+func ListAppend() Instruction {
+	return Instruction{Kind: OP_LIST_APPEND}
+}
+func ListPop() Instruction {
+	return Instruction{Kind: OP_LIST_POP}
+}
+func ListSlice() Instruction {
+	return Instruction{Kind: OP_LIST_SLICE}
+}
+func ListConcat() Instruction {
+	return Instruction{Kind: OP_LIST_CONCAT}
+}
+func ListReverse() Instruction {
+	return Instruction{Kind: OP_LIST_REVERSE}
+}
+func ListIndex() Instruction {
+	return Instruction{Kind: OP_LIST_INDEX}
+}
+func ListContains() Instruction {
+	return Instruction{Kind: OP_LIST_CONTAINS}
+}
+func ListSort() Instruction {
+	return Instruction{Kind: OP_LIST_SORT}
+}
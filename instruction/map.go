@@ -0,0 +1,23 @@
+package instruction
+
+func MapNew(keyTag, valTag byte) Instruction {
+	return Instruction{Kind: OP_MAP_NEW, Operands: []byte{keyTag, valTag}}
+}
+func MapGet() Instruction {
+	return Instruction{Kind: OP_MAP_GET}
+}
+func MapSet() Instruction {
+	return Instruction{Kind: OP_MAP_SET}
+}
+func MapDelete() Instruction {
+	return Instruction{Kind: OP_MAP_DELETE}
+}
+func MapHas() Instruction {
+	return Instruction{Kind: OP_MAP_HAS}
+}
+func MapLen() Instruction {
+	return Instruction{Kind: OP_MAP_LEN}
+}
+func MapKeys() Instruction {
+	return Instruction{Kind: OP_MAP_KEYS}
+}
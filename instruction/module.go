@@ -0,0 +1,349 @@
+package instruction
+
+import (
+	"bytes"
+	"cvm/object"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Binary layout, all integers little endian:
+//
+//	magic "CVMB" | format version u16 | opcode table version u16 | flags u16
+//	constant pool:  count u32 | { size u32 | bytes }...
+//	type table:     count u32 | { name | field count u32 | { tag u8 | name }... }...   (names are size u32 | bytes)
+//	code section:   count u32 | { kind u8 | operands }...
+//	debug section:  source size u32 | source | count u32 | { line u32 }...   (FLAG_DEBUG only)
+//	checksum u32 (crc32 IEEE of everything before it)
+//
+// String, list and struct operands, the ones of string.load, list.new and struct.new, live in the constant pool
+// and are referenced from the code section by a u32 index, other operands are stored inline.
+const (
+	MODULE_MAGIC         = "CVMB"
+	MODULE_VERSION       = 2
+	OPCODE_TABLE_VERSION = 14
+
+	FLAG_DEBUG = 1 << 0
+)
+
+var ErrInvalidModule = errors.New("invalid module")
+
+type Module struct {
+	Types []TypeDecl
+	Code  []Instruction
+	Debug *DebugInfo
+}
+
+// TypeDecl declares a named struct type, struct.make refers to it by its index in Module.Types.
+type TypeDecl struct {
+	Name   string
+	Fields []Field
+}
+
+type Field struct {
+	Name string
+	Tag  byte
+}
+
+// Default returns a struct of this type with every field set to its default value.
+func (t *TypeDecl) Default() (object.CVMObject, error) {
+	names := make([]string, len(t.Fields))
+	tags := make([]byte, len(t.Fields))
+	for ind, field := range t.Fields {
+		names[ind] = field.Name
+		tags[ind] = field.Tag
+	}
+	return object.CreateNamedStruct(t.Name, names, tags)
+}
+
+// FieldIndex returns the position of the field called name.
+func (t *TypeDecl) FieldIndex(name string) (uint32, bool) {
+	for ind, field := range t.Fields {
+		if field.Name == name {
+			return uint32(ind), true
+		}
+	}
+	return 0, false
+}
+
+// IsIdent reports whether name can be used as a type or field name.
+func IsIdent(name string) bool {
+	if name == "" {
+		return false
+	}
+	for ind, ch := range name {
+		switch {
+		case ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z'):
+		case ind > 0 && ch >= '0' && ch <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type DebugInfo struct {
+	Source string
+	Lines  []uint32
+}
+
+func isPooled(kind byte) bool {
+	op := opcodes[kind]
+	return op != nil && op.pooled()
+}
+
+// Validate checks that every type is well formed, every instruction is known, has well formed operands
+// and that every jump, block, call and type reference lies inside the module.
+func Validate(m *Module) error {
+	names := map[string]bool{}
+	for ind, typ := range m.Types {
+		if !IsIdent(typ.Name) {
+			return fmt.Errorf("%w: type %d: invalid name %q", ErrInvalidModule, ind, typ.Name)
+		}
+		if names[typ.Name] {
+			return fmt.Errorf("%w: type %s redeclared", ErrInvalidModule, typ.Name)
+		}
+		names[typ.Name] = true
+		for fi, field := range typ.Fields {
+			if !IsIdent(field.Name) {
+				return fmt.Errorf("%w: type %s: invalid field name %q", ErrInvalidModule, typ.Name, field.Name)
+			}
+			if ind, _ := typ.FieldIndex(field.Name); int(ind) != fi {
+				return fmt.Errorf("%w: type %s: field %s redeclared", ErrInvalidModule, typ.Name, field.Name)
+			}
+			if _, err := object.CreateDefault(field.Tag); err != nil {
+				return fmt.Errorf("%w: type %s: field %s: %v", ErrInvalidModule, typ.Name, field.Name, err)
+			}
+		}
+	}
+	for ip := range m.Code {
+		instr := &m.Code[ip]
+		if _, err := instr.Format(nil); err != nil {
+			return fmt.Errorf("%w: instruction %d: %v", ErrInvalidModule, ip, err)
+		}
+		if addr, ok := instr.Target(); ok && addr > uint32(len(m.Code)) {
+			return fmt.Errorf("%w: instruction %d: target %d out of range", ErrInvalidModule, ip, addr)
+		}
+		if instr.Kind == OP_STRUCT_MAKE {
+			if typ, _ := instr.OperandI32(0); typ >= uint32(len(m.Types)) {
+				return fmt.Errorf("%w: instruction %d: type %d out of range", ErrInvalidModule, ip, typ)
+			}
+		}
+	}
+	if m.Debug != nil && len(m.Debug.Lines) != len(m.Code) {
+		return fmt.Errorf("%w: debug info covers %d instructions, code has %d", ErrInvalidModule, len(m.Debug.Lines), len(m.Code))
+	}
+	return nil
+}
+
+func Encode(w io.Writer, m *Module) error {
+	if err := Validate(m); err != nil {
+		return err
+	}
+	var flags uint16
+	if m.Debug != nil {
+		flags |= FLAG_DEBUG
+	}
+	var pool [][]byte
+	poolIndex := map[string]uint32{}
+	var code []byte
+	for _, instr := range m.Code {
+		code = append(code, instr.Kind)
+		if !isPooled(instr.Kind) {
+			code = append(code, instr.Operands...)
+			continue
+		}
+		ind, ok := poolIndex[string(instr.Operands)]
+		if !ok {
+			ind = uint32(len(pool))
+			poolIndex[string(instr.Operands)] = ind
+			pool = append(pool, instr.Operands)
+		}
+		code = binary.LittleEndian.AppendUint32(code, ind)
+	}
+
+	buf := []byte(MODULE_MAGIC)
+	buf = binary.LittleEndian.AppendUint16(buf, MODULE_VERSION)
+	buf = binary.LittleEndian.AppendUint16(buf, OPCODE_TABLE_VERSION)
+	buf = binary.LittleEndian.AppendUint16(buf, flags)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(pool)))
+	for _, c := range pool {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(c)))
+		buf = append(buf, c...)
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(m.Types)))
+	for _, typ := range m.Types {
+		buf = appendName(buf, typ.Name)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(typ.Fields)))
+		for _, field := range typ.Fields {
+			buf = append(buf, field.Tag)
+			buf = appendName(buf, field.Name)
+		}
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(m.Code)))
+	buf = append(buf, code...)
+	if m.Debug != nil {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(m.Debug.Source)))
+		buf = append(buf, m.Debug.Source...)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(m.Debug.Lines)))
+		for _, line := range m.Debug.Lines {
+			buf = binary.LittleEndian.AppendUint32(buf, line)
+		}
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+	_, err := w.Write(buf)
+	return err
+}
+
+func Decode(r io.Reader) (*Module, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(MODULE_MAGIC)+10 {
+		return nil, fmt.Errorf("%w: file too short", ErrInvalidModule)
+	}
+	if !bytes.Equal(data[:len(MODULE_MAGIC)], []byte(MODULE_MAGIC)) {
+		return nil, fmt.Errorf("%w: bad magic %q", ErrInvalidModule, data[:len(MODULE_MAGIC)])
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidModule)
+	}
+	rd := moduleReader{data: body, off: len(MODULE_MAGIC)}
+	if v := rd.uint16(); v != MODULE_VERSION {
+		return nil, fmt.Errorf("%w: unsupported format version %d", ErrInvalidModule, v)
+	}
+	if v := rd.uint16(); v != OPCODE_TABLE_VERSION {
+		return nil, fmt.Errorf("%w: unsupported opcode table version %d", ErrInvalidModule, v)
+	}
+	flags := rd.uint16()
+	if flags&^FLAG_DEBUG != 0 {
+		return nil, fmt.Errorf("%w: unknown flags 0x%04x", ErrInvalidModule, flags)
+	}
+
+	pool := make([][]byte, rd.count(4))
+	for ind := range pool {
+		pool[ind] = rd.bytes(int(rd.uint32()))
+	}
+	m := &Module{}
+	if n := rd.count(8); n > 0 {
+		m.Types = make([]TypeDecl, n)
+	}
+	for ind := range m.Types {
+		m.Types[ind].Name = rd.name()
+		m.Types[ind].Fields = make([]Field, rd.count(5))
+		for fi := range m.Types[ind].Fields {
+			m.Types[ind].Fields[fi].Tag = rd.byte()
+			m.Types[ind].Fields[fi].Name = rd.name()
+		}
+	}
+	m.Code = make([]Instruction, rd.count(1))
+	for ip := range m.Code {
+		instr := Instruction{Kind: rd.byte()}
+		if rd.err != nil {
+			break
+		}
+		op := opcodes[instr.Kind]
+		if op == nil {
+			return nil, fmt.Errorf("%w: instruction %d: unknown opcode 0x%02x", ErrInvalidModule, ip, instr.Kind)
+		}
+		if op.pooled() {
+			ind := rd.uint32()
+			if rd.err == nil && ind >= uint32(len(pool)) {
+				return nil, fmt.Errorf("%w: instruction %d: constant %d out of range", ErrInvalidModule, ip, ind)
+			}
+			if rd.err == nil {
+				instr.Operands = pool[ind]
+			}
+		} else if size := op.inlineSize(); size > 0 {
+			instr.Operands = rd.bytes(size)
+		}
+		m.Code[ip] = instr
+	}
+	if flags&FLAG_DEBUG != 0 {
+		m.Debug = &DebugInfo{}
+		m.Debug.Source = string(rd.bytes(int(rd.uint32())))
+		m.Debug.Lines = make([]uint32, rd.count(4))
+		for ind := range m.Debug.Lines {
+			m.Debug.Lines[ind] = rd.uint32()
+		}
+	}
+	if rd.err != nil {
+		return nil, rd.err
+	}
+	if rd.off != len(rd.data) {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrInvalidModule, len(rd.data)-rd.off)
+	}
+	if err := Validate(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func appendName(buf []byte, name string) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(name)))
+	return append(buf, name...)
+}
+
+type moduleReader struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (r *moduleReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data)-r.off {
+		r.err = fmt.Errorf("%w: truncated at offset %d", ErrInvalidModule, r.off)
+		return nil
+	}
+	res := make([]byte, n)
+	copy(res, r.data[r.off:r.off+n])
+	r.off += n
+	return res
+}
+
+func (r *moduleReader) byte() byte {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *moduleReader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (r *moduleReader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *moduleReader) name() string {
+	return string(r.bytes(int(r.uint32())))
+}
+
+// count reads an element count and rejects it when the remaining data
+// can't hold that many elements of at least minSize bytes.
+func (r *moduleReader) count(minSize int) int {
+	n := r.uint32()
+	if r.err == nil && uint64(n)*uint64(minSize) > uint64(len(r.data)-r.off) {
+		r.err = fmt.Errorf("%w: count %d exceeds remaining data at offset %d", ErrInvalidModule, n, r.off)
+		return 0
+	}
+	return int(n)
+}
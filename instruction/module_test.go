@@ -0,0 +1,113 @@
+package instruction
+
+import (
+	"bytes"
+	"cvm/object"
+	"errors"
+	"testing"
+)
+
+func testModule() *Module {
+	return &Module{
+		Types: []TypeDecl{
+			{Name: "Point", Fields: []Field{{Name: "x", Tag: object.TAG_I32}, {Name: "y", Tag: object.TAG_I32}}},
+			{Name: "Empty"},
+		},
+		Code: []Instruction{
+			StructMake(0),
+			I32Load(10),
+			StringLoad("hello"),
+			StringLoad("hello"),
+			ListNew(object.TAG_I32),
+			StructNew(object.TAG_I32, object.TAG_STRING),
+			FuncCall(8, 1),
+			Halt(),
+			FuncRet(1),
+		},
+		Debug: &DebugInfo{Source: "test.cvms", Lines: []uint32{1, 2, 3, 4, 5, 6, 7, 8, 9}},
+	}
+}
+
+func TestModuleRoundTrip(t *testing.T) {
+	for _, m := range []*Module{testModule(), {Types: testModule().Types, Code: testModule().Code}, {}} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, m); err != nil {
+			t.Fatal(err)
+		}
+		res, err := Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Code) != len(m.Code) {
+			t.Fatalf("got %d instructions, want %d", len(res.Code), len(m.Code))
+		}
+		for ip := range m.Code {
+			if res.Code[ip].Kind != m.Code[ip].Kind || !bytes.Equal(res.Code[ip].Operands, m.Code[ip].Operands) {
+				t.Fatalf("instruction %d: %v != %v", ip, res.Code[ip], m.Code[ip])
+			}
+		}
+		if len(res.Types) != len(m.Types) {
+			t.Fatalf("got %d types, want %d", len(res.Types), len(m.Types))
+		}
+		for ind, typ := range m.Types {
+			if res.Types[ind].Name != typ.Name || len(res.Types[ind].Fields) != len(typ.Fields) {
+				t.Fatalf("type %d: %v != %v", ind, res.Types[ind], typ)
+			}
+			for fi, field := range typ.Fields {
+				if res.Types[ind].Fields[fi] != field {
+					t.Fatalf("type %s field %d: %v != %v", typ.Name, fi, res.Types[ind].Fields[fi], field)
+				}
+			}
+		}
+		if (res.Debug == nil) != (m.Debug == nil) {
+			t.Fatalf("debug section mismatch")
+		}
+		if m.Debug != nil && (res.Debug.Source != m.Debug.Source || len(res.Debug.Lines) != len(m.Debug.Lines)) {
+			t.Fatalf("debug info %v != %v", res.Debug, m.Debug)
+		}
+	}
+}
+
+func TestModuleCorrupt(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, testModule()); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	for n := 0; n < len(data); n++ {
+		if _, err := Decode(bytes.NewReader(data[:n])); !errors.Is(err, ErrInvalidModule) {
+			t.Fatalf("truncated to %d bytes: expected invalid module, got %v", n, err)
+		}
+	}
+	for n := 0; n < len(data); n++ {
+		corrupt := bytes.Clone(data)
+		corrupt[n] ^= 0xff
+		if _, err := Decode(bytes.NewReader(corrupt)); !errors.Is(err, ErrInvalidModule) {
+			t.Fatalf("flipped byte %d: expected invalid module, got %v", n, err)
+		}
+	}
+}
+
+func TestModuleValidate(t *testing.T) {
+	testCases := []struct {
+		desc string
+		m    *Module
+	}{
+		{desc: "test target out of range", m: &Module{Code: []Instruction{Jump(5)}}},
+		{desc: "test unknown opcode", m: &Module{Code: []Instruction{{Kind: 0xff}}}},
+		{desc: "test truncated operands", m: &Module{Code: []Instruction{{Kind: OP_I32_LOAD, Operands: []byte{object.TAG_I32}}}}},
+		{desc: "test debug lines", m: &Module{Code: []Instruction{Halt()}, Debug: &DebugInfo{}}},
+		{desc: "test type out of range", m: &Module{Code: []Instruction{StructMake(0)}}},
+		{desc: "test invalid type name", m: &Module{Types: []TypeDecl{{Name: "a b"}}}},
+		{desc: "test redeclared type", m: &Module{Types: []TypeDecl{{Name: "T"}, {Name: "T"}}}},
+		{desc: "test redeclared field", m: &Module{Types: []TypeDecl{{Name: "T", Fields: []Field{{"x", object.TAG_I32}, {"x", object.TAG_BOOL}}}}}},
+		{desc: "test invalid field tag", m: &Module{Types: []TypeDecl{{Name: "T", Fields: []Field{{"x", object.TAG_UNDEFINED}}}}}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			if err := Encode(&bytes.Buffer{}, tC.m); !errors.Is(err, ErrInvalidModule) {
+				t.Fatalf("expected invalid module, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,385 @@
+package instruction
+
+import (
+	"cvm/object"
+	"fmt"
+	"strings"
+)
+
+// Operand is the kind of an inline instruction operand, it fixes both the binary encoding
+// and how the operand is written in assembler source.
+type Operand byte
+
+const (
+	OPERAND_I32    Operand = iota // tag.i32
+	OPERAND_F32                   // tag.f32
+	OPERAND_BOOL                  // tag.bool
+	OPERAND_I64                   // tag.i64
+	OPERAND_F64                   // tag.f64
+	OPERAND_STRING                // string object, pooled
+	OPERAND_TAG                   // bare tag byte
+	OPERAND_LIST                  // list item tag, encoded as the data of an empty list, pooled
+	OPERAND_TAGS                  // struct field tags, encoded as a default struct, pooled and always last
+	OPERAND_ADDR                  // instruction index as tag.i32
+	OPERAND_UINT                  // count or index as tag.i32
+	OPERAND_SLOT                  // heap slot as tag.i32, written $n
+	OPERAND_TYPE                  // index into Module.Types as tag.i32
+)
+
+// Size returns the encoded size of an operand, 0 for the variable sized constants kept in the module constant pool.
+func (k Operand) Size() int {
+	switch k {
+	case OPERAND_I32, OPERAND_F32, OPERAND_ADDR, OPERAND_UINT, OPERAND_SLOT, OPERAND_TYPE:
+		return 5
+	case OPERAND_I64, OPERAND_F64:
+		return 9
+	case OPERAND_BOOL:
+		return 2
+	case OPERAND_TAG:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// ANY in an Effect matches every tag.
+const ANY = object.TAG_UNDEFINED
+
+// Effect lists the tags an instruction pops, bottom to top, and the tags it pushes.
+type Effect struct {
+	Pop  []byte
+	Push []byte
+}
+
+// Opcode describes an instruction kind. Variadic opcodes like func.call pop and push
+// a number of values that depends on their operands or the stack, their Effect is empty.
+type Opcode struct {
+	Kind     byte
+	Mnemonic string
+	Operands []Operand
+	Effect   Effect
+	Variadic bool
+}
+
+// pooled reports whether the operands of op live in the module constant pool.
+func (op *Opcode) pooled() bool {
+	for _, k := range op.Operands {
+		if k.Size() == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// inlineSize returns the encoded size of the operands of an opcode that isn't pooled.
+func (op *Opcode) inlineSize() int {
+	size := 0
+	for _, k := range op.Operands {
+		size += k.Size()
+	}
+	return size
+}
+
+// Kinds OP_CUSTOM_FIRST to OP_CUSTOM_LAST are reserved for opcodes registered by embedders.
+const (
+	OP_CUSTOM_FIRST byte = 0xc0
+	OP_CUSTOM_LAST  byte = 0xff
+)
+
+var (
+	opcodes       [256]*Opcode
+	opcodesByName = map[string]byte{}
+)
+
+func init() {
+	for ind := range builtins {
+		op := &builtins[ind]
+		opcodes[op.Kind] = op
+		opcodesByName[op.Mnemonic] = op.Kind
+	}
+}
+
+// Register adds a custom opcode to the table used by the assembler, Format, module encoding and the verifier.
+// Its kind must lie in the custom range and neither kind nor mnemonic may be taken.
+// Custom opcodes have a fixed stack effect, continue with the next instruction and only take
+// operands that are stored inline. Register must not run concurrently with users of the table,
+// call it while the program initializes.
+func Register(op Opcode) error {
+	if op.Kind < OP_CUSTOM_FIRST {
+		return fmt.Errorf("opcode 0x%02x is outside of the custom range 0x%02x-0x%02x", op.Kind, OP_CUSTOM_FIRST, OP_CUSTOM_LAST)
+	}
+	if prev := opcodes[op.Kind]; prev != nil {
+		return fmt.Errorf("opcode 0x%02x is already registered as %s", op.Kind, prev.Mnemonic)
+	}
+	if !isMnemonic(op.Mnemonic) {
+		return fmt.Errorf("invalid mnemonic %q", op.Mnemonic)
+	}
+	if _, ok := opcodesByName[op.Mnemonic]; ok {
+		return fmt.Errorf("mnemonic %s is already registered", op.Mnemonic)
+	}
+	if op.Variadic {
+		return fmt.Errorf("custom opcode %s can't be variadic", op.Mnemonic)
+	}
+	for _, k := range op.Operands {
+		if k == OPERAND_ADDR || k.Size() == 0 {
+			return fmt.Errorf("custom opcode %s can't take operand kind %d", op.Mnemonic, k)
+		}
+	}
+	op.Operands = append([]Operand(nil), op.Operands...)
+	op.Effect = Effect{Pop: append([]byte(nil), op.Effect.Pop...), Push: append([]byte(nil), op.Effect.Push...)}
+	opcodes[op.Kind] = &op
+	opcodesByName[op.Mnemonic] = op.Kind
+	return nil
+}
+
+// isMnemonic reports whether name is made of dot separated identifiers, like i32.add.
+func isMnemonic(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, part := range strings.Split(name, ".") {
+		if !IsIdent(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// Info returns the description of kind.
+func Info(kind byte) (Opcode, bool) {
+	op := opcodes[kind]
+	if op == nil {
+		return Opcode{}, false
+	}
+	return *op, true
+}
+
+func Mnemonic(kind byte) string {
+	if op := opcodes[kind]; op != nil {
+		return op.Mnemonic
+	}
+	return ""
+}
+
+func Lookup(name string) (byte, bool) {
+	kind, ok := opcodesByName[name]
+	return kind, ok
+}
+
+var (
+	i32Unary  = Effect{Pop: []byte{object.TAG_I32}, Push: []byte{object.TAG_I32}}
+	i32Binary = Effect{Pop: []byte{object.TAG_I32, object.TAG_I32}, Push: []byte{object.TAG_I32}}
+	i32Cmp    = Effect{Pop: []byte{object.TAG_I32, object.TAG_I32}, Push: []byte{object.TAG_BOOL}}
+	f32Unary  = Effect{Pop: []byte{object.TAG_F32}, Push: []byte{object.TAG_F32}}
+	f32Binary = Effect{Pop: []byte{object.TAG_F32, object.TAG_F32}, Push: []byte{object.TAG_F32}}
+	f32Cmp    = Effect{Pop: []byte{object.TAG_F32, object.TAG_F32}, Push: []byte{object.TAG_BOOL}}
+	i64Unary  = Effect{Pop: []byte{object.TAG_I64}, Push: []byte{object.TAG_I64}}
+	i64Binary = Effect{Pop: []byte{object.TAG_I64, object.TAG_I64}, Push: []byte{object.TAG_I64}}
+	i64Cmp    = Effect{Pop: []byte{object.TAG_I64, object.TAG_I64}, Push: []byte{object.TAG_BOOL}}
+	f64Unary  = Effect{Pop: []byte{object.TAG_F64}, Push: []byte{object.TAG_F64}}
+	f64Binary = Effect{Pop: []byte{object.TAG_F64, object.TAG_F64}, Push: []byte{object.TAG_F64}}
+	f64Cmp    = Effect{Pop: []byte{object.TAG_F64, object.TAG_F64}, Push: []byte{object.TAG_BOOL}}
+	boolBin   = Effect{Pop: []byte{object.TAG_BOOL, object.TAG_BOOL}, Push: []byte{object.TAG_BOOL}}
+)
+
+// builtins describes every opcode of the vm in kind order.
+var builtins = []Opcode{
+	{Kind: OP_NULL, Mnemonic: "null"},
+	{Kind: OP_HALT, Mnemonic: "halt"},
+
+	{Kind: OP_I32_LOAD, Mnemonic: "i32.load", Operands: []Operand{OPERAND_I32}, Effect: Effect{Push: []byte{object.TAG_I32}}},
+	{Kind: OP_I32_NEG, Mnemonic: "i32.neg", Effect: i32Unary},
+	{Kind: OP_I32_ADD, Mnemonic: "i32.add", Effect: i32Binary},
+	{Kind: OP_I32_SUB, Mnemonic: "i32.sub", Effect: i32Binary},
+	{Kind: OP_I32_MUL, Mnemonic: "i32.mul", Effect: i32Binary},
+	{Kind: OP_I32_DIV, Mnemonic: "i32.div", Effect: i32Binary},
+	{Kind: OP_I32_LT, Mnemonic: "i32.lt", Effect: i32Cmp},
+	{Kind: OP_I32_GT, Mnemonic: "i32.gt", Effect: i32Cmp},
+	{Kind: OP_I32_LEQ, Mnemonic: "i32.leq", Effect: i32Cmp},
+	{Kind: OP_I32_GEQ, Mnemonic: "i32.geq", Effect: i32Cmp},
+	{Kind: OP_I32_EQ, Mnemonic: "i32.eq", Effect: i32Cmp},
+	{Kind: OP_I32_NEQ, Mnemonic: "i32.neq", Effect: i32Cmp},
+
+	{Kind: OP_BOOL_LOAD, Mnemonic: "bool.load", Operands: []Operand{OPERAND_BOOL}, Effect: Effect{Push: []byte{object.TAG_BOOL}}},
+	{Kind: OP_BOOL_AND, Mnemonic: "bool.and", Effect: boolBin},
+	{Kind: OP_BOOL_OR, Mnemonic: "bool.or", Effect: boolBin},
+	{Kind: OP_BOOL_NOT, Mnemonic: "bool.not", Effect: Effect{Pop: []byte{object.TAG_BOOL}, Push: []byte{object.TAG_BOOL}}},
+	{Kind: OP_BOOL_NAND, Mnemonic: "bool.nand", Effect: boolBin},
+	{Kind: OP_BOOL_NOR, Mnemonic: "bool.nor", Effect: boolBin},
+	{Kind: OP_BOOL_XOR, Mnemonic: "bool.xor", Effect: boolBin},
+
+	{Kind: OP_F32_LOAD, Mnemonic: "f32.load", Operands: []Operand{OPERAND_F32}, Effect: Effect{Push: []byte{object.TAG_F32}}},
+	{Kind: OP_F32_NEG, Mnemonic: "f32.neg", Effect: f32Unary},
+	{Kind: OP_F32_ADD, Mnemonic: "f32.add", Effect: f32Binary},
+	{Kind: OP_F32_SUB, Mnemonic: "f32.sub", Effect: f32Binary},
+	{Kind: OP_F32_MUL, Mnemonic: "f32.mul", Effect: f32Binary},
+	{Kind: OP_F32_DIV, Mnemonic: "f32.div", Effect: f32Binary},
+	{Kind: OP_F32_LT, Mnemonic: "f32.lt", Effect: f32Cmp},
+	{Kind: OP_F32_GT, Mnemonic: "f32.gt", Effect: f32Cmp},
+	{Kind: OP_F32_LEQ, Mnemonic: "f32.leq", Effect: f32Cmp},
+	{Kind: OP_F32_GEQ, Mnemonic: "f32.geq", Effect: f32Cmp},
+	{Kind: OP_F32_EQ, Mnemonic: "f32.eq", Effect: f32Cmp},
+	{Kind: OP_F32_NEQ, Mnemonic: "f32.neq", Effect: f32Cmp},
+
+	{Kind: OP_LIST_NEW, Mnemonic: "list.new", Operands: []Operand{OPERAND_LIST}, Effect: Effect{Push: []byte{object.TAG_LIST}}},
+	{Kind: OP_LIST_LENGTH, Mnemonic: "list.length", Effect: Effect{Pop: []byte{object.TAG_LIST}, Push: []byte{object.TAG_I32}}},
+	{Kind: OP_LIST_GET, Mnemonic: "list.get", Effect: Effect{Pop: []byte{object.TAG_LIST, object.TAG_I32}, Push: []byte{ANY}}},
+	{Kind: OP_LIST_INSERT, Mnemonic: "list.insert", Effect: Effect{Pop: []byte{object.TAG_LIST, object.TAG_I32, ANY}, Push: []byte{object.TAG_LIST}}},
+	{Kind: OP_LIST_REPLACE, Mnemonic: "list.replace", Effect: Effect{Pop: []byte{object.TAG_LIST, object.TAG_I32, ANY}, Push: []byte{object.TAG_LIST}}},
+	{Kind: OP_LIST_REMOVE, Mnemonic: "list.remove", Effect: Effect{Pop: []byte{object.TAG_LIST, object.TAG_I32}, Push: []byte{object.TAG_LIST}}},
+
+	{Kind: OP_STRING_LOAD, Mnemonic: "string.load", Operands: []Operand{OPERAND_STRING}, Effect: Effect{Push: []byte{object.TAG_STRING}}},
+	{Kind: OP_STRING_CONCAT, Mnemonic: "string.concat", Effect: Effect{Pop: []byte{object.TAG_STRING, object.TAG_STRING}, Push: []byte{object.TAG_STRING}}},
+	{Kind: OP_STRING_FORMAT, Mnemonic: "string.format", Variadic: true},
+	{Kind: OP_STRING_LENGTH, Mnemonic: "string.length", Effect: Effect{Pop: []byte{object.TAG_STRING}, Push: []byte{object.TAG_I32}}},
+	{Kind: OP_STRING_SPLIT, Mnemonic: "string.split", Effect: Effect{Pop: []byte{object.TAG_STRING, object.TAG_STRING}, Push: []byte{object.TAG_LIST}}},
+
+	{Kind: OP_STRUCT_NEW, Mnemonic: "struct.new", Operands: []Operand{OPERAND_TAGS}, Effect: Effect{Push: []byte{object.TAG_STRUCT}}},
+	{Kind: OP_STRUCT_GET, Mnemonic: "struct.get", Effect: Effect{Pop: []byte{object.TAG_STRUCT, object.TAG_I32}, Push: []byte{ANY}}},
+	{Kind: OP_STRUCT_SET, Mnemonic: "struct.set", Effect: Effect{Pop: []byte{object.TAG_STRUCT, object.TAG_I32, ANY}, Push: []byte{object.TAG_STRUCT}}},
+
+	{Kind: OP_TO_STRING, Mnemonic: "to_string", Effect: Effect{Pop: []byte{ANY}, Push: []byte{object.TAG_STRING}}},
+	{Kind: OP_TO_I32, Mnemonic: "to_i32", Effect: Effect{Pop: []byte{ANY}, Push: []byte{object.TAG_I32}}},
+	{Kind: OP_TO_F32, Mnemonic: "to_f32", Effect: Effect{Pop: []byte{ANY}, Push: []byte{object.TAG_F32}}},
+	{Kind: OP_TO_BOOL, Mnemonic: "to_bool", Effect: Effect{Pop: []byte{ANY}, Push: []byte{object.TAG_BOOL}}},
+
+	{Kind: OP_JUMP, Mnemonic: "jump", Operands: []Operand{OPERAND_ADDR}},
+	{Kind: OP_JUMPC, Mnemonic: "jumpc", Operands: []Operand{OPERAND_ADDR}, Effect: Effect{Pop: []byte{object.TAG_BOOL}}},
+	{Kind: OP_JUMPNC, Mnemonic: "jumpnc", Operands: []Operand{OPERAND_ADDR}, Effect: Effect{Pop: []byte{object.TAG_BOOL}}},
+
+	{Kind: OP_BLOCK_START, Mnemonic: "block.block", Operands: []Operand{OPERAND_ADDR}},
+	{Kind: OP_BLOCK_END, Mnemonic: "block.end"},
+	{Kind: OP_BLOCK_BR, Mnemonic: "block.br"},
+	{Kind: OP_BLOCK_LOAD, Mnemonic: "block.load", Operands: []Operand{OPERAND_SLOT}, Effect: Effect{Push: []byte{ANY}}},
+	{Kind: OP_BLOCK_SAVE, Mnemonic: "block.save", Operands: []Operand{OPERAND_SLOT}, Effect: Effect{Pop: []byte{ANY}}},
+
+	{Kind: OP_LOAD, Mnemonic: "load", Operands: []Operand{OPERAND_SLOT}, Effect: Effect{Push: []byte{ANY}}},
+	{Kind: OP_SAVE, Mnemonic: "save", Operands: []Operand{OPERAND_SLOT}, Effect: Effect{Pop: []byte{ANY}}},
+	{Kind: OP_NEW, Mnemonic: "new", Effect: Effect{Pop: []byte{ANY}}},
+	{Kind: OP_FREE, Mnemonic: "free", Operands: []Operand{OPERAND_SLOT}},
+	{Kind: OP_POP, Mnemonic: "pop", Effect: Effect{Pop: []byte{ANY}}},
+
+	{Kind: OP_PRINT, Mnemonic: "print", Effect: Effect{Pop: []byte{ANY}}},
+	{Kind: OP_PRINTF, Mnemonic: "printf", Variadic: true},
+	{Kind: OP_PRINTLN, Mnemonic: "println", Effect: Effect{Pop: []byte{ANY}}},
+	{Kind: OP_READ, Mnemonic: "read", Effect: Effect{Push: []byte{object.TAG_STRING}}},
+
+	{Kind: OP_FUNC_CALL, Mnemonic: "func.call", Operands: []Operand{OPERAND_ADDR, OPERAND_UINT}, Variadic: true},
+	{Kind: OP_FUNC_RET, Mnemonic: "func.ret", Operands: []Operand{OPERAND_UINT}, Variadic: true},
+
+	{Kind: OP_LOCAL_LOAD, Mnemonic: "local.load", Operands: []Operand{OPERAND_SLOT}, Effect: Effect{Push: []byte{ANY}}},
+	{Kind: OP_LOCAL_SAVE, Mnemonic: "local.save", Operands: []Operand{OPERAND_SLOT}, Effect: Effect{Pop: []byte{ANY}}},
+
+	{Kind: OP_NATIVE_CALL, Mnemonic: "native.call", Operands: []Operand{OPERAND_UINT}, Variadic: true},
+
+	{Kind: OP_STRUCT_MAKE, Mnemonic: "struct.make", Operands: []Operand{OPERAND_TYPE}, Effect: Effect{Push: []byte{object.TAG_STRUCT}}},
+
+	{Kind: OP_MAP_NEW, Mnemonic: "map.new", Operands: []Operand{OPERAND_TAG, OPERAND_TAG}, Effect: Effect{Push: []byte{object.TAG_MAP}}},
+	{Kind: OP_MAP_GET, Mnemonic: "map.get", Effect: Effect{Pop: []byte{object.TAG_MAP, ANY}, Push: []byte{ANY}}},
+	{Kind: OP_MAP_SET, Mnemonic: "map.set", Effect: Effect{Pop: []byte{object.TAG_MAP, ANY, ANY}, Push: []byte{object.TAG_MAP}}},
+	{Kind: OP_MAP_DELETE, Mnemonic: "map.delete", Effect: Effect{Pop: []byte{object.TAG_MAP, ANY}, Push: []byte{object.TAG_MAP}}},
+	{Kind: OP_MAP_HAS, Mnemonic: "map.has", Effect: Effect{Pop: []byte{object.TAG_MAP, ANY}, Push: []byte{object.TAG_BOOL}}},
+	{Kind: OP_MAP_LEN, Mnemonic: "map.len", Effect: Effect{Pop: []byte{object.TAG_MAP}, Push: []byte{object.TAG_I32}}},
+	{Kind: OP_MAP_KEYS, Mnemonic: "map.keys", Effect: Effect{Pop: []byte{object.TAG_MAP}, Push: []byte{object.TAG_LIST}}},
+
+	{Kind: OP_I64_LOAD, Mnemonic: "i64.load", Operands: []Operand{OPERAND_I64}, Effect: Effect{Push: []byte{object.TAG_I64}}},
+	{Kind: OP_I64_NEG, Mnemonic: "i64.neg", Effect: i64Unary},
+	{Kind: OP_I64_ADD, Mnemonic: "i64.add", Effect: i64Binary},
+	{Kind: OP_I64_SUB, Mnemonic: "i64.sub", Effect: i64Binary},
+	{Kind: OP_I64_MUL, Mnemonic: "i64.mul", Effect: i64Binary},
+	{Kind: OP_I64_DIV, Mnemonic: "i64.div", Effect: i64Binary},
+	{Kind: OP_I64_LT, Mnemonic: "i64.lt", Effect: i64Cmp},
+	{Kind: OP_I64_GT, Mnemonic: "i64.gt", Effect: i64Cmp},
+	{Kind: OP_I64_LEQ, Mnemonic: "i64.leq", Effect: i64Cmp},
+	{Kind: OP_I64_GEQ, Mnemonic: "i64.geq", Effect: i64Cmp},
+	{Kind: OP_I64_EQ, Mnemonic: "i64.eq", Effect: i64Cmp},
+	{Kind: OP_I64_NEQ, Mnemonic: "i64.neq", Effect: i64Cmp},
+
+	{Kind: OP_F64_LOAD, Mnemonic: "f64.load", Operands: []Operand{OPERAND_F64}, Effect: Effect{Push: []byte{object.TAG_F64}}},
+	{Kind: OP_F64_NEG, Mnemonic: "f64.neg", Effect: f64Unary},
+	{Kind: OP_F64_ADD, Mnemonic: "f64.add", Effect: f64Binary},
+	{Kind: OP_F64_SUB, Mnemonic: "f64.sub", Effect: f64Binary},
+	{Kind: OP_F64_MUL, Mnemonic: "f64.mul", Effect: f64Binary},
+	{Kind: OP_F64_DIV, Mnemonic: "f64.div", Effect: f64Binary},
+	{Kind: OP_F64_LT, Mnemonic: "f64.lt", Effect: f64Cmp},
+	{Kind: OP_F64_GT, Mnemonic: "f64.gt", Effect: f64Cmp},
+	{Kind: OP_F64_LEQ, Mnemonic: "f64.leq", Effect: f64Cmp},
+	{Kind: OP_F64_GEQ, Mnemonic: "f64.geq", Effect: f64Cmp},
+	{Kind: OP_F64_EQ, Mnemonic: "f64.eq", Effect: f64Cmp},
+	{Kind: OP_F64_NEQ, Mnemonic: "f64.neq", Effect: f64Cmp},
+
+	{Kind: OP_TO_I64, Mnemonic: "to_i64", Effect: Effect{Pop: []byte{ANY}, Push: []byte{object.TAG_I64}}},
+	{Kind: OP_TO_F64, Mnemonic: "to_f64", Effect: Effect{Pop: []byte{ANY}, Push: []byte{object.TAG_F64}}},
+
+	{Kind: OP_I32_REM, Mnemonic: "i32.rem", Effect: i32Binary},
+	{Kind: OP_I32_AND, Mnemonic: "i32.and", Effect: i32Binary},
+	{Kind: OP_I32_OR, Mnemonic: "i32.or", Effect: i32Binary},
+	{Kind: OP_I32_XOR, Mnemonic: "i32.xor", Effect: i32Binary},
+	{Kind: OP_I32_NOT, Mnemonic: "i32.not", Effect: i32Unary},
+	{Kind: OP_I32_SHL, Mnemonic: "i32.shl", Effect: i32Binary},
+	{Kind: OP_I32_SHR, Mnemonic: "i32.shr", Effect: i32Binary},
+	{Kind: OP_I32_SHRU, Mnemonic: "i32.shru", Effect: i32Binary},
+
+	{Kind: OP_F32_SQRT, Mnemonic: "f32.sqrt", Effect: f32Unary},
+	{Kind: OP_F32_POW, Mnemonic: "f32.pow", Effect: f32Binary},
+	{Kind: OP_F32_FLOOR, Mnemonic: "f32.floor", Effect: f32Unary},
+	{Kind: OP_F32_CEIL, Mnemonic: "f32.ceil", Effect: f32Unary},
+	{Kind: OP_F32_ROUND, Mnemonic: "f32.round", Effect: f32Unary},
+	{Kind: OP_F32_ABS, Mnemonic: "f32.abs", Effect: f32Unary},
+	{Kind: OP_F32_MIN, Mnemonic: "f32.min", Effect: f32Binary},
+	{Kind: OP_F32_MAX, Mnemonic: "f32.max", Effect: f32Binary},
+	{Kind: OP_F32_SIN, Mnemonic: "f32.sin", Effect: f32Unary},
+	{Kind: OP_F32_COS, Mnemonic: "f32.cos", Effect: f32Unary},
+	{Kind: OP_F32_EXP, Mnemonic: "f32.exp", Effect: f32Unary},
+	{Kind: OP_F32_LOG, Mnemonic: "f32.log", Effect: f32Unary},
+	{Kind: OP_F32_IS_NAN, Mnemonic: "f32.is_nan", Effect: Effect{Pop: []byte{object.TAG_F32}, Push: []byte{object.TAG_BOOL}}},
+	{Kind: OP_F32_IS_INF, Mnemonic: "f32.is_inf", Effect: Effect{Pop: []byte{object.TAG_F32}, Push: []byte{object.TAG_BOOL}}},
+
+	{Kind: OP_STRING_RUNE_LENGTH, Mnemonic: "string.rune_length", Effect: Effect{Pop: []byte{object.TAG_STRING}, Push: []byte{object.TAG_I32}}},
+	{Kind: OP_STRING_SLICE, Mnemonic: "string.slice", Effect: Effect{Pop: []byte{object.TAG_STRING, object.TAG_I32, object.TAG_I32}, Push: []byte{object.TAG_STRING}}},
+	{Kind: OP_STRING_RUNE_SLICE, Mnemonic: "string.rune_slice", Effect: Effect{Pop: []byte{object.TAG_STRING, object.TAG_I32, object.TAG_I32}, Push: []byte{object.TAG_STRING}}},
+	{Kind: OP_STRING_INDEX, Mnemonic: "string.index", Effect: Effect{Pop: []byte{object.TAG_STRING, object.TAG_STRING}, Push: []byte{object.TAG_I32}}},
+	{Kind: OP_STRING_RUNE_INDEX, Mnemonic: "string.rune_index", Effect: Effect{Pop: []byte{object.TAG_STRING, object.TAG_STRING}, Push: []byte{object.TAG_I32}}},
+	{Kind: OP_STRING_CHAR_AT, Mnemonic: "string.char_at", Effect: Effect{Pop: []byte{object.TAG_STRING, object.TAG_I32}, Push: []byte{object.TAG_STRING}}},
+	{Kind: OP_STRING_RUNE_AT, Mnemonic: "string.rune_at", Effect: Effect{Pop: []byte{object.TAG_STRING, object.TAG_I32}, Push: []byte{object.TAG_STRING}}},
+	{Kind: OP_STRING_CONTAINS, Mnemonic: "string.contains", Effect: Effect{Pop: []byte{object.TAG_STRING, object.TAG_STRING}, Push: []byte{object.TAG_BOOL}}},
+	{Kind: OP_STRING_HAS_PREFIX, Mnemonic: "string.has_prefix", Effect: Effect{Pop: []byte{object.TAG_STRING, object.TAG_STRING}, Push: []byte{object.TAG_BOOL}}},
+	{Kind: OP_STRING_HAS_SUFFIX, Mnemonic: "string.has_suffix", Effect: Effect{Pop: []byte{object.TAG_STRING, object.TAG_STRING}, Push: []byte{object.TAG_BOOL}}},
+	{Kind: OP_STRING_REPLACE, Mnemonic: "string.replace", Effect: Effect{Pop: []byte{object.TAG_STRING, object.TAG_STRING, object.TAG_STRING}, Push: []byte{object.TAG_STRING}}},
+	{Kind: OP_STRING_TRIM, Mnemonic: "string.trim", Effect: Effect{Pop: []byte{object.TAG_STRING}, Push: []byte{object.TAG_STRING}}},
+	{Kind: OP_STRING_UPPER, Mnemonic: "string.upper", Effect: Effect{Pop: []byte{object.TAG_STRING}, Push: []byte{object.TAG_STRING}}},
+	{Kind: OP_STRING_LOWER, Mnemonic: "string.lower", Effect: Effect{Pop: []byte{object.TAG_STRING}, Push: []byte{object.TAG_STRING}}},
+	{Kind: OP_STRING_JOIN, Mnemonic: "string.join", Effect: Effect{Pop: []byte{object.TAG_LIST, object.TAG_STRING}, Push: []byte{object.TAG_STRING}}},
+	{Kind: OP_STRING_COMPARE, Mnemonic: "string.compare", Effect: Effect{Pop: []byte{object.TAG_STRING, object.TAG_STRING}, Push: []byte{object.TAG_I32}}},
+
+	{Kind: OP_LIST_APPEND, Mnemonic: "list.append", Effect: Effect{Pop: []byte{object.TAG_LIST, ANY}, Push: []byte{object.TAG_LIST}}},
+	{Kind: OP_LIST_POP, Mnemonic: "list.pop", Effect: Effect{Pop: []byte{object.TAG_LIST}, Push: []byte{object.TAG_LIST, ANY}}},
+	{Kind: OP_LIST_SLICE, Mnemonic: "list.slice", Effect: Effect{Pop: []byte{object.TAG_LIST, object.TAG_I32, object.TAG_I32}, Push: []byte{object.TAG_LIST}}},
+	{Kind: OP_LIST_CONCAT, Mnemonic: "list.concat", Effect: Effect{Pop: []byte{object.TAG_LIST, object.TAG_LIST}, Push: []byte{object.TAG_LIST}}},
+	{Kind: OP_LIST_REVERSE, Mnemonic: "list.reverse", Effect: Effect{Pop: []byte{object.TAG_LIST}, Push: []byte{object.TAG_LIST}}},
+	{Kind: OP_LIST_INDEX, Mnemonic: "list.index", Effect: Effect{Pop: []byte{object.TAG_LIST, ANY}, Push: []byte{object.TAG_I32}}},
+	{Kind: OP_LIST_CONTAINS, Mnemonic: "list.contains", Effect: Effect{Pop: []byte{object.TAG_LIST, ANY}, Push: []byte{object.TAG_BOOL}}},
+	{Kind: OP_LIST_SORT, Mnemonic: "list.sort", Effect: Effect{Pop: []byte{object.TAG_LIST}, Push: []byte{object.TAG_LIST}}},
+
+	{Kind: OP_REF_NEW, Mnemonic: "ref.new", Effect: Effect{Pop: []byte{ANY}, Push: []byte{object.TAG_REF}}},
+	{Kind: OP_REF_GET, Mnemonic: "ref.get", Effect: Effect{Pop: []byte{object.TAG_REF}, Push: []byte{ANY}}},
+	{Kind: OP_REF_SET, Mnemonic: "ref.set", Effect: Effect{Pop: []byte{object.TAG_REF, ANY}}},
+	{Kind: OP_REF_COPY, Mnemonic: "ref.copy", Effect: Effect{Pop: []byte{object.TAG_REF}, Push: []byte{object.TAG_REF}}},
+	{Kind: OP_REF_LENGTH, Mnemonic: "ref.length", Effect: Effect{Pop: []byte{object.TAG_REF}, Push: []byte{object.TAG_I32}}},
+	{Kind: OP_REF_AT, Mnemonic: "ref.at", Effect: Effect{Pop: []byte{object.TAG_REF, ANY}, Push: []byte{ANY}}},
+	{Kind: OP_REF_APPEND, Mnemonic: "ref.append", Effect: Effect{Pop: []byte{object.TAG_REF, ANY}}},
+	{Kind: OP_REF_INSERT, Mnemonic: "ref.insert", Effect: Effect{Pop: []byte{object.TAG_REF, object.TAG_I32, ANY}}},
+	{Kind: OP_REF_REMOVE, Mnemonic: "ref.remove", Effect: Effect{Pop: []byte{object.TAG_REF, ANY}}},
+	{Kind: OP_REF_REPLACE, Mnemonic: "ref.replace", Effect: Effect{Pop: []byte{object.TAG_REF, ANY, ANY}}},
+
+	{Kind: OP_GC, Mnemonic: "gc"},
+
+	{Kind: OP_FUNC_LOAD, Mnemonic: "func.load", Operands: []Operand{OPERAND_ADDR, OPERAND_UINT, OPERAND_UINT}, Effect: Effect{Push: []byte{object.TAG_FUNC}}},
+	{Kind: OP_FUNC_CALL_INDIRECT, Mnemonic: "func.call_indirect", Operands: []Operand{OPERAND_UINT, OPERAND_UINT}, Variadic: true},
+
+	{Kind: OP_FUNC_CLOSURE, Mnemonic: "func.closure", Operands: []Operand{OPERAND_UINT}, Variadic: true},
+	{Kind: OP_CLOSURE_LOAD, Mnemonic: "closure.load", Operands: []Operand{OPERAND_UINT}, Effect: Effect{Push: []byte{ANY}}},
+	{Kind: OP_CLOSURE_SAVE, Mnemonic: "closure.save", Operands: []Operand{OPERAND_UINT}, Effect: Effect{Pop: []byte{ANY}}},
+
+	{Kind: OP_FUNC_TAILCALL, Mnemonic: "func.tailcall", Operands: []Operand{OPERAND_ADDR, OPERAND_UINT}, Variadic: true},
+}
@@ -0,0 +1,45 @@
+package instruction
+
+func RefNew() Instruction {
+	return Instruction{Kind: OP_REF_NEW}
+}
+
+func RefGet() Instruction {
+	return Instruction{Kind: OP_REF_GET}
+}
+
+func RefSet() Instruction {
+	return Instruction{Kind: OP_REF_SET}
+}
+
+func RefCopy() Instruction {
+	return Instruction{Kind: OP_REF_COPY}
+}
+
+func RefLength() Instruction {
+	return Instruction{Kind: OP_REF_LENGTH}
+}
+
+func RefAt() Instruction {
+	return Instruction{Kind: OP_REF_AT}
+}
+
+func RefAppend() Instruction {
+	return Instruction{Kind: OP_REF_APPEND}
+}
+
+func RefInsert() Instruction {
+	return Instruction{Kind: OP_REF_INSERT}
+}
+
+func RefRemove() Instruction {
+	return Instruction{Kind: OP_REF_REMOVE}
+}
+
+func RefReplace() Instruction {
+	return Instruction{Kind: OP_REF_REPLACE}
+}
+
+func GC() Instruction {
+	return Instruction{Kind: OP_GC}
+}
@@ -21,3 +21,71 @@ func StringSplit() Instruction {
 func StringFormat() Instruction {
 	return Instruction{Kind: OP_STRING_FORMAT}
 }
+
+func StringLength() Instruction {
+	return Instruction{Kind: OP_STRING_LENGTH}
+}
+
+func StringRuneLength() Instruction {
+	return Instruction{Kind: OP_STRING_RUNE_LENGTH}
+}
+
+func StringSlice() Instruction {
+	return Instruction{Kind: OP_STRING_SLICE}
+}
+
+func StringRuneSlice() Instruction {
+	return Instruction{Kind: OP_STRING_RUNE_SLICE}
+}
+
+func StringIndex() Instruction {
+	return Instruction{Kind: OP_STRING_INDEX}
+}
+
+func StringRuneIndex() Instruction {
+	return Instruction{Kind: OP_STRING_RUNE_INDEX}
+}
+
+func StringCharAt() Instruction {
+	return Instruction{Kind: OP_STRING_CHAR_AT}
+}
+
+func StringRuneAt() Instruction {
+	return Instruction{Kind: OP_STRING_RUNE_AT}
+}
+
+func StringContains() Instruction {
+	return Instruction{Kind: OP_STRING_CONTAINS}
+}
+
+func StringHasPrefix() Instruction {
+	return Instruction{Kind: OP_STRING_HAS_PREFIX}
+}
+
+func StringHasSuffix() Instruction {
+	return Instruction{Kind: OP_STRING_HAS_SUFFIX}
+}
+
+func StringReplace() Instruction {
+	return Instruction{Kind: OP_STRING_REPLACE}
+}
+
+func StringTrim() Instruction {
+	return Instruction{Kind: OP_STRING_TRIM}
+}
+
+func StringUpper() Instruction {
+	return Instruction{Kind: OP_STRING_UPPER}
+}
+
+func StringLower() Instruction {
+	return Instruction{Kind: OP_STRING_LOWER}
+}
+
+func StringJoin() Instruction {
+	return Instruction{Kind: OP_STRING_JOIN}
+}
+
+func StringCompare() Instruction {
+	return Instruction{Kind: OP_STRING_COMPARE}
+}
@@ -1,23 +1,24 @@
 package instruction
 
-import "cvm/object"
+import (
+	"cvm/object"
+	"encoding/binary"
+)
 
 func StructNew(tags ...byte) Instruction {
-	lO, err := object.CreateI32(int32(len(tags)))
+	obj, err := object.CreateNamedStruct("", nil, tags)
 	if err != nil {
 		panic(err)
 	}
-	buf := []byte{object.TAG_STRUCT}
-	buf = append(buf, object.Bytes(lO)...)
-	buf = append(buf, tags...)
-	for _, tag := range tags {
-		obj, err := object.CreateDefault(tag)
-		if err != nil {
-			panic(err)
-		}
-		buf = append(buf, object.Bytes(obj)...)
-	}
-	return Instruction{Kind: OP_STRUCT_NEW, Operands: buf}
+	return Instruction{Kind: OP_STRUCT_NEW, Operands: object.Bytes(obj)}
+}
+
+// StructMake creates a struct of the module type with index typ.
+func StructMake(typ uint32) Instruction {
+	buf := make([]byte, 0, 5)
+	buf = append(buf, object.TAG_I32)
+	buf = binary.LittleEndian.AppendUint32(buf, typ)
+	return Instruction{Kind: OP_STRUCT_MAKE, Operands: buf}
 }
 
 func StructGet() Instruction {
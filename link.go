@@ -0,0 +1,157 @@
+package cvm
+
+import (
+	"cvm/instruction"
+	"cvm/object"
+	"fmt"
+)
+
+// Program is an instruction stream linked for execution: operands are decoded once by Link
+// instead of on every step, ops[ip] is the linked form of Code[ip].
+type Program struct {
+	Code []instruction.Instruction
+	ops  []op
+	// src and types are what the program was linked from, src copies the instructions of Code.
+	src   []instruction.Instruction
+	types []instruction.TypeDecl
+}
+
+// op is a pre-decoded instruction. arg holds the jump or call address, slot index, native index or
+// result count, argc the argument count of func.call and func.call_indirect and obj the constant
+// pushed by the load instructions.
+// instr is the instruction op was linked from, handlers of custom opcodes decode it themselves.
+type op struct {
+	kind  byte
+	arg   int32
+	argc  int32
+	obj   object.CVMObject
+	instr *instruction.Instruction
+}
+
+// Link decodes the operands of instrs, struct.make defaults are built from types.
+// A malformed operand is reported as *VMError of kind ERR_INVALID_OPERAND at its instruction.
+func Link(instrs []instruction.Instruction, types []instruction.TypeDecl) (*Program, error) {
+	p := &Program{Code: instrs, ops: make([]op, len(instrs)), src: append([]instruction.Instruction(nil), instrs...), types: types}
+	for ip := range instrs {
+		o, err := linkOp(&instrs[ip], types)
+		if err != nil {
+			return nil, newError(instrs, uint32(ip), fmt.Errorf("%w: %w", ErrInvalidOperand, err))
+		}
+		p.ops[ip] = o
+	}
+	return p, nil
+}
+
+// linkedFrom reports whether p was linked from instrs and types, and none of the instructions was replaced since.
+// Operand bytes changed in place are not noticed.
+func (p *Program) linkedFrom(instrs []instruction.Instruction, types []instruction.TypeDecl) bool {
+	if len(p.src) != len(instrs) || len(p.types) != len(types) {
+		return false
+	}
+	if len(instrs) > 0 && &p.Code[0] != &instrs[0] || len(types) > 0 && &p.types[0] != &types[0] {
+		return false
+	}
+	for ip := range instrs {
+		old, cur := &p.src[ip], &instrs[ip]
+		if old.Kind != cur.Kind || len(old.Operands) != len(cur.Operands) ||
+			len(cur.Operands) > 0 && &old.Operands[0] != &cur.Operands[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func linkOp(instr *instruction.Instruction, types []instruction.TypeDecl) (op, error) {
+	o := op{kind: instr.Kind, instr: instr}
+	var err error
+	// Format rejects operands the constructors wouldn't produce, the cases below then only decode them.
+	// Unknown kinds are left to fail when they run.
+	if _, ok := instruction.Info(instr.Kind); ok {
+		if _, err := instr.Format(nil); err != nil {
+			return o, err
+		}
+	}
+	if instr.Kind >= instruction.OP_CUSTOM_FIRST {
+		return o, nil
+	}
+	switch instr.Kind {
+	case instruction.OP_I32_LOAD, instruction.OP_BOOL_LOAD, instruction.OP_F32_LOAD,
+		instruction.OP_I64_LOAD, instruction.OP_F64_LOAD, instruction.OP_STRING_LOAD:
+		if len(instr.Operands) == 0 {
+			return o, fmt.Errorf("missing constant")
+		}
+		o.obj, err = object.CreateObject(instr.Operands)
+	case instruction.OP_LIST_NEW:
+		o.obj, err = object.CreateList(instr.Operands)
+	case instruction.OP_STRUCT_NEW:
+		o.obj, err = object.CreateStruct(instr.Operands)
+	case instruction.OP_MAP_NEW:
+		if len(instr.Operands) < 2 {
+			return o, fmt.Errorf("map.new needs key and value tags")
+		}
+		o.obj, err = object.CreateMap(instr.Operands[0], instr.Operands[1])
+	case instruction.OP_STRUCT_MAKE:
+		typ, err := instr.OperandI32(0)
+		if err != nil {
+			return o, err
+		}
+		o.arg = int32(typ)
+		// an unknown type is reported when struct.make runs, like before linking existed
+		if typ < uint32(len(types)) {
+			o.obj, err = types[typ].Default()
+		}
+		return o, err
+	case instruction.OP_FUNC_CALL, instruction.OP_FUNC_TAILCALL:
+		addr, err := instr.OperandI32(0)
+		if err != nil {
+			return o, err
+		}
+		argc, err := instr.OperandI32(1)
+		if err != nil {
+			return o, err
+		}
+		o.arg, o.argc = int32(addr), int32(argc)
+	case instruction.OP_FUNC_LOAD:
+		var vals [3]uint32
+		for n := range vals {
+			if vals[n], err = instr.OperandI32(n); err != nil {
+				return o, err
+			}
+		}
+		o.obj, err = object.CreateFunc(vals[0], vals[1], vals[2])
+	case instruction.OP_FUNC_CALL_INDIRECT:
+		argc, err := instr.OperandI32(0)
+		if err != nil {
+			return o, err
+		}
+		results, err := instr.OperandI32(1)
+		if err != nil {
+			return o, err
+		}
+		o.arg, o.argc = int32(results), int32(argc)
+	case instruction.OP_FUNC_CLOSURE:
+		n, err := instr.OperandI32(0)
+		if err != nil {
+			return o, err
+		}
+		o.argc = int32(n)
+	case instruction.OP_CLOSURE_LOAD, instruction.OP_CLOSURE_SAVE:
+		// the index is kept as an i32 object, the form struct fields are addressed with
+		ind, err := instr.OperandI32(0)
+		if err != nil {
+			return o, err
+		}
+		o.obj, err = object.CreateI32(int32(ind))
+		return o, err
+	case instruction.OP_JUMP, instruction.OP_JUMPC, instruction.OP_JUMPNC, instruction.OP_BLOCK_START,
+		instruction.OP_BLOCK_LOAD, instruction.OP_BLOCK_SAVE, instruction.OP_LOAD, instruction.OP_SAVE,
+		instruction.OP_FREE, instruction.OP_FUNC_RET, instruction.OP_LOCAL_LOAD, instruction.OP_LOCAL_SAVE,
+		instruction.OP_NATIVE_CALL:
+		val, err := instr.OperandI32(0)
+		if err != nil {
+			return o, err
+		}
+		o.arg = int32(val)
+	}
+	return o, err
+}
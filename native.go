@@ -0,0 +1,82 @@
+package cvm
+
+import (
+	"context"
+	"cvm/object"
+	"fmt"
+)
+
+// NativeFunc receives the popped arguments bottom to top and returns the values to push in order.
+type NativeFunc func(ctx context.Context, vm *CVM, args []object.CVMObject) ([]object.CVMObject, error)
+
+// Native is a Go function callable from bytecode with native.call.
+// Params and Results hold the expected object tags, TAG_UNDEFINED accepts any tag.
+type Native struct {
+	Name    string
+	Params  []byte
+	Results []byte
+	Fn      NativeFunc
+}
+
+// RegisterNative adds a host function and returns the index native.call uses to reach it.
+func (vm *CVM) RegisterNative(name string, params, results []byte, fn NativeFunc) (uint32, error) {
+	if fn == nil {
+		return 0, fmt.Errorf("native %s has no function", name)
+	}
+	if _, ok := vm.NativeIndex(name); ok {
+		return 0, fmt.Errorf("native %s already registered", name)
+	}
+	vm.Natives = append(vm.Natives, Native{
+		Name:    name,
+		Params:  params,
+		Results: results,
+		Fn:      fn,
+	})
+	return uint32(len(vm.Natives) - 1), nil
+}
+
+func (vm *CVM) NativeIndex(name string) (uint32, bool) {
+	for ind, native := range vm.Natives {
+		if native.Name == name {
+			return uint32(ind), true
+		}
+	}
+	return 0, false
+}
+
+func (vm *CVM) CallNative(ctx context.Context, ind uint32) error {
+	if ind >= uint32(len(vm.Natives)) {
+		return fmt.Errorf("%w: %d", ErrUnknownNative, ind)
+	}
+	native := vm.Natives[ind]
+	if vm.SP < uint(len(native.Params)) {
+		return fmt.Errorf("%w: native %s needs %d arguments", ErrStackUnderflow, native.Name, len(native.Params))
+	}
+	args := make([]object.CVMObject, len(native.Params))
+	for i := len(args) - 1; i >= 0; i-- {
+		obj, err := vm.Pop(ctx)
+		if err != nil {
+			return err
+		}
+		if tag := native.Params[i]; tag != object.TAG_UNDEFINED && tag != obj.Tag {
+			return fmt.Errorf("%w: native %s argument %d expects %s, got %s", object.ErrTypeMismatch, native.Name, i, object.TagsName(tag), object.TagsName(obj.Tag))
+		}
+		args[i] = obj
+	}
+	res, err := native.Fn(ctx, vm, args)
+	if err != nil {
+		return fmt.Errorf("%w %s: %w", ErrNative, native.Name, err)
+	}
+	if len(res) != len(native.Results) {
+		return fmt.Errorf("%w %s: returned %d values, declared %d", ErrNative, native.Name, len(res), len(native.Results))
+	}
+	for i, obj := range res {
+		if tag := native.Results[i]; tag != object.TAG_UNDEFINED && tag != obj.Tag {
+			return fmt.Errorf("%w %s: result %d is %s, declared %s", ErrNative, native.Name, i, object.TagsName(obj.Tag), object.TagsName(tag))
+		}
+		if err := vm.Push(ctx, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
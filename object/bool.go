@@ -4,23 +4,22 @@ import "fmt"
 
 // constructor
 
+// boolData is shared by every bool CreateBool returns, scalar data is never written in place.
+// The capacity is capped so appending to it copies.
+var boolData = [2][]byte{[]byte{0}[:1:1], []byte{1}[:1:1]}
+
 func CreateBool(val bool) (CVMObject, error) {
-	var obj CVMObject
-	obj.Data = nil
-	obj.Tag = TAG_BOOL
 	if val {
-		obj.Data = append(obj.Data, 1)
-	} else {
-		obj.Data = append(obj.Data, 0)
+		return CVMObject{Tag: TAG_BOOL, Data: boolData[1]}, nil
 	}
-	return obj, nil
+	return CVMObject{Tag: TAG_BOOL, Data: boolData[0]}, nil
 }
 
 // manipulation
 
 func ValueBool(obj CVMObject) (bool, error) {
 	if obj.Tag != TAG_BOOL {
-		return false, fmt.Errorf("can't get Data, object tag is %s, not bool", TagsName(obj.Tag))
+		return false, fmt.Errorf("%w: can't get Data, object tag is %s, not bool", ErrTypeMismatch, TagsName(obj.Tag))
 	}
 	return obj.Data[0] > 0, nil
 }
@@ -41,6 +40,18 @@ func AsBool(obj CVMObject) (CVMObject, error) {
 			return CVMObject{}, err
 		}
 		return CreateBool(val != 0.0)
+	case TAG_I64:
+		val, err := ValueI64(obj)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		return CreateBool(val != 0)
+	case TAG_F64:
+		val, err := ValueF64(obj)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		return CreateBool(val != 0.0)
 	case TAG_LIST:
 		l, err := Len(obj)
 		if err != nil {
@@ -48,13 +59,13 @@ func AsBool(obj CVMObject) (CVMObject, error) {
 		}
 		return CreateBool(l > 0)
 	default:
-		return CVMObject{}, fmt.Errorf("can't convert %s to f32", TagsName(obj.Tag))
+		return CVMObject{}, fmt.Errorf("%w: can't convert %s to bool", ErrTypeMismatch, TagsName(obj.Tag))
 	}
 }
 
 func StringBool(obj CVMObject) (string, error) {
 	if obj.Tag != TAG_BOOL {
-		return "", fmt.Errorf("expected bool, got %s", TagsName(obj.Tag))
+		return "", fmt.Errorf("%w: expected bool, got %s", ErrTypeMismatch, TagsName(obj.Tag))
 	}
 	val, err := ValueBool(obj)
 	return fmt.Sprintf("(%s)%v", TagsName(obj.Tag), val), err
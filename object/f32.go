@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
+	"strconv"
 )
 
 func CreateF32(val float32) (CVMObject, error) {
@@ -16,7 +17,7 @@ func CreateF32(val float32) (CVMObject, error) {
 
 func ValueF32(obj CVMObject) (float32, error) {
 	if obj.Tag != TAG_F32 {
-		return 0, fmt.Errorf("can't get Data, object tag is %s, not f32", TagsName(obj.Tag))
+		return 0, fmt.Errorf("%w: can't get Data, object tag is %s, not f32", ErrTypeMismatch, TagsName(obj.Tag))
 	}
 	val := math.Float32frombits(binary.LittleEndian.Uint32(obj.Data[:4]))
 	return val, nil
@@ -32,17 +33,42 @@ func AsF32(obj CVMObject) (CVMObject, error) {
 			return CVMObject{}, err
 		}
 		return CreateF32(float32(val))
+	case TAG_I64:
+		val, err := ValueI64(obj)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		return CreateF32(float32(val))
+	case TAG_F64:
+		val, err := ValueF64(obj)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		return CreateF32(float32(val))
 	default:
-		return CVMObject{}, fmt.Errorf("can't convert %s to f32", TagsName(obj.Tag))
+		return CVMObject{}, fmt.Errorf("%w: can't convert %s to f32", ErrTypeMismatch, TagsName(obj.Tag))
 	}
 }
 
 func StringF32(obj CVMObject) (string, error) {
 	if obj.Tag != TAG_F32 {
-		return "", fmt.Errorf("expected f32, got %s", TagsName(obj.Tag))
+		return "", fmt.Errorf("%w: expected f32, got %s", ErrTypeMismatch, TagsName(obj.Tag))
 	}
 	val, err := ValueF32(obj)
-	return fmt.Sprintf("(%s)%f", TagsName(obj.Tag), val), err
+	return fmt.Sprintf("(%s)%s", TagsName(obj.Tag), formatFloat(float64(val), 'f', 6, 32)), err
+}
+
+// formatFloat is strconv.FormatFloat that spells special values as NaN, Inf and -Inf.
+func formatFloat(val float64, fmt byte, prec, bitSize int) string {
+	switch {
+	case math.IsNaN(val):
+		return "NaN"
+	case math.IsInf(val, 1):
+		return "Inf"
+	case math.IsInf(val, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(val, fmt, prec, bitSize)
 }
 
 // actions
@@ -174,3 +200,93 @@ func NeqF32(obj1, obj2 CVMObject) (CVMObject, error) {
 	}
 	return CreateBool(v1 != v2)
 }
+
+// math
+
+func unaryMathF32(obj CVMObject, fn func(float64) float64) (CVMObject, error) {
+	v, err := ValueF32(obj)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateF32(float32(fn(float64(v))))
+}
+
+func binaryMathF32(obj1, obj2 CVMObject, fn func(float64, float64) float64) (CVMObject, error) {
+	v1, err := ValueF32(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueF32(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateF32(float32(fn(float64(v1), float64(v2))))
+}
+
+func SqrtF32(obj CVMObject) (CVMObject, error) {
+	return unaryMathF32(obj, math.Sqrt)
+}
+
+func FloorF32(obj CVMObject) (CVMObject, error) {
+	return unaryMathF32(obj, math.Floor)
+}
+
+func CeilF32(obj CVMObject) (CVMObject, error) {
+	return unaryMathF32(obj, math.Ceil)
+}
+
+// RoundF32 rounds half away from zero.
+func RoundF32(obj CVMObject) (CVMObject, error) {
+	return unaryMathF32(obj, math.Round)
+}
+
+func AbsF32(obj CVMObject) (CVMObject, error) {
+	return unaryMathF32(obj, math.Abs)
+}
+
+func SinF32(obj CVMObject) (CVMObject, error) {
+	return unaryMathF32(obj, math.Sin)
+}
+
+func CosF32(obj CVMObject) (CVMObject, error) {
+	return unaryMathF32(obj, math.Cos)
+}
+
+func ExpF32(obj CVMObject) (CVMObject, error) {
+	return unaryMathF32(obj, math.Exp)
+}
+
+// LogF32 is the natural logarithm.
+func LogF32(obj CVMObject) (CVMObject, error) {
+	return unaryMathF32(obj, math.Log)
+}
+
+func PowF32(obj1, obj2 CVMObject) (CVMObject, error) {
+	return binaryMathF32(obj1, obj2, math.Pow)
+}
+
+// MinF32 and MaxF32 return NaN if either operand is NaN.
+func MinF32(obj1, obj2 CVMObject) (CVMObject, error) {
+	return binaryMathF32(obj1, obj2, math.Min)
+}
+
+func MaxF32(obj1, obj2 CVMObject) (CVMObject, error) {
+	return binaryMathF32(obj1, obj2, math.Max)
+}
+
+func IsNaNF32(obj CVMObject) (CVMObject, error) {
+	v, err := ValueF32(obj)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(math.IsNaN(float64(v)))
+}
+
+// IsInfF32 reports both positive and negative infinity.
+func IsInfF32(obj CVMObject) (CVMObject, error) {
+	v, err := ValueF32(obj)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(math.IsInf(float64(v), 0))
+}
@@ -0,0 +1,188 @@
+package object
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+func CreateF64(val float64) (CVMObject, error) {
+	var obj CVMObject
+	obj.Data = nil
+	obj.Tag = TAG_F64
+	obj.Data = binary.LittleEndian.AppendUint64(obj.Data, math.Float64bits(val))
+	return obj, nil
+}
+
+func ValueF64(obj CVMObject) (float64, error) {
+	if obj.Tag != TAG_F64 {
+		return 0, fmt.Errorf("%w: can't get Data, object tag is %s, not f64", ErrTypeMismatch, TagsName(obj.Tag))
+	}
+	val := math.Float64frombits(binary.LittleEndian.Uint64(obj.Data[:8]))
+	return val, nil
+}
+
+func AsF64(obj CVMObject) (CVMObject, error) {
+	switch obj.Tag {
+	case TAG_F64:
+		return obj, nil
+	case TAG_I32:
+		val, err := ValueI32(obj)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		return CreateF64(float64(val))
+	case TAG_I64:
+		val, err := ValueI64(obj)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		return CreateF64(float64(val))
+	case TAG_F32:
+		val, err := ValueF32(obj)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		return CreateF64(float64(val))
+	default:
+		return CVMObject{}, fmt.Errorf("%w: can't convert %s to f64", ErrTypeMismatch, TagsName(obj.Tag))
+	}
+}
+
+func StringF64(obj CVMObject) (string, error) {
+	if obj.Tag != TAG_F64 {
+		return "", fmt.Errorf("%w: expected f64, got %s", ErrTypeMismatch, TagsName(obj.Tag))
+	}
+	val, err := ValueF64(obj)
+	return fmt.Sprintf("(%s)%s", TagsName(obj.Tag), formatFloat(val, 'f', 6, 64)), err
+}
+
+// actions
+
+func NegF64(obj CVMObject) (CVMObject, error) {
+	v, err := ValueF64(obj)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateF64(-v)
+}
+
+func AddF64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueF64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueF64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateF64(v1 + v2)
+}
+
+func SubF64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueF64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueF64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateF64(v1 - v2)
+}
+
+func MulF64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueF64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueF64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateF64(v1 * v2)
+}
+
+func DivF64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueF64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueF64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateF64(v1 / v2)
+}
+
+func LtF64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueF64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueF64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(v1 < v2)
+}
+
+func GtF64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueF64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueF64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(v1 > v2)
+}
+
+func LeqF64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueF64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueF64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(v1 <= v2)
+}
+
+func GeqF64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueF64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueF64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(v1 >= v2)
+}
+
+func EqF64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueF64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueF64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(v1 == v2)
+}
+
+func NeqF64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueF64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueF64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(v1 != v2)
+}
@@ -0,0 +1,244 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// FormatString fills the directives of str with data, data holds the arguments last to first
+// as they are popped from the stack.
+//
+// Directives follow the fmt package: %[flags][width][.precision]verb, where flags are any of "-+ #0",
+// and %[n]verb picks argument n (counting from 1), later directives continue after it.
+// Supported verbs:
+//
+//	%v       any value as to_string renders it
+//	%d       i32, i64 in decimal; %b %o %x %X in other radixes
+//	%f %e %E %g %G
+//	         f32, f64
+//	%s       any value, strings without quotes; %q strings with quotes
+//	%t       bool
+//	%%       a literal percent sign
+//
+// The bare "%." placeholder of older programs is still accepted as %v when no digit follows the dot.
+func FormatString(str CVMObject, data []CVMObject) (CVMObject, error) {
+	valStr, err := ValueString(str)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	args := make([]CVMObject, len(data))
+	for i, obj := range data {
+		args[len(data)-1-i] = obj
+	}
+	used := make([]bool, len(args))
+	next := 0
+	var buf strings.Builder
+	for i := 0; i < len(valStr); {
+		ch := valStr[i]
+		if ch != '%' {
+			buf.WriteByte(ch)
+			i++
+			continue
+		}
+		d, n, err := parseDirective(valStr[i:])
+		if err != nil {
+			return CVMObject{}, err
+		}
+		i += n
+		if d.verb == '%' {
+			buf.WriteByte('%')
+			continue
+		}
+		if d.arg >= 0 {
+			next = d.arg
+		}
+		if next >= len(args) {
+			return CVMObject{}, fmt.Errorf("format %q: missing argument %d for %s", valStr, next+1, d.spec())
+		}
+		res, err := d.format(args[next])
+		if err != nil {
+			return CVMObject{}, err
+		}
+		buf.WriteString(res)
+		used[next] = true
+		next++
+	}
+	for i, ok := range used {
+		if !ok {
+			return CVMObject{}, fmt.Errorf("format %q: argument %d is not used", valStr, i+1)
+		}
+	}
+	return CreateString(buf.String())
+}
+
+type directive struct {
+	flags      string
+	width      int
+	prec       int
+	arg        int
+	verb       byte
+	hasWidth   bool
+	hasPrec    bool
+	legacyNext bool
+}
+
+// parseDirective parses the directive at the start of str and returns its length.
+func parseDirective(str string) (directive, int, error) {
+	d := directive{arg: -1}
+	i := 1
+	if strings.HasPrefix(str, "%.") && (len(str) == 2 || str[2] < '0' || str[2] > '9') {
+		d.verb = 'v'
+		d.legacyNext = true
+		return d, 2, nil
+	}
+	for i < len(str) && strings.IndexByte("-+ #0", str[i]) >= 0 {
+		d.flags += str[i : i+1]
+		i++
+	}
+	if i < len(str) && str[i] == '[' {
+		end := strings.IndexByte(str[i:], ']')
+		if end < 0 {
+			return d, 0, fmt.Errorf("format directive %q: unterminated argument index", str)
+		}
+		n, err := strconv.Atoi(str[i+1 : i+end])
+		if err != nil || n < 1 {
+			return d, 0, fmt.Errorf("format directive %q: invalid argument index", str[:i+end+1])
+		}
+		d.arg = n - 1
+		i += end + 1
+	}
+	start := i
+	for i < len(str) && str[i] >= '0' && str[i] <= '9' {
+		i++
+	}
+	if i > start {
+		d.width, _ = strconv.Atoi(str[start:i])
+		d.hasWidth = true
+	}
+	if i < len(str) && str[i] == '.' {
+		i++
+		start = i
+		for i < len(str) && str[i] >= '0' && str[i] <= '9' {
+			i++
+		}
+		d.prec, _ = strconv.Atoi(str[start:i])
+		d.hasPrec = true
+	}
+	if i >= len(str) {
+		return d, 0, fmt.Errorf("format directive %q: missing verb", str)
+	}
+	d.verb = str[i]
+	if strings.IndexByte("vdboxXfeEgGsqt%", d.verb) < 0 {
+		return d, 0, fmt.Errorf("format directive %q: unknown verb %%%c", str[:i+1], d.verb)
+	}
+	return d, i + 1, nil
+}
+
+// spec rebuilds the directive for the fmt package, without the argument index.
+func (d directive) spec() string {
+	var buf strings.Builder
+	buf.WriteByte('%')
+	buf.WriteString(d.flags)
+	if d.hasWidth {
+		buf.WriteString(strconv.Itoa(d.width))
+	}
+	if d.hasPrec {
+		buf.WriteByte('.')
+		buf.WriteString(strconv.Itoa(d.prec))
+	}
+	buf.WriteByte(d.verb)
+	return buf.String()
+}
+
+func (d directive) format(obj CVMObject) (string, error) {
+	mismatch := func() (string, error) {
+		return "", fmt.Errorf("%w: %s does not accept %s", ErrTypeMismatch, d.spec(), TagsName(obj.Tag))
+	}
+	switch d.verb {
+	case 'v', 's':
+		if d.legacyNext || d.verb == 'v' || obj.Tag != TAG_STRING {
+			sO, err := AsString(obj)
+			if err != nil {
+				return "", err
+			}
+			obj = sO
+		}
+		val, err := ValueString(obj)
+		if err != nil {
+			return "", err
+		}
+		if d.legacyNext {
+			return val, nil
+		}
+		d.verb = 's'
+		return fmt.Sprintf(d.spec(), val), nil
+	case 'q':
+		if obj.Tag != TAG_STRING {
+			return mismatch()
+		}
+		val, err := ValueString(obj)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(d.spec(), val), nil
+	case 't':
+		if obj.Tag != TAG_BOOL {
+			return mismatch()
+		}
+		val, err := ValueBool(obj)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(d.spec(), val), nil
+	case 'd', 'b', 'o', 'x', 'X':
+		switch obj.Tag {
+		case TAG_I32:
+			val, err := ValueI32(obj)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf(d.spec(), val), nil
+		case TAG_I64:
+			val, err := ValueI64(obj)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf(d.spec(), val), nil
+		}
+		return mismatch()
+	default:
+		var val float64
+		switch obj.Tag {
+		case TAG_F32:
+			v, err := ValueF32(obj)
+			if err != nil {
+				return "", err
+			}
+			val = float64(v)
+			if !math.IsNaN(val) && !math.IsInf(val, 0) {
+				return fmt.Sprintf(d.spec(), v), nil
+			}
+		case TAG_F64:
+			v, err := ValueF64(obj)
+			if err != nil {
+				return "", err
+			}
+			val = v
+		default:
+			return mismatch()
+		}
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return d.padded(formatFloat(val, 'g', -1, 64)), nil
+		}
+		return fmt.Sprintf(d.spec(), val), nil
+	}
+}
+
+// padded formats str as %s keeping only the flags and width of d.
+func (d directive) padded(str string) string {
+	d.verb = 's'
+	d.hasPrec = false
+	return fmt.Sprintf(d.spec(), str)
+}
@@ -0,0 +1,74 @@
+package object
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// A function value is the entry address of a function together with the number of values it takes and returns,
+// func.call_indirect checks both against its call site. Closures also carry a reference to their environment,
+// a struct on the object heap holding the captured values, plain functions have the nil reference.
+
+// FUNC_NIL is the address of the nil function, the default function value.
+const FUNC_NIL = math.MaxUint32
+
+type Func struct {
+	Addr    uint32
+	Params  uint32
+	Results uint32
+	// Env is the handle of the environment of a closure, 0 for plain functions.
+	Env uint32
+}
+
+// constructor
+
+func CreateFunc(addr, params, results uint32) (CVMObject, error) {
+	return CreateClosure(addr, params, results, 0)
+}
+
+// CreateClosure creates a function value whose environment is the struct with handle env.
+func CreateClosure(addr, params, results, env uint32) (CVMObject, error) {
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint32(data, addr)
+	binary.LittleEndian.PutUint32(data[4:], params)
+	binary.LittleEndian.PutUint32(data[8:], results)
+	binary.LittleEndian.PutUint32(data[12:], env)
+	return CVMObject{Tag: TAG_FUNC, Data: data}, nil
+}
+
+// manipulation
+
+func ValueFunc(obj CVMObject) (Func, error) {
+	if obj.Tag != TAG_FUNC {
+		return Func{}, fmt.Errorf("%w: expected func, got %s", ErrTypeMismatch, TagsName(obj.Tag))
+	}
+	return Func{
+		Addr:    binary.LittleEndian.Uint32(obj.Data),
+		Params:  binary.LittleEndian.Uint32(obj.Data[4:]),
+		Results: binary.LittleEndian.Uint32(obj.Data[8:]),
+		Env:     binary.LittleEndian.Uint32(obj.Data[12:]),
+	}, nil
+}
+
+func StringFunc(obj CVMObject) (string, error) {
+	str, err := funcString(obj)
+	return "(func)" + str, err
+}
+
+// funcString renders a function value as @addr(params:results) or nil, closures add &env.
+func funcString(obj CVMObject) (string, error) {
+	fn, err := ValueFunc(obj)
+	if err != nil {
+		return "", err
+	}
+	if fn.Addr == FUNC_NIL {
+		return "nil", nil
+	}
+	str := fmt.Sprintf("@%d(%d:%d)", fn.Addr, fn.Params, fn.Results)
+	if fn.Env != 0 {
+		str += "&" + strconv.FormatUint(uint64(fn.Env), 10)
+	}
+	return str, nil
+}
@@ -18,7 +18,7 @@ func CreateI32(val int32) (CVMObject, error) {
 
 func ValueI32(obj CVMObject) (int32, error) {
 	if obj.Tag != TAG_I32 {
-		return 0, fmt.Errorf("can't get Data, object tag is %s, not i32", TagsName(obj.Tag))
+		return 0, fmt.Errorf("%w: can't get Data, object tag is %s, not i32", ErrTypeMismatch, TagsName(obj.Tag))
 	}
 	val := binary.LittleEndian.Uint32(obj.Data[:4])
 	return int32(val), nil
@@ -28,20 +28,32 @@ func AsI32(obj CVMObject) (CVMObject, error) {
 	switch obj.Tag {
 	case TAG_I32:
 		return obj, nil
+	case TAG_I64:
+		val, err := ValueI64(obj)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		return CreateI32(int32(val))
 	case TAG_F32:
 		val, err := ValueF32(obj)
 		if err != nil {
 			return CVMObject{}, err
 		}
 		return CreateI32(int32(val))
+	case TAG_F64:
+		val, err := ValueF64(obj)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		return CreateI32(int32(val))
 	default:
-		return CVMObject{}, fmt.Errorf("can't convert %s to i32", TagsName(obj.Tag))
+		return CVMObject{}, fmt.Errorf("%w: can't convert %s to i32", ErrTypeMismatch, TagsName(obj.Tag))
 	}
 }
 
 func StringI32(obj CVMObject) (string, error) {
 	if obj.Tag != TAG_I32 {
-		return "", fmt.Errorf("expected i32, got %s", TagsName(obj.Tag))
+		return "", fmt.Errorf("%w: expected i32, got %s", ErrTypeMismatch, TagsName(obj.Tag))
 	}
 	val, err := ValueI32(obj)
 	return fmt.Sprintf("(%s)%d", TagsName(obj.Tag), val), err
@@ -102,9 +114,111 @@ func DivI32(obj1, obj2 CVMObject) (CVMObject, error) {
 	if err != nil {
 		return CVMObject{}, err
 	}
+	if v2 == 0 {
+		return CVMObject{}, ErrDivisionByZero
+	}
 	return CreateI32(v1 / v2)
 }
 
+func RemI32(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueI32(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueI32(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	if v2 == 0 {
+		return CVMObject{}, ErrDivisionByZero
+	}
+	return CreateI32(v1 % v2)
+}
+
+func AndI32(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueI32(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueI32(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateI32(v1 & v2)
+}
+
+func OrI32(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueI32(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueI32(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateI32(v1 | v2)
+}
+
+func XorI32(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueI32(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueI32(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateI32(v1 ^ v2)
+}
+
+func NotI32(obj CVMObject) (CVMObject, error) {
+	v, err := ValueI32(obj)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateI32(^v)
+}
+
+// Shift counts are taken modulo 32, so negative and oversized counts wrap instead of failing.
+
+func ShlI32(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueI32(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueI32(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateI32(v1 << (uint32(v2) & 31))
+}
+
+// ShrI32 is the arithmetic shift, it keeps the sign of obj1.
+func ShrI32(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueI32(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueI32(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateI32(v1 >> (uint32(v2) & 31))
+}
+
+// ShruI32 is the logical shift, it fills with zero bits.
+func ShruI32(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueI32(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueI32(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateI32(int32(uint32(v1) >> (uint32(v2) & 31)))
+}
+
 func LtI32(obj1, obj2 CVMObject) (CVMObject, error) {
 	v1, err := ValueI32(obj1)
 	if err != nil {
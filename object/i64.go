@@ -0,0 +1,193 @@
+package object
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// constructor
+func CreateI64(val int64) (CVMObject, error) {
+	var obj CVMObject
+	obj.Data = nil
+	obj.Tag = TAG_I64
+	obj.Data = binary.LittleEndian.AppendUint64(obj.Data, uint64(val))
+	return obj, nil
+}
+
+// manipulations
+
+func ValueI64(obj CVMObject) (int64, error) {
+	if obj.Tag != TAG_I64 {
+		return 0, fmt.Errorf("%w: can't get Data, object tag is %s, not i64", ErrTypeMismatch, TagsName(obj.Tag))
+	}
+	val := binary.LittleEndian.Uint64(obj.Data[:8])
+	return int64(val), nil
+}
+
+func AsI64(obj CVMObject) (CVMObject, error) {
+	switch obj.Tag {
+	case TAG_I64:
+		return obj, nil
+	case TAG_I32:
+		val, err := ValueI32(obj)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		return CreateI64(int64(val))
+	case TAG_F32:
+		val, err := ValueF32(obj)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		return CreateI64(int64(val))
+	case TAG_F64:
+		val, err := ValueF64(obj)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		return CreateI64(int64(val))
+	default:
+		return CVMObject{}, fmt.Errorf("%w: can't convert %s to i64", ErrTypeMismatch, TagsName(obj.Tag))
+	}
+}
+
+func StringI64(obj CVMObject) (string, error) {
+	if obj.Tag != TAG_I64 {
+		return "", fmt.Errorf("%w: expected i64, got %s", ErrTypeMismatch, TagsName(obj.Tag))
+	}
+	val, err := ValueI64(obj)
+	return fmt.Sprintf("(%s)%d", TagsName(obj.Tag), val), err
+}
+
+// actions
+
+func NegI64(obj CVMObject) (CVMObject, error) {
+	v, err := ValueI64(obj)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateI64(-v)
+}
+
+func AddI64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueI64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueI64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateI64(v1 + v2)
+}
+
+func SubI64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueI64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueI64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateI64(v1 - v2)
+}
+
+func MulI64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueI64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueI64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateI64(v1 * v2)
+}
+
+func DivI64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueI64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueI64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	if v2 == 0 {
+		return CVMObject{}, ErrDivisionByZero
+	}
+	return CreateI64(v1 / v2)
+}
+
+func LtI64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueI64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueI64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(v1 < v2)
+}
+
+func GtI64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueI64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueI64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(v1 > v2)
+}
+
+func LeqI64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueI64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueI64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(v1 <= v2)
+}
+
+func GeqI64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueI64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueI64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(v1 >= v2)
+}
+
+func EqI64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueI64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueI64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(v1 == v2)
+}
+
+func NeqI64(obj1, obj2 CVMObject) (CVMObject, error) {
+	v1, err := ValueI64(obj1)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	v2, err := ValueI64(obj2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(v1 != v2)
+}
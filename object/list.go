@@ -2,8 +2,11 @@ package object
 
 import (
 	"bytes"
+	"cmp"
 	"encoding/binary"
 	"fmt"
+	"slices"
+	"strings"
 )
 
 // constructor
@@ -26,52 +29,19 @@ func CreateList(val []byte) (CVMObject, error) {
 
 func StringList(obj CVMObject) (string, error) {
 	if obj.Tag != TAG_LIST {
-		return "", fmt.Errorf("expected list, got %s", TagsName(obj.Tag))
+		return "", fmt.Errorf("%w: expected list, got %s", ErrTypeMismatch, TagsName(obj.Tag))
 	}
 	var buf bytes.Buffer
-	l, err := Len(obj)
+	items, err := listItems(obj)
 	if err != nil {
 		return buf.String(), err
 	}
-	fmt.Fprintf(&buf, "(%s.%s)[%d]{ ", TagsName(obj.Tag), TagsName(obj.Data[0]), l)
-	size, err := Size(obj)
-	if err != nil {
-		return buf.String(), err
-	}
-	for i := 6; i < size-1; {
-		s := 0
-		str := ""
-		switch obj.Data[i] {
-		case TAG_I32, TAG_F32, TAG_BOOL:
-			s, err = Size(CVMObject{Tag: obj.Data[i]})
-			if err != nil {
-				return buf.String(), err
-			}
-			obj, err := CreateObject(obj.Data[i : i+s])
-			if err != nil {
-				return buf.String(), err
-			}
-			str, err = String(obj)
-			if err != nil {
-				return buf.String(), err
-			}
-		case TAG_STRING, TAG_LIST:
-			s, err = Size(CVMObject{Tag: obj.Data[i], Data: obj.Data[i+1 : i+6]})
-			if err != nil {
-				return buf.String(), err
-			}
-			obj, err := CreateObject(obj.Data[i : i+s])
-			if err != nil {
-				return buf.String(), err
-			}
-			str, err = String(obj)
-			if err != nil {
-				return buf.String(), err
-			}
-		default:
-			return buf.String(), fmt.Errorf("unexpected list item tag %s", TagsName(obj.Data[i]))
+	fmt.Fprintf(&buf, "(%s.%s)[%d]{ ", TagsName(obj.Tag), TagsName(obj.Data[0]), len(items))
+	for _, item := range items {
+		str, err := String(item)
+		if err != nil {
+			return buf.String(), err
 		}
-		i += s
 		fmt.Fprintf(&buf, "%s ", str)
 	}
 	fmt.Fprint(&buf, "}")
@@ -89,6 +59,111 @@ func ChangeLengthList(list CVMObject, NewLength int32) (CVMObject, error) {
 	return list, nil
 }
 
+// listOffsets returns the start of every item of list inside list.Data followed by the end of the last one.
+// Items of fixed size are located without walking the list.
+func listOffsets(list CVMObject) ([]int, error) {
+	if list.Tag != TAG_LIST {
+		return nil, fmt.Errorf("%w: expected list, got %s", ErrTypeMismatch, TagsName(list.Tag))
+	}
+	ln, err := Len(list)
+	if err != nil {
+		return nil, err
+	}
+	offs := make([]int, ln+1)
+	offs[0] = 6
+	fixed := 0
+	switch list.Data[0] {
+	case TAG_I32, TAG_F32, TAG_BOOL, TAG_I64, TAG_F64, TAG_REF, TAG_FUNC:
+		fixed, _ = Size(CVMObject{Tag: list.Data[0]})
+	}
+	for i := 0; i < ln; i++ {
+		size := fixed
+		if size == 0 {
+			if offs[i] >= len(list.Data) {
+				return nil, fmt.Errorf("%w: truncated list", ErrIndexOutOfRange)
+			}
+			size, err = SizeAt(list.Data[offs[i]:])
+			if err != nil {
+				return nil, err
+			}
+		}
+		offs[i+1] = offs[i] + size
+	}
+	if offs[ln] > len(list.Data) {
+		return nil, fmt.Errorf("%w: truncated list", ErrIndexOutOfRange)
+	}
+	return offs, nil
+}
+
+// itemRange returns the bounds of item ind inside list.Data.
+func itemRange(list, ind CVMObject) (int, int, error) {
+	indV, err := ValueI32(ind)
+	if err != nil {
+		return 0, 0, err
+	}
+	offs, err := listOffsets(list)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(offs) == 1 {
+		return 0, 0, fmt.Errorf("%w: list is empty", ErrIndexOutOfRange)
+	}
+	if indV < 0 || int(indV) >= len(offs)-1 {
+		return 0, 0, fmt.Errorf("%w: %d", ErrIndexOutOfRange, indV)
+	}
+	return offs[indV], offs[indV+1], nil
+}
+
+// listItems decodes every item of list, the items share their data with list.
+func listItems(list CVMObject) ([]CVMObject, error) {
+	offs, err := listOffsets(list)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]CVMObject, 0, len(offs)-1)
+	for i := 0; i < len(offs)-1; i++ {
+		item, err := CreateObject(list.Data[offs[i]:offs[i+1]])
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// buildList encodes items as a list of elemTag.
+func buildList(elemTag byte, items []CVMObject) CVMObject {
+	size := 6
+	for _, item := range items {
+		size += 1 + len(item.Data)
+	}
+	data := make([]byte, 0, size)
+	data = append(data, elemTag, TAG_I32)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(items)))
+	for _, item := range items {
+		data = append(data, item.Tag)
+		data = append(data, item.Data...)
+	}
+	return CVMObject{Tag: TAG_LIST, Data: data}
+}
+
+// spliceList replaces list.Data[start:end] with ins and sets the length to ln, list itself is left unchanged.
+func spliceList(list CVMObject, start, end int, ins []byte, ln int) CVMObject {
+	data := make([]byte, 0, len(list.Data)-(end-start)+len(ins))
+	data = append(data, list.Data[:start]...)
+	data = append(data, ins...)
+	data = append(data, list.Data[end:]...)
+	binary.LittleEndian.PutUint32(data[2:6], uint32(ln))
+	return CVMObject{Tag: TAG_LIST, Data: data}
+}
+
+func checkItem(list, obj CVMObject) error {
+	if list.Data[0] != obj.Tag {
+		return fmt.Errorf("%w: expected %s list item, got %s", ErrTypeMismatch, TagsName(list.Data[0]), TagsName(obj.Tag))
+	}
+	return nil
+}
+
 // actions
 
 func LenList(list CVMObject) (CVMObject, error) {
@@ -102,186 +177,318 @@ func LenList(list CVMObject) (CVMObject, error) {
 }
 
 func GetList(list, ind CVMObject) (CVMObject, error) {
-	var obj CVMObject
-	if list.Tag != TAG_LIST {
-		return obj, fmt.Errorf("expected list, got %s", TagsName(list.Tag))
+	start, end, err := itemRange(list, ind)
+	if err != nil {
+		return CVMObject{}, err
 	}
-	temp, err := ValueI32(ind)
+	return CreateObject(list.Data[start:end])
+}
+
+func RemoveList(list, ind CVMObject) (CVMObject, error) {
+	start, end, err := itemRange(list, ind)
 	if err != nil {
-		return obj, err
+		return list, err
 	}
-	indVal := int(temp)
 	ln, err := Len(list)
 	if err != nil {
-		return obj, err
+		return list, err
 	}
-	if ln <= 0 {
-		return obj, fmt.Errorf("list is empty")
+	return spliceList(list, start, end, nil, ln-1), nil
+}
+
+func InsertList(list, ind, obj CVMObject) (CVMObject, error) {
+	indV, err := ValueI32(ind)
+	if err != nil {
+		return list, err
 	}
-	if ln <= indVal {
-		return obj, fmt.Errorf("index %d out of range", indVal)
+	offs, err := listOffsets(list)
+	if err != nil {
+		return list, err
 	}
-	offStart := 6
-	offEnd := 0
-	switch list.Data[0] {
-	case TAG_BOOL, TAG_F32, TAG_I32:
-		size, err := Size(CVMObject{Tag: list.Data[0]})
-		if err != nil {
-			return list, err
-		}
-		offStart += indVal * size
-		offEnd = offStart + size
-	case TAG_STRING:
-		size := 0
-		for i := 0; i < indVal+1; i++ {
-			s, err := Size(CVMObject{Tag: TAG_STRING, Data: list.Data[offStart+1 : offStart+5]})
-			if err != nil {
-				return list, err
-			}
-			offStart += s
-			size = s
-		}
-		offEnd = offStart
-		offStart -= size
-	case TAG_LIST:
-		size := 0
-		for i := 0; i < indVal+1; i++ {
-			s, err := Size(CVMObject{Tag: TAG_LIST, Data: list.Data[offStart+1 : offStart+7]})
-			if err != nil {
-				return list, err
-			}
-			offStart += s
-			size = s
-		}
-		offEnd = offStart
-		offStart -= size
+	if err := checkItem(list, obj); err != nil {
+		return list, err
 	}
-	obj, err = CreateObject(list.Data[offStart:offEnd])
-	return obj, err
+	if indV < 0 || int(indV) >= len(offs) {
+		return list, fmt.Errorf("%w: %d", ErrIndexOutOfRange, indV)
+	}
+	return spliceList(list, offs[indV], offs[indV], Bytes(obj), len(offs)), nil
 }
 
-func RemoveList(oldList, ind CVMObject) (CVMObject, error) {
-	list, _ := CreateList(oldList.Data)
-	if list.Tag != TAG_LIST {
-		return list, fmt.Errorf("expected list, got %s", TagsName(list.Tag))
+func ReplaceList(list, ind, obj CVMObject) (CVMObject, error) {
+	start, end, err := itemRange(list, ind)
+	if err != nil {
+		return list, err
 	}
-	if len(list.Data) <= 6 {
-		return list, fmt.Errorf("trying to pop element from empty list")
+	if err := checkItem(list, obj); err != nil {
+		return list, err
 	}
-	temp, err := ValueI32(ind)
+	ln, err := Len(list)
 	if err != nil {
 		return list, err
 	}
-	indVal := int(temp)
+	return spliceList(list, start, end, Bytes(obj), ln), nil
+}
+
+// AppendList adds obj after the last item of list.
+func AppendList(list, obj CVMObject) (CVMObject, error) {
+	if list.Tag != TAG_LIST {
+		return list, fmt.Errorf("%w: expected list, got %s", ErrTypeMismatch, TagsName(list.Tag))
+	}
+	if err := checkItem(list, obj); err != nil {
+		return list, err
+	}
 	ln, err := Len(list)
 	if err != nil {
 		return list, err
 	}
-	if ln <= 0 {
-		return list, fmt.Errorf("list is empty")
+	return spliceList(list, len(list.Data), len(list.Data), Bytes(obj), ln+1), nil
+}
+
+// PopList removes the last item of list and returns the shortened list and the item.
+func PopList(list CVMObject) (CVMObject, CVMObject, error) {
+	offs, err := listOffsets(list)
+	if err != nil {
+		return list, CVMObject{}, err
 	}
-	if ln <= indVal {
-		return list, fmt.Errorf("index %d out of range", indVal)
+	ln := len(offs) - 1
+	if ln == 0 {
+		return list, CVMObject{}, fmt.Errorf("%w: trying to pop element from empty list", ErrIndexOutOfRange)
 	}
-	offStart := 6
-	offEnd := 0
-	switch list.Data[0] {
-	case TAG_BOOL, TAG_F32, TAG_I32:
-		size, err := Size(CVMObject{Tag: list.Data[0]})
-		if err != nil {
-			return list, err
-		}
-		offStart += indVal * size
-		offEnd = offStart + size
-	case TAG_STRING:
-		size := 0
-		for i := 0; i < indVal+1; i++ {
-			s, err := Size(CVMObject{Tag: TAG_STRING, Data: list.Data[offStart+1 : offStart+5]})
-			if err != nil {
-				return list, err
-			}
-			offStart += s
-			size = s
-		}
-		offEnd = offStart
-		offStart -= size
-	case TAG_LIST:
-		size := 0
-		for i := 0; i < indVal+1; i++ {
-			s, err := Size(CVMObject{Tag: TAG_LIST, Data: list.Data[offStart+1 : offStart+7]})
-			if err != nil {
-				return list, err
-			}
-			offStart += s
-			size = s
+	item, err := CreateObject(list.Data[offs[ln-1]:offs[ln]])
+	if err != nil {
+		return list, CVMObject{}, err
+	}
+	return spliceList(list, offs[ln-1], offs[ln], nil, ln-1), item, nil
+}
+
+// SliceList returns the items of list from start up to, but not including, end.
+func SliceList(list, start, end CVMObject) (CVMObject, error) {
+	offs, err := listOffsets(list)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	startV, err := ValueI32(start)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	endV, err := ValueI32(end)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	ln := int32(len(offs) - 1)
+	if startV < 0 || startV > ln {
+		return CVMObject{}, fmt.Errorf("%w: %d", ErrIndexOutOfRange, startV)
+	}
+	if endV < startV || endV > ln {
+		return CVMObject{}, fmt.Errorf("%w: %d", ErrIndexOutOfRange, endV)
+	}
+	data := make([]byte, 0, 6+offs[endV]-offs[startV])
+	data = append(data, list.Data[0], TAG_I32)
+	data = binary.LittleEndian.AppendUint32(data, uint32(endV-startV))
+	data = append(data, list.Data[offs[startV]:offs[endV]]...)
+	return CVMObject{Tag: TAG_LIST, Data: data}, nil
+}
+
+// ConcatList returns the items of a followed by the items of b, both lists must hold the same item type.
+func ConcatList(a, b CVMObject) (CVMObject, error) {
+	for _, list := range []CVMObject{a, b} {
+		if list.Tag != TAG_LIST {
+			return CVMObject{}, fmt.Errorf("%w: expected list, got %s", ErrTypeMismatch, TagsName(list.Tag))
 		}
-		offEnd = offStart
-		offStart -= size
 	}
-	list.Data = append(list.Data[:offStart], list.Data[offEnd:]...)
-	list, err = ChangeLengthList(list, int32(ln-1))
+	lnA, err := Len(a)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	lnB, err := Len(b)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	if a.Data[0] != b.Data[0] {
+		return CVMObject{}, fmt.Errorf("%w: can't concat %s list with %s list", ErrTypeMismatch, TagsName(a.Data[0]), TagsName(b.Data[0]))
+	}
+	return spliceList(a, len(a.Data), len(a.Data), b.Data[6:], lnA+lnB), nil
+}
+
+func ReverseList(list CVMObject) (CVMObject, error) {
+	items, err := listItems(list)
 	if err != nil {
 		return list, err
 	}
-	return list, nil
+	for l, r := 0, len(items)-1; l < r; l, r = l+1, r-1 {
+		items[l], items[r] = items[r], items[l]
+	}
+	return buildList(list.Data[0], items), nil
 }
 
-func InsertList(oldList, ind, obj CVMObject) (CVMObject, error) {
-	list, _ := CreateList(oldList.Data)
-	if list.Tag != TAG_LIST {
-		return list, fmt.Errorf("expected list, got %s", TagsName(list.Tag))
+// IndexList returns the index of the first item of list equal to obj, or -1.
+// Items are compared by their encoding, so a f32 NaN is never found and -0 differs from 0.
+func IndexList(list, obj CVMObject) (CVMObject, error) {
+	items, err := listItems(list)
+	if err != nil {
+		return CVMObject{}, err
 	}
-	if list.Data[0] != obj.Tag {
-		return list, fmt.Errorf("expected %s list item, got %s", TagsName(list.Data[0]), TagsName(obj.Tag))
+	if err := checkItem(list, obj); err != nil {
+		return CVMObject{}, err
+	}
+	isNaN := func(item CVMObject) bool {
+		switch item.Tag {
+		case TAG_F32:
+			v, _ := ValueF32(item)
+			return v != v
+		case TAG_F64:
+			v, _ := ValueF64(item)
+			return v != v
+		}
+		return false
+	}
+	if !isNaN(obj) {
+		for i, item := range items {
+			if bytes.Equal(item.Data, obj.Data) {
+				return CreateI32(int32(i))
+			}
+		}
 	}
-	temp, err := ValueI32(ind)
+	return CreateI32(-1)
+}
+
+func ContainsList(list, obj CVMObject) (CVMObject, error) {
+	ind, err := IndexList(list, obj)
 	if err != nil {
-		return list, err
+		return CVMObject{}, err
 	}
-	indVal := int(temp)
-	ln, err := Len(list)
-	if int(ln) < indVal {
-		return list, fmt.Errorf("index %d out of range", indVal)
+	indV, err := ValueI32(ind)
+	if err != nil {
+		return CVMObject{}, err
 	}
-	size, err := Size(obj)
+	return CreateBool(indV >= 0)
+}
+
+// SortList orders the items of a numeric or string list ascending, NaN sorts before every other number.
+// The sort is stable.
+func SortList(list CVMObject) (CVMObject, error) {
+	items, err := listItems(list)
 	if err != nil {
 		return list, err
 	}
-	offStart := 6
+	var compare func(a, b CVMObject) int
 	switch list.Data[0] {
-	case TAG_BOOL, TAG_F32, TAG_I32:
-		offStart += indVal * size
-	case TAG_STRING:
-		for i := 0; i < indVal; i++ {
-			s, err := Size(CVMObject{Tag: TAG_STRING, Data: list.Data[offStart+1 : offStart+5]})
-			if err != nil {
-				return list, err
-			}
-			offStart += s
+	case TAG_I32:
+		compare = func(a, b CVMObject) int {
+			av, _ := ValueI32(a)
+			bv, _ := ValueI32(b)
+			return cmp.Compare(av, bv)
 		}
-	case TAG_LIST:
-		for i := 0; i < indVal; i++ {
-			s, err := Size(CVMObject{Tag: TAG_LIST, Data: list.Data[offStart+1 : offStart+7]})
-			if err != nil {
-				return list, err
-			}
-			offStart += s
+	case TAG_I64:
+		compare = func(a, b CVMObject) int {
+			av, _ := ValueI64(a)
+			bv, _ := ValueI64(b)
+			return cmp.Compare(av, bv)
+		}
+	case TAG_F32:
+		compare = func(a, b CVMObject) int {
+			av, _ := ValueF32(a)
+			bv, _ := ValueF32(b)
+			return cmp.Compare(av, bv)
+		}
+	case TAG_F64:
+		compare = func(a, b CVMObject) int {
+			av, _ := ValueF64(a)
+			bv, _ := ValueF64(b)
+			return cmp.Compare(av, bv)
+		}
+	case TAG_STRING:
+		compare = func(a, b CVMObject) int {
+			av, _ := ValueString(a)
+			bv, _ := ValueString(b)
+			return strings.Compare(av, bv)
 		}
+	default:
+		return list, fmt.Errorf("%w: can't sort %s list", ErrTypeMismatch, TagsName(list.Data[0]))
+	}
+	slices.SortStableFunc(items, compare)
+	return buildList(list.Data[0], items), nil
+}
+
+// The in place variants below mutate list.Data, growing it like append does.
+// They are only safe when no other object shares list.Data, as for objects owned by the object heap.
+
+func AppendListInPlace(list *CVMObject, obj CVMObject) error {
+	if list.Tag != TAG_LIST {
+		return fmt.Errorf("%w: expected list, got %s", ErrTypeMismatch, TagsName(list.Tag))
 	}
-	list.Data = append(list.Data[:offStart], append(Bytes(obj), list.Data[offStart:]...)...)
-	list, err = ChangeLengthList(list, int32(ln+1))
+	if err := checkItem(*list, obj); err != nil {
+		return err
+	}
+	ln, err := Len(*list)
 	if err != nil {
-		return list, err
+		return err
 	}
-	return list, nil
+	list.Data = append(list.Data, obj.Tag)
+	list.Data = append(list.Data, obj.Data...)
+	binary.LittleEndian.PutUint32(list.Data[2:6], uint32(ln+1))
+	return nil
 }
 
-func ReplaceList(oldList, ind, obj CVMObject) (CVMObject, error) {
-	list, err := CreateList(oldList.Data)
-	list, err = RemoveList(list, ind)
+func InsertListInPlace(list *CVMObject, ind, obj CVMObject) error {
+	indV, err := ValueI32(ind)
 	if err != nil {
-		return list, err
+		return err
+	}
+	offs, err := listOffsets(*list)
+	if err != nil {
+		return err
+	}
+	if err := checkItem(*list, obj); err != nil {
+		return err
+	}
+	if indV < 0 || int(indV) >= len(offs) {
+		return fmt.Errorf("%w: %d", ErrIndexOutOfRange, indV)
+	}
+	spliceInPlace(list, offs[indV], offs[indV], Bytes(obj))
+	binary.LittleEndian.PutUint32(list.Data[2:6], uint32(len(offs)))
+	return nil
+}
+
+func RemoveListInPlace(list *CVMObject, ind CVMObject) error {
+	start, end, err := itemRange(*list, ind)
+	if err != nil {
+		return err
+	}
+	ln, err := Len(*list)
+	if err != nil {
+		return err
+	}
+	spliceInPlace(list, start, end, nil)
+	binary.LittleEndian.PutUint32(list.Data[2:6], uint32(ln-1))
+	return nil
+}
+
+func ReplaceListInPlace(list *CVMObject, ind, obj CVMObject) error {
+	start, end, err := itemRange(*list, ind)
+	if err != nil {
+		return err
+	}
+	if err := checkItem(*list, obj); err != nil {
+		return err
+	}
+	spliceInPlace(list, start, end, Bytes(obj))
+	return nil
+}
+
+// spliceInPlace replaces obj.Data[start:end] with ins, moving the tail instead of copying the whole data.
+func spliceInPlace(obj *CVMObject, start, end int, ins []byte) {
+	tail := len(obj.Data) - end
+	size := start + len(ins) + tail
+	if size > cap(obj.Data) {
+		data := make([]byte, size, 2*size)
+		copy(data, obj.Data[:start])
+		copy(data[start+len(ins):], obj.Data[end:])
+		obj.Data = data
+	} else {
+		data := obj.Data[:size]
+		copy(data[start+len(ins):], obj.Data[end:end+tail])
+		obj.Data = data
 	}
-	list, err = InsertList(list, ind, obj)
-	return list, err
+	copy(obj.Data[start:], ins)
 }
@@ -0,0 +1,253 @@
+package object
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Map entries are kept sorted by key, so iteration order only depends on the contents.
+
+// constructor
+
+func CreateMap(keyTag, valTag byte) (CVMObject, error) {
+	if keyTag != TAG_UNDEFINED && !IsMapKey(keyTag) {
+		return CVMObject{}, fmt.Errorf("%w: %s can't be a map key", ErrTypeMismatch, TagsName(keyTag))
+	}
+	data := make([]byte, 0, 7)
+	data = append(data, keyTag, valTag, TAG_I32)
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	return CVMObject{Tag: TAG_MAP, Data: data}, nil
+}
+
+// IsMapKey reports whether objects with tag can be used as map keys.
+func IsMapKey(tag byte) bool {
+	return tag == TAG_I32 || tag == TAG_BOOL || tag == TAG_STRING
+}
+
+// manipulation
+
+func StringMap(obj CVMObject) (string, error) {
+	if obj.Tag != TAG_MAP {
+		return "", fmt.Errorf("%w: expected map, got %s", ErrTypeMismatch, TagsName(obj.Tag))
+	}
+	entries, err := mapEntries(obj)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "(%s.%s.%s)[%d]{ ", TagsName(obj.Tag), TagsName(obj.Data[0]), TagsName(obj.Data[1]), len(entries))
+	for _, e := range entries {
+		key, err := String(e.key)
+		if err != nil {
+			return buf.String(), err
+		}
+		val, err := String(e.val)
+		if err != nil {
+			return buf.String(), err
+		}
+		fmt.Fprintf(&buf, "%s: %s ", key, val)
+	}
+	fmt.Fprint(&buf, "}")
+	return buf.String(), nil
+}
+
+func asStringMap(obj CVMObject) (CVMObject, error) {
+	entries, err := mapEntries(obj)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	var buf strings.Builder
+	buf.WriteString("{")
+	for _, e := range entries {
+		var strs [2]string
+		for i, item := range []CVMObject{e.key, e.val} {
+			res, err := AsString(item)
+			if err != nil {
+				return CVMObject{}, err
+			}
+			strs[i], err = ValueString(res)
+			if err != nil {
+				return CVMObject{}, err
+			}
+		}
+		fmt.Fprintf(&buf, " %s: %s", strs[0], strs[1])
+	}
+	buf.WriteString(" }")
+	return CreateString(buf.String())
+}
+
+type mapEntry struct {
+	key, val   CVMObject
+	start, end int
+}
+
+// mapEntries decodes every entry of m, start and end are the bounds of the entry inside m.Data.
+func mapEntries(m CVMObject) ([]mapEntry, error) {
+	ln, err := Len(m)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]mapEntry, 0, ln)
+	off := 7
+	for i := 0; i < ln; i++ {
+		e := mapEntry{start: off}
+		for _, item := range []*CVMObject{&e.key, &e.val} {
+			if off >= len(m.Data) {
+				return nil, fmt.Errorf("%w: truncated map", ErrIndexOutOfRange)
+			}
+			size, err := SizeAt(m.Data[off:])
+			if err != nil {
+				return nil, err
+			}
+			*item, err = CreateObject(m.Data[off : off+size])
+			if err != nil {
+				return nil, err
+			}
+			off += size
+		}
+		e.end = off
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// compareKeys orders map keys: numerically for i32, false before true and bytewise for strings.
+func compareKeys(a, b CVMObject) int {
+	switch a.Tag {
+	case TAG_I32:
+		av, _ := ValueI32(a)
+		bv, _ := ValueI32(b)
+		switch {
+		case av < bv:
+			return -1
+		case av > bv:
+			return 1
+		}
+		return 0
+	case TAG_STRING:
+		av, _ := ValueString(a)
+		bv, _ := ValueString(b)
+		return strings.Compare(av, bv)
+	default:
+		return bytes.Compare(a.Data, b.Data)
+	}
+}
+
+// findMap returns the entries of m and the position of key among them.
+// When key is missing the position is where it would be inserted.
+func findMap(m, key CVMObject) ([]mapEntry, int, bool, error) {
+	if m.Tag != TAG_MAP {
+		return nil, 0, false, fmt.Errorf("%w: expected map, got %s", ErrTypeMismatch, TagsName(m.Tag))
+	}
+	if m.Data[0] != key.Tag {
+		return nil, 0, false, fmt.Errorf("%w: expected %s map key, got %s", ErrTypeMismatch, TagsName(m.Data[0]), TagsName(key.Tag))
+	}
+	entries, err := mapEntries(m)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	for i, e := range entries {
+		switch c := compareKeys(key, e.key); {
+		case c == 0:
+			return entries, i, true, nil
+		case c < 0:
+			return entries, i, false, nil
+		}
+	}
+	return entries, len(entries), false, nil
+}
+
+// actions
+
+func LenMap(m CVMObject) (CVMObject, error) {
+	if m.Tag != TAG_MAP {
+		return CVMObject{}, fmt.Errorf("%w: expected map, got %s", ErrTypeMismatch, TagsName(m.Tag))
+	}
+	ln, err := Len(m)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateI32(int32(ln))
+}
+
+func GetMap(m, key CVMObject) (CVMObject, error) {
+	entries, ind, ok, err := findMap(m, key)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	if !ok {
+		keyS, _ := AsString(key)
+		keyV, _ := ValueString(keyS)
+		return CVMObject{}, fmt.Errorf("%w: %s", ErrKeyNotFound, keyV)
+	}
+	return entries[ind].val, nil
+}
+
+func HasMap(m, key CVMObject) (CVMObject, error) {
+	_, _, ok, err := findMap(m, key)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(ok)
+}
+
+func SetMap(m, key, val CVMObject) (CVMObject, error) {
+	entries, ind, ok, err := findMap(m, key)
+	if err != nil {
+		return m, err
+	}
+	if m.Data[1] != val.Tag {
+		return m, fmt.Errorf("%w: expected %s map value, got %s", ErrTypeMismatch, TagsName(m.Data[1]), TagsName(val.Tag))
+	}
+	ln := len(entries)
+	start := len(m.Data)
+	if ind < len(entries) {
+		start = entries[ind].start
+	}
+	end := start
+	if ok {
+		end = entries[ind].end
+	} else {
+		ln++
+	}
+	data := make([]byte, 0, len(m.Data)+len(key.Data)+len(val.Data)+2)
+	data = append(data, m.Data[:start]...)
+	data = append(data, Bytes(key)...)
+	data = append(data, Bytes(val)...)
+	data = append(data, m.Data[end:]...)
+	binary.LittleEndian.PutUint32(data[3:7], uint32(ln))
+	return CVMObject{Tag: TAG_MAP, Data: data}, nil
+}
+
+// DeleteMap removes key from m, deleting a missing key leaves m unchanged.
+func DeleteMap(m, key CVMObject) (CVMObject, error) {
+	entries, ind, ok, err := findMap(m, key)
+	if err != nil || !ok {
+		return m, err
+	}
+	data := make([]byte, 0, len(m.Data))
+	data = append(data, m.Data[:entries[ind].start]...)
+	data = append(data, m.Data[entries[ind].end:]...)
+	binary.LittleEndian.PutUint32(data[3:7], uint32(len(entries)-1))
+	return CVMObject{Tag: TAG_MAP, Data: data}, nil
+}
+
+// KeysMap returns the keys of m in iteration order as a list.
+func KeysMap(m CVMObject) (CVMObject, error) {
+	if m.Tag != TAG_MAP {
+		return CVMObject{}, fmt.Errorf("%w: expected map, got %s", ErrTypeMismatch, TagsName(m.Tag))
+	}
+	entries, err := mapEntries(m)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	data := make([]byte, 0, 6)
+	data = append(data, m.Data[0], TAG_I32)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(entries)))
+	for _, e := range entries {
+		data = append(data, Bytes(e.key)...)
+	}
+	return CreateList(data)
+}
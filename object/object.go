@@ -2,8 +2,10 @@ package object
 
 import (
 	"bufio"
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"os"
+	"io"
 )
 
 const (
@@ -14,7 +16,19 @@ const (
 
 	TAG_LIST   // tag.elemTag.len.data...
 	TAG_STRING // tag.len.data...
-	TAG_STRUCT // tag.len.{fieldTags}...{data}...
+	TAG_STRUCT // tag.len.{fieldTags}...desc.{data}...
+	TAG_MAP    // tag.keyTag.valTag.len.{key.value}...
+	TAG_I64    // tag.data
+	TAG_F64    // tag.data
+	TAG_REF    // tag.handle
+	TAG_FUNC   // tag.addr.params.results.env
+)
+
+var (
+	ErrTypeMismatch    = errors.New("type mismatch")
+	ErrIndexOutOfRange = errors.New("index out of range")
+	ErrKeyNotFound     = errors.New("key not found")
+	ErrDivisionByZero  = errors.New("division by zero")
 )
 
 type CVMObject struct {
@@ -30,12 +44,22 @@ func String(obj CVMObject) (string, error) {
 		return StringBool(obj)
 	case TAG_F32:
 		return StringF32(obj)
+	case TAG_I64:
+		return StringI64(obj)
+	case TAG_F64:
+		return StringF64(obj)
 	case TAG_LIST:
 		return StringList(obj)
 	case TAG_STRING:
 		return StringString(obj)
 	case TAG_STRUCT:
 		return StringStruct(obj)
+	case TAG_MAP:
+		return StringMap(obj)
+	case TAG_REF:
+		return StringRef(obj)
+	case TAG_FUNC:
+		return StringFunc(obj)
 	default:
 		return fmt.Sprintf("(unknown)%v", obj.Data), nil
 	}
@@ -47,12 +71,20 @@ func Value(obj CVMObject) (any, error) {
 		return ValueI32(obj)
 	case TAG_F32:
 		return ValueF32(obj)
+	case TAG_I64:
+		return ValueI64(obj)
+	case TAG_F64:
+		return ValueF64(obj)
 	case TAG_BOOL:
 		return ValueBool(obj)
 	case TAG_STRING:
 		return ValueString(obj)
+	case TAG_REF:
+		return ValueRef(obj)
+	case TAG_FUNC:
+		return ValueFunc(obj)
 	default:
-		return nil, fmt.Errorf("can't get value for tag %v", TagsName(obj.Tag))
+		return nil, fmt.Errorf("%w: can't get value for tag %v", ErrTypeMismatch, TagsName(obj.Tag))
 	}
 }
 
@@ -64,17 +96,38 @@ func TagsName(tag byte) string {
 		return "i32"
 	case TAG_F32:
 		return "f32"
+	case TAG_I64:
+		return "i64"
+	case TAG_F64:
+		return "f64"
 	case TAG_BOOL:
 		return "bool"
 	case TAG_LIST:
 		return "list"
 	case TAG_STRING:
 		return "string"
+	case TAG_STRUCT:
+		return "struct"
+	case TAG_MAP:
+		return "map"
+	case TAG_REF:
+		return "ref"
+	case TAG_FUNC:
+		return "func"
 	default:
 		return "unknown"
 	}
 }
 
+func TagByName(name string) (byte, bool) {
+	for _, tag := range []byte{TAG_UNDEFINED, TAG_I32, TAG_BOOL, TAG_F32, TAG_LIST, TAG_STRING, TAG_STRUCT, TAG_MAP, TAG_I64, TAG_F64, TAG_REF, TAG_FUNC} {
+		if TagsName(tag) == name {
+			return tag, true
+		}
+	}
+	return TAG_UNDEFINED, false
+}
+
 func Bytes(obj CVMObject) []byte {
 	return append([]byte{obj.Tag}, obj.Data...)
 }
@@ -83,7 +136,7 @@ func CreateObject(val []byte) (CVMObject, error) {
 	var obj CVMObject
 	obj.Data = nil
 	switch val[0] {
-	case TAG_I32, TAG_F32, TAG_BOOL, TAG_STRING, TAG_LIST, TAG_STRUCT:
+	case TAG_I32, TAG_F32, TAG_BOOL, TAG_STRING, TAG_LIST, TAG_STRUCT, TAG_MAP, TAG_I64, TAG_F64, TAG_REF, TAG_FUNC:
 		obj.Tag = val[0]
 		obj.Data = val[1:]
 	default:
@@ -98,12 +151,24 @@ func CreateDefault(target byte) (CVMObject, error) {
 		return CreateI32(0)
 	case TAG_F32:
 		return CreateF32(0.0)
+	case TAG_I64:
+		return CreateI64(0)
+	case TAG_F64:
+		return CreateF64(0.0)
 	case TAG_BOOL:
 		return CreateBool(false)
 	case TAG_STRING:
 		return CreateString("")
 	case TAG_LIST:
 		return CreateList(nil)
+	case TAG_STRUCT:
+		return CreateNamedStruct("", nil, nil)
+	case TAG_MAP:
+		return CreateMap(TAG_UNDEFINED, TAG_UNDEFINED)
+	case TAG_REF:
+		return CreateRef(0)
+	case TAG_FUNC:
+		return CreateFunc(FUNC_NIL, 0, 0)
 	default:
 		return CVMObject{}, fmt.Errorf("cant create object with target %s", TagsName(target))
 	}
@@ -118,6 +183,13 @@ func Len(obj CVMObject) (int, error) {
 		}
 		val, err := ValueI32(l)
 		return int(val), err
+	case TAG_MAP:
+		l, err := CreateObject(obj.Data[2:7])
+		if err != nil {
+			return 0, err
+		}
+		val, err := ValueI32(l)
+		return int(val), err
 	case TAG_STRING, TAG_STRUCT:
 		l, err := CreateObject(obj.Data[:5])
 		if err != nil {
@@ -126,7 +198,7 @@ func Len(obj CVMObject) (int, error) {
 		val, err := ValueI32(l)
 		return int(val), err
 	default:
-		return 0, fmt.Errorf("can't get len of %s", TagsName(obj.Tag))
+		return 0, fmt.Errorf("%w: can't get len of %s", ErrTypeMismatch, TagsName(obj.Tag))
 	}
 }
 
@@ -134,10 +206,12 @@ func Size(obj CVMObject) (int, error) {
 	switch obj.Tag {
 	case TAG_UNDEFINED:
 		return 0, nil
-	case TAG_I32:
-		return 5, nil
-	case TAG_F32:
+	case TAG_I32, TAG_F32, TAG_REF:
 		return 5, nil
+	case TAG_I64, TAG_F64:
+		return 9, nil
+	case TAG_FUNC:
+		return 17, nil
 	case TAG_BOOL:
 		return 2, nil
 	case TAG_STRING:
@@ -155,41 +229,102 @@ func Size(obj CVMObject) (int, error) {
 		switch obj.Data[0] {
 		case TAG_UNDEFINED:
 			return 7, nil
-		case TAG_I32, TAG_BOOL, TAG_F32:
+		case TAG_I32, TAG_BOOL, TAG_F32, TAG_I64, TAG_F64, TAG_REF, TAG_FUNC:
 			itemSize, err = Size(CVMObject{Tag: obj.Data[0]})
 			if err != nil {
 				return 0, err
 			}
 			return l*itemSize + 7, nil
-		case TAG_STRING:
-			for i := 6; i < len(obj.Data); {
-				s, err := Size(CVMObject{Tag: obj.Data[i], Data: obj.Data[i+1 : i+6]})
-				if err != nil {
-					return 0, err
-				}
-				i += s
-				itemSize += s
-			}
-			return itemSize + 7, nil
-		case TAG_LIST:
-			for i := 6; i < len(obj.Data); {
-				s, err := Size(CVMObject{Tag: obj.Data[i], Data: obj.Data[i+1 : i+6]})
-				if err != nil {
-					return 0, err
-				}
-				i += s
-				itemSize += s
-			}
-			return itemSize + 7, nil
 		default:
-			return 0, fmt.Errorf("unexpected item tag %s", TagsName(obj.Data[0]))
+			return SizeAt(Bytes(obj))
 		}
+	case TAG_STRUCT, TAG_MAP:
+		return SizeAt(Bytes(obj))
 	default:
 		return 0, fmt.Errorf("unknown tag %v", obj.Tag)
 	}
 }
 
-func Print(obj CVMObject) (CVMObject, error) {
+// SizeAt returns the encoded size of the object starting at data[0].
+// Unlike Size it only reads as far as the object goes, so data may hold trailing bytes.
+func SizeAt(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, fmt.Errorf("%w: empty object data", ErrIndexOutOfRange)
+	}
+	switch data[0] {
+	case TAG_I32, TAG_F32, TAG_BOOL, TAG_I64, TAG_F64, TAG_REF, TAG_FUNC:
+		size, err := Size(CVMObject{Tag: data[0]})
+		if err != nil {
+			return 0, err
+		}
+		if size > len(data) {
+			return 0, fmt.Errorf("%w: truncated %s", ErrIndexOutOfRange, TagsName(data[0]))
+		}
+		return size, nil
+	case TAG_STRING:
+		if len(data) < 6 {
+			return 0, fmt.Errorf("%w: truncated string", ErrIndexOutOfRange)
+		}
+		size := 6 + int(binary.LittleEndian.Uint32(data[2:6]))
+		if size > len(data) {
+			return 0, fmt.Errorf("%w: truncated string", ErrIndexOutOfRange)
+		}
+		return size, nil
+	case TAG_LIST:
+		if len(data) < 7 {
+			return 0, fmt.Errorf("%w: truncated list", ErrIndexOutOfRange)
+		}
+		ln := int(binary.LittleEndian.Uint32(data[3:7]))
+		off := 7
+		for i := 0; i < ln; i++ {
+			s, err := SizeAt(data[off:])
+			if err != nil {
+				return 0, err
+			}
+			off += s
+		}
+		return off, nil
+	case TAG_STRUCT:
+		if len(data) < 6 {
+			return 0, fmt.Errorf("%w: truncated struct", ErrIndexOutOfRange)
+		}
+		ln := int(binary.LittleEndian.Uint32(data[2:6]))
+		if 6+ln >= len(data) || data[6+ln] != TAG_STRING {
+			return 0, fmt.Errorf("%w: truncated struct", ErrIndexOutOfRange)
+		}
+		desc, err := SizeAt(data[6+ln:])
+		if err != nil {
+			return 0, err
+		}
+		off := 6 + ln + desc
+		for i := 0; i < ln; i++ {
+			s, err := SizeAt(data[off:])
+			if err != nil {
+				return 0, err
+			}
+			off += s
+		}
+		return off, nil
+	case TAG_MAP:
+		if len(data) < 8 {
+			return 0, fmt.Errorf("%w: truncated map", ErrIndexOutOfRange)
+		}
+		ln := int(binary.LittleEndian.Uint32(data[4:8]))
+		off := 8
+		for i := 0; i < 2*ln; i++ {
+			s, err := SizeAt(data[off:])
+			if err != nil {
+				return 0, err
+			}
+			off += s
+		}
+		return off, nil
+	default:
+		return 0, fmt.Errorf("unknown tag %v", data[0])
+	}
+}
+
+func Print(w io.Writer, obj CVMObject) (CVMObject, error) {
 	frmtO, err := CreateString("%.")
 	if err != nil {
 		return CVMObject{}, err
@@ -202,11 +337,11 @@ func Print(obj CVMObject) (CVMObject, error) {
 	if err != nil {
 		return CVMObject{}, err
 	}
-	fmt.Fprint(os.Stdout, resV)
-	return CVMObject{}, nil
+	_, err = fmt.Fprint(w, resV)
+	return CVMObject{}, err
 }
 
-func Printf(f CVMObject, objs []CVMObject) (CVMObject, error) {
+func Printf(w io.Writer, f CVMObject, objs []CVMObject) (CVMObject, error) {
 	res, err := FormatString(f, objs)
 	if err != nil {
 		return CVMObject{}, err
@@ -215,11 +350,11 @@ func Printf(f CVMObject, objs []CVMObject) (CVMObject, error) {
 	if err != nil {
 		return CVMObject{}, err
 	}
-	fmt.Fprint(os.Stdout, resV)
-	return CVMObject{}, nil
+	_, err = fmt.Fprint(w, resV)
+	return CVMObject{}, err
 }
 
-func Println(obj CVMObject) (CVMObject, error) {
+func Println(w io.Writer, obj CVMObject) (CVMObject, error) {
 	f, err := CreateString("%.")
 	if err != nil {
 		return CVMObject{}, err
@@ -233,13 +368,15 @@ func Println(obj CVMObject) (CVMObject, error) {
 	if err != nil {
 		return CVMObject{}, err
 	}
-	fmt.Fprintln(os.Stdout, resV)
-	return CVMObject{}, nil
+	_, err = fmt.Fprintln(w, resV)
+	return CVMObject{}, err
 }
 
-func Read() (CVMObject, error) {
-	res, err := bufio.NewReader(os.Stdin).ReadString('\n')
-	if err != nil {
+// Read returns the next line of r including its line break.
+// The last line of the input may come without a line break.
+func Read(r *bufio.Reader) (CVMObject, error) {
+	res, err := r.ReadString('\n')
+	if err != nil && (err != io.EOF || res == "") {
 		return CVMObject{}, err
 	}
 	resObj, err := CreateString(res)
@@ -0,0 +1,129 @@
+package object
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// A reference is the handle of an object on the VM object heap, where lists, strings, structs and maps
+// are mutated in place instead of being copied by every operation. Handle 0 is the nil reference.
+
+// constructor
+
+func CreateRef(handle uint32) (CVMObject, error) {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, handle)
+	return CVMObject{Tag: TAG_REF, Data: data}, nil
+}
+
+// manipulation
+
+func ValueRef(obj CVMObject) (uint32, error) {
+	if obj.Tag != TAG_REF {
+		return 0, fmt.Errorf("%w: expected ref, got %s", ErrTypeMismatch, TagsName(obj.Tag))
+	}
+	return binary.LittleEndian.Uint32(obj.Data), nil
+}
+
+func StringRef(obj CVMObject) (string, error) {
+	val, err := ValueRef(obj)
+	if err != nil {
+		return "", err
+	}
+	if val == 0 {
+		return "(ref)nil", nil
+	}
+	return fmt.Sprintf("(ref)&%d", val), nil
+}
+
+// Clone returns a copy of obj that shares no data with it.
+func Clone(obj CVMObject) CVMObject {
+	data := make([]byte, len(obj.Data))
+	copy(data, obj.Data)
+	return CVMObject{Tag: obj.Tag, Data: data}
+}
+
+// Refs calls fn with the handle of every reference obj holds,
+// including the ones nested in list items, struct fields and map entries and the environments of closures.
+func Refs(obj CVMObject, fn func(handle uint32)) error {
+	switch obj.Tag {
+	case TAG_REF, TAG_LIST, TAG_STRUCT, TAG_MAP, TAG_FUNC:
+		_, err := walkRefs(obj.Tag, obj.Data, fn)
+		return err
+	default:
+		return nil
+	}
+}
+
+// walkRefs visits the references of the object with tag whose data starts at data[0] and returns the size of its data.
+func walkRefs(tag byte, data []byte, fn func(handle uint32)) (int, error) {
+	switch tag {
+	case TAG_REF:
+		if len(data) < 4 {
+			return 0, fmt.Errorf("%w: truncated ref", ErrIndexOutOfRange)
+		}
+		fn(binary.LittleEndian.Uint32(data))
+		return 4, nil
+	case TAG_FUNC:
+		if len(data) < 16 {
+			return 0, fmt.Errorf("%w: truncated func", ErrIndexOutOfRange)
+		}
+		fn(binary.LittleEndian.Uint32(data[12:]))
+		return 16, nil
+	case TAG_LIST:
+		if len(data) < 6 {
+			return 0, fmt.Errorf("%w: truncated list", ErrIndexOutOfRange)
+		}
+		ln := int(binary.LittleEndian.Uint32(data[2:6]))
+		switch data[0] {
+		case TAG_I32, TAG_BOOL, TAG_F32, TAG_I64, TAG_F64:
+			// items of these lists can't hold references, only their size is needed
+			itemSize, err := Size(CVMObject{Tag: data[0]})
+			return 6 + ln*itemSize, err
+		}
+		return walkItems(data, 6, ln, fn)
+	case TAG_STRUCT:
+		if len(data) < 5 {
+			return 0, fmt.Errorf("%w: truncated struct", ErrIndexOutOfRange)
+		}
+		ln := int(binary.LittleEndian.Uint32(data[1:5]))
+		if 5+ln >= len(data) {
+			return 0, fmt.Errorf("%w: truncated struct", ErrIndexOutOfRange)
+		}
+		desc, err := SizeAt(data[5+ln:])
+		if err != nil {
+			return 0, err
+		}
+		return walkItems(data, 5+ln+desc, ln, fn)
+	case TAG_MAP:
+		if len(data) < 7 {
+			return 0, fmt.Errorf("%w: truncated map", ErrIndexOutOfRange)
+		}
+		return walkItems(data, 7, 2*int(binary.LittleEndian.Uint32(data[3:7])), fn)
+	default:
+		return 0, fmt.Errorf("%w: %s holds no references", ErrTypeMismatch, TagsName(tag))
+	}
+}
+
+// walkItems visits the references of n encoded objects starting at data[off] and returns the offset after them.
+func walkItems(data []byte, off, n int, fn func(handle uint32)) (int, error) {
+	for i := 0; i < n; i++ {
+		if off >= len(data) {
+			return 0, fmt.Errorf("%w: truncated item", ErrIndexOutOfRange)
+		}
+		var size int
+		var err error
+		switch data[off] {
+		case TAG_REF, TAG_LIST, TAG_STRUCT, TAG_MAP, TAG_FUNC:
+			size, err = walkRefs(data[off], data[off+1:], fn)
+			size++
+		default:
+			size, err = SizeAt(data[off:])
+		}
+		if err != nil {
+			return 0, err
+		}
+		off += size
+	}
+	return off, nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 // constructor
@@ -26,7 +27,7 @@ func CreateString(str string) (CVMObject, error) {
 
 func ValueString(obj CVMObject) (string, error) {
 	if obj.Tag != TAG_STRING {
-		return "", fmt.Errorf("expected string, got %s", TagsName(obj.Tag))
+		return "", fmt.Errorf("%w: expected string, got %s", ErrTypeMismatch, TagsName(obj.Tag))
 	}
 	val := bytes.NewBuffer(obj.Data[5:])
 	return val.String(), nil
@@ -47,7 +48,19 @@ func AsString(obj CVMObject) (CVMObject, error) {
 		if err != nil {
 			return CVMObject{}, err
 		}
-		return CreateString(strconv.FormatFloat(float64(val), 'e', -1, 32))
+		return CreateString(formatFloat(float64(val), 'e', -1, 32))
+	case TAG_I64:
+		val, err := ValueI64(obj)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		return CreateString(strconv.FormatInt(val, 10))
+	case TAG_F64:
+		val, err := ValueF64(obj)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		return CreateString(formatFloat(val, 'e', -1, 64))
 	case TAG_BOOL:
 		val, err := ValueBool(obj)
 		if err != nil {
@@ -55,21 +68,13 @@ func AsString(obj CVMObject) (CVMObject, error) {
 		}
 		return CreateString(strconv.FormatBool(val))
 	case TAG_LIST:
-		ln, err := Len(obj)
+		items, err := listItems(obj)
 		if err != nil {
 			return CVMObject{}, err
 		}
 		var buf strings.Builder
 		buf.WriteString("[")
-		for i := 0; i < ln; i++ {
-			ind, err := CreateI32(int32(i))
-			if err != nil {
-				return CVMObject{}, err
-			}
-			item, err := GetList(obj, ind)
-			if err != nil {
-				return CVMObject{}, err
-			}
+		for _, item := range items {
 			res, err := AsString(item)
 			if err != nil {
 				return CVMObject{}, err
@@ -83,14 +88,37 @@ func AsString(obj CVMObject) (CVMObject, error) {
 		}
 		buf.WriteString(" ]")
 		return CreateString(buf.String())
+	case TAG_MAP:
+		return asStringMap(obj)
+	case TAG_REF:
+		val, err := ValueRef(obj)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		if val == 0 {
+			return CreateString("nil")
+		}
+		return CreateString("&" + strconv.FormatUint(uint64(val), 10))
+	case TAG_FUNC:
+		str, err := funcString(obj)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		return CreateString(str)
+	case TAG_STRUCT:
+		str, err := StringStruct(obj)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		return CreateString(str)
 	default:
-		return CVMObject{}, fmt.Errorf("can't convert %s to string", TagsName(obj.Tag))
+		return CVMObject{}, fmt.Errorf("%w: can't convert %s to string", ErrTypeMismatch, TagsName(obj.Tag))
 	}
 }
 
 func StringString(obj CVMObject) (string, error) {
 	if obj.Tag != TAG_STRING {
-		return "", fmt.Errorf("expected string, got %s", TagsName(obj.Tag))
+		return "", fmt.Errorf("%w: expected string, got %s", ErrTypeMismatch, TagsName(obj.Tag))
 	}
 	ln, err := Len(obj)
 	if err != nil {
@@ -105,31 +133,19 @@ func StringString(obj CVMObject) (string, error) {
 
 // actions
 
+// ConcatString returns a new string, str1.Data may share its backing array with a list or map.
 func ConcatString(str1, str2 CVMObject) (CVMObject, error) {
-	resObj := CVMObject{
-		Tag: TAG_STRING,
-	}
-	var temp []byte
-	ln1, err := CreateObject(str1.Data[:5])
+	v1, v2, err := stringPair(str1, str2)
 	if err != nil {
-		return resObj, err
-	}
-	ln2, err := CreateObject(str2.Data[:5])
-	if err != nil {
-		return resObj, err
-	}
-	resLen, err := AddI32(ln1, ln2)
-	if err != nil {
-		return resObj, err
+		return CVMObject{}, err
 	}
-	temp = Bytes(resLen)
-	temp = append(temp, append(str1.Data[5:], str2.Data[5:]...)...)
-	resObj.Data = make([]byte, len(temp))
-	copy(resObj.Data, temp)
-	return resObj, nil
+	return CreateString(v1 + v2)
 }
 
 func LenString(str CVMObject) (CVMObject, error) {
+	if str.Tag != TAG_STRING {
+		return CVMObject{}, fmt.Errorf("%w: expected string, got %s", ErrTypeMismatch, TagsName(str.Tag))
+	}
 	ln, err := Len(str)
 	if err != nil {
 		return CVMObject{}, err
@@ -141,10 +157,10 @@ func LenString(str CVMObject) (CVMObject, error) {
 func SplitString(str CVMObject, sep CVMObject) (CVMObject, error) {
 	var list CVMObject
 	if str.Tag != TAG_STRING {
-		return list, fmt.Errorf("invalid string, got %s", TagsName(str.Tag))
+		return list, fmt.Errorf("%w: invalid string, got %s", ErrTypeMismatch, TagsName(str.Tag))
 	}
 	if sep.Tag != TAG_STRING {
-		return list, fmt.Errorf("invalid string, got %s", TagsName(sep.Tag))
+		return list, fmt.Errorf("%w: invalid string, got %s", ErrTypeMismatch, TagsName(sep.Tag))
 	}
 	buf := make([]byte, 0, 6)
 	buf = append(buf, TAG_STRING)
@@ -180,28 +196,285 @@ func SplitString(str CVMObject, sep CVMObject) (CVMObject, error) {
 	return list, err
 }
 
-func FormatString(str CVMObject, data []CVMObject) (CVMObject, error) {
-	var resObj CVMObject
-	valStr, err := ValueString(str)
+// Byte offsets passed to the string functions below must fall on rune boundaries,
+// so their results are always valid UTF-8. The Rune variants count runes instead of bytes.
+
+func checkBoundary(str string, off int32) error {
+	if off < 0 || int(off) > len(str) {
+		return fmt.Errorf("%w: %d", ErrIndexOutOfRange, off)
+	}
+	if int(off) < len(str) && !utf8.RuneStart(str[off]) {
+		return fmt.Errorf("%w: offset %d is inside a rune", ErrIndexOutOfRange, off)
+	}
+	return nil
+}
+
+// runeOffset converts a rune index of str to a byte offset, ind may be one past the last rune.
+func runeOffset(str string, ind int32) (int32, error) {
+	if ind < 0 {
+		return 0, fmt.Errorf("%w: %d", ErrIndexOutOfRange, ind)
+	}
+	n := int32(0)
+	for off := range str {
+		if n == ind {
+			return int32(off), nil
+		}
+		n++
+	}
+	if n == ind {
+		return int32(len(str)), nil
+	}
+	return 0, fmt.Errorf("%w: %d", ErrIndexOutOfRange, ind)
+}
+
+func RuneLenString(str CVMObject) (CVMObject, error) {
+	val, err := ValueString(str)
 	if err != nil {
-		return resObj, err
+		return CVMObject{}, err
 	}
-	l := strings.Count(valStr, "%.")
-	g := len(data)
-	if l != g {
-		return resObj, fmt.Errorf("invalid value format points (%d) and processing data (%d)", l, g)
+	return CreateI32(int32(utf8.RuneCountInString(val)))
+}
+
+// SliceString returns the bytes from start up to but not including end.
+func SliceString(str, start, end CVMObject) (CVMObject, error) {
+	val, err := ValueString(str)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	startV, err := ValueI32(start)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	endV, err := ValueI32(end)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	if err := checkBoundary(val, startV); err != nil {
+		return CVMObject{}, err
+	}
+	if err := checkBoundary(val, endV); err != nil {
+		return CVMObject{}, err
+	}
+	if startV > endV {
+		return CVMObject{}, fmt.Errorf("%w: slice [%d:%d]", ErrIndexOutOfRange, startV, endV)
+	}
+	return CreateString(val[startV:endV])
+}
+
+func RuneSliceString(str, start, end CVMObject) (CVMObject, error) {
+	val, err := ValueString(str)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	startV, err := ValueI32(start)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	endV, err := ValueI32(end)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	if startV > endV {
+		return CVMObject{}, fmt.Errorf("%w: slice [%d:%d]", ErrIndexOutOfRange, startV, endV)
+	}
+	startOff, err := runeOffset(val, startV)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	endOff, err := runeOffset(val, endV)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateString(val[startOff:endOff])
+}
+
+// IndexString returns the byte offset of the first occurrence of sub, or -1.
+func IndexString(str, sub CVMObject) (CVMObject, error) {
+	val, subV, err := stringPair(str, sub)
+	if err != nil {
+		return CVMObject{}, err
 	}
-	for i := l - 1; i >= 0; i-- {
-		sO, err := AsString(data[i])
+	return CreateI32(int32(strings.Index(val, subV)))
+}
+
+func RuneIndexString(str, sub CVMObject) (CVMObject, error) {
+	val, subV, err := stringPair(str, sub)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	off := strings.Index(val, subV)
+	if off < 0 {
+		return CreateI32(-1)
+	}
+	return CreateI32(int32(utf8.RuneCountInString(val[:off])))
+}
+
+// CharAtString returns the rune starting at byte offset ind as a string.
+func CharAtString(str, ind CVMObject) (CVMObject, error) {
+	val, err := ValueString(str)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	off, err := ValueI32(ind)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	if int(off) == len(val) {
+		return CVMObject{}, fmt.Errorf("%w: %d", ErrIndexOutOfRange, off)
+	}
+	if err := checkBoundary(val, off); err != nil {
+		return CVMObject{}, err
+	}
+	_, size := utf8.DecodeRuneInString(val[off:])
+	return CreateString(val[off : int(off)+size])
+}
+
+func RuneAtString(str, ind CVMObject) (CVMObject, error) {
+	val, err := ValueString(str)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	indV, err := ValueI32(ind)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	off, err := runeOffset(val, indV)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	if int(off) == len(val) {
+		return CVMObject{}, fmt.Errorf("%w: %d", ErrIndexOutOfRange, indV)
+	}
+	_, size := utf8.DecodeRuneInString(val[off:])
+	return CreateString(val[off : int(off)+size])
+}
+
+func ContainsString(str, sub CVMObject) (CVMObject, error) {
+	val, subV, err := stringPair(str, sub)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(strings.Contains(val, subV))
+}
+
+func HasPrefixString(str, prefix CVMObject) (CVMObject, error) {
+	val, prefixV, err := stringPair(str, prefix)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(strings.HasPrefix(val, prefixV))
+}
+
+func HasSuffixString(str, suffix CVMObject) (CVMObject, error) {
+	val, suffixV, err := stringPair(str, suffix)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateBool(strings.HasSuffix(val, suffixV))
+}
+
+// ReplaceString replaces every occurrence of old.
+func ReplaceString(str, old, new CVMObject) (CVMObject, error) {
+	val, oldV, err := stringPair(str, old)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	newV, err := ValueString(new)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateString(strings.ReplaceAll(val, oldV, newV))
+}
+
+// TrimString removes leading and trailing white space as defined by Unicode.
+func TrimString(str CVMObject) (CVMObject, error) {
+	val, err := ValueString(str)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateString(strings.TrimSpace(val))
+}
+
+func UpperString(str CVMObject) (CVMObject, error) {
+	val, err := ValueString(str)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateString(strings.ToUpper(val))
+}
+
+func LowerString(str CVMObject) (CVMObject, error) {
+	val, err := ValueString(str)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateString(strings.ToLower(val))
+}
+
+// JoinString concatenates a list of strings with sep between the items.
+func JoinString(list, sep CVMObject) (CVMObject, error) {
+	if list.Tag != TAG_LIST {
+		return CVMObject{}, fmt.Errorf("%w: expected list, got %s", ErrTypeMismatch, TagsName(list.Tag))
+	}
+	sepV, err := ValueString(sep)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	ln, err := Len(list)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	if ln > 0 && list.Data[0] != TAG_STRING {
+		return CVMObject{}, fmt.Errorf("%w: expected string list, got %s list", ErrTypeMismatch, TagsName(list.Data[0]))
+	}
+	items := make([]string, 0, ln)
+	for off := 6; len(items) < ln; {
+		size, err := SizeAt(list.Data[off:])
 		if err != nil {
-			return resObj, err
+			return CVMObject{}, err
 		}
-		sV, err := ValueString(sO)
+		item, err := CreateObject(list.Data[off : off+size])
 		if err != nil {
-			return resObj, err
+			return CVMObject{}, err
 		}
-		valStr = strings.Replace(valStr, "%.", sV, 1)
+		itemV, err := ValueString(item)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		items = append(items, itemV)
+		off += size
+	}
+	return CreateString(strings.Join(items, sepV))
+}
+
+// CompareString returns -1, 0 or 1 comparing the strings bytewise.
+func CompareString(str1, str2 CVMObject) (CVMObject, error) {
+	v1, v2, err := stringPair(str1, str2)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	return CreateI32(int32(strings.Compare(v1, v2)))
+}
+
+func stringPair(str1, str2 CVMObject) (string, string, error) {
+	v1, err := ValueString(str1)
+	if err != nil {
+		return "", "", err
+	}
+	v2, err := ValueString(str2)
+	return v1, v2, err
+}
+
+// AppendStringInPlace appends the bytes of obj to str, reusing the capacity of str.Data.
+// It is only safe when no other object shares str.Data, as for objects owned by the object heap.
+func AppendStringInPlace(str *CVMObject, obj CVMObject) error {
+	if str.Tag != TAG_STRING {
+		return fmt.Errorf("%w: expected string, got %s", ErrTypeMismatch, TagsName(str.Tag))
+	}
+	if obj.Tag != TAG_STRING {
+		return fmt.Errorf("%w: expected string, got %s", ErrTypeMismatch, TagsName(obj.Tag))
 	}
-	resObj, err = CreateString(valStr)
-	return resObj, nil
+	str.Data = append(str.Data, obj.Data[5:]...)
+	binary.LittleEndian.PutUint32(str.Data[1:5], uint32(len(str.Data)-5))
+	return nil
 }
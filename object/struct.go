@@ -3,8 +3,14 @@ package object
 import (
 	"bytes"
 	"fmt"
+	"strconv"
+	"strings"
 )
 
+// Every struct carries a descriptor string between its field tags and its data.
+// Anonymous structs have an empty descriptor, named ones hold the type name
+// followed by the field names, separated by spaces.
+
 // constructor
 
 func CreateStruct(data []byte) (CVMObject, error) {
@@ -19,11 +25,102 @@ func CreateStruct(data []byte) (CVMObject, error) {
 	return obj, nil
 }
 
+// CreateNamedStruct builds a struct with default field values.
+// An empty name creates an anonymous struct, fields may then be nil.
+func CreateNamedStruct(name string, fields []string, tags []byte) (CVMObject, error) {
+	if name != "" && len(fields) != len(tags) {
+		return CVMObject{}, fmt.Errorf("struct %s has %d field names for %d fields", name, len(fields), len(tags))
+	}
+	lO, err := CreateI32(int32(len(tags)))
+	if err != nil {
+		return CVMObject{}, err
+	}
+	desc := ""
+	if name != "" {
+		desc = strings.Join(append([]string{name}, fields...), " ")
+	}
+	dO, err := CreateString(desc)
+	if err != nil {
+		return CVMObject{}, err
+	}
+	data := Bytes(lO)
+	data = append(data, tags...)
+	data = append(data, Bytes(dO)...)
+	for _, tag := range tags {
+		obj, err := CreateDefault(tag)
+		if err != nil {
+			return CVMObject{}, err
+		}
+		data = append(data, Bytes(obj)...)
+	}
+	return CVMObject{Tag: TAG_STRUCT, Data: data}, nil
+}
+
+// CreateStructOf builds an anonymous struct whose fields hold copies of objs.
+func CreateStructOf(objs []CVMObject) (CVMObject, error) {
+	lO, err := CreateI32(int32(len(objs)))
+	if err != nil {
+		return CVMObject{}, err
+	}
+	dO, err := CreateString("")
+	if err != nil {
+		return CVMObject{}, err
+	}
+	data := Bytes(lO)
+	for _, obj := range objs {
+		data = append(data, obj.Tag)
+	}
+	data = append(data, Bytes(dO)...)
+	for _, obj := range objs {
+		data = append(data, Bytes(obj)...)
+	}
+	return CVMObject{Tag: TAG_STRUCT, Data: data}, nil
+}
+
 // manipulation
 
+// StructType returns the type name and field names of a named struct.
+// Both are empty for anonymous structs.
+func StructType(obj CVMObject) (string, []string, error) {
+	if obj.Tag != TAG_STRUCT {
+		return "", nil, fmt.Errorf("%w: expected struct, got %s", ErrTypeMismatch, TagsName(obj.Tag))
+	}
+	ln, err := Len(obj)
+	if err != nil {
+		return "", nil, err
+	}
+	if 5+ln >= len(obj.Data) {
+		return "", nil, fmt.Errorf("%w: truncated struct", ErrIndexOutOfRange)
+	}
+	size, err := SizeAt(obj.Data[5+ln:])
+	if err != nil {
+		return "", nil, err
+	}
+	desc, err := CreateObject(obj.Data[5+ln : 5+ln+size])
+	if err != nil {
+		return "", nil, err
+	}
+	descV, err := ValueString(desc)
+	if err != nil || descV == "" {
+		return "", nil, err
+	}
+	names := strings.Split(descV, " ")
+	if len(names) != ln+1 {
+		return "", nil, fmt.Errorf("corrupted struct descriptor %q", descV)
+	}
+	return names[0], names[1:], nil
+}
+
 func StringStruct(obj CVMObject) (string, error) {
 	if obj.Tag != TAG_STRUCT {
-		return "", fmt.Errorf("invalid struct tag %s", TagsName(obj.Tag))
+		return "", fmt.Errorf("%w: invalid struct tag %s", ErrTypeMismatch, TagsName(obj.Tag))
+	}
+	name, fields, err := StructType(obj)
+	if err != nil {
+		return "", err
+	}
+	if name != "" {
+		return stringNamedStruct(obj, name, fields)
 	}
 	var buf bytes.Buffer
 	fmt.Fprint(&buf, "struct{ ")
@@ -35,107 +132,147 @@ func StringStruct(obj CVMObject) (string, error) {
 		fmt.Fprintf(&buf, "%s ", TagsName(obj.Data[i]))
 	}
 	fmt.Fprint(&buf, "}{ ")
-	for i := ln + 5; i < len(obj.Data); {
-		switch obj.Data[i] {
-		case TAG_F32, TAG_I32, TAG_BOOL:
-			s, err := Size(CVMObject{Tag: obj.Data[i]})
-			if err != nil {
-				return buf.String(), err
-			}
-			tO, err := CreateObject(obj.Data[i : i+s])
-			if err != nil {
-				return buf.String(), err
-			}
-			tS, err := String(tO)
+	for i := 0; i < ln; i++ {
+		start, end, err := fieldRange(obj, i)
+		if err != nil {
+			return buf.String(), err
+		}
+		tO, err := CreateObject(obj.Data[start:end])
+		if err != nil {
+			return buf.String(), err
+		}
+		tS, err := String(tO)
+		if err != nil {
+			return buf.String(), err
+		}
+		fmt.Fprintf(&buf, "%s ", tS)
+	}
+	fmt.Fprint(&buf, "}")
+	return buf.String(), nil
+}
+
+// stringNamedStruct renders a named struct as Name{field: value, ...}.
+func stringNamedStruct(obj CVMObject, name string, fields []string) (string, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s{", name)
+	for i, field := range fields {
+		start, end, err := fieldRange(obj, i)
+		if err != nil {
+			return buf.String(), err
+		}
+		tO, err := CreateObject(obj.Data[start:end])
+		if err != nil {
+			return buf.String(), err
+		}
+		var tS string
+		switch tO.Tag {
+		case TAG_I32, TAG_F32, TAG_BOOL, TAG_I64, TAG_F64, TAG_REF, TAG_FUNC:
+			sO, err := AsString(tO)
 			if err != nil {
 				return buf.String(), err
 			}
-			fmt.Fprintf(&buf, "%s ", tS)
-			i += s
-		case TAG_STRING, TAG_LIST:
-			s, err := Size(CVMObject{Tag: obj.Data[i], Data: obj.Data[i+1 : i+6]})
+			tS, err = ValueString(sO)
 			if err != nil {
 				return buf.String(), err
 			}
-			tO, err := CreateObject(obj.Data[i : i+s])
+		case TAG_STRING:
+			val, err := ValueString(tO)
 			if err != nil {
 				return buf.String(), err
 			}
-			tS, err := String(tO)
+			tS = strconv.Quote(val)
+		default:
+			tS, err = String(tO)
 			if err != nil {
 				return buf.String(), err
 			}
-			fmt.Fprintf(&buf, "%s ", tS)
-			i += s
-		default:
-			return buf.String(), fmt.Errorf("unexpected struct field tag %s", TagsName(obj.Data[i]))
 		}
+		if i > 0 {
+			fmt.Fprint(&buf, ", ")
+		}
+		fmt.Fprintf(&buf, "%s: %s", field, tS)
 	}
 	fmt.Fprint(&buf, "}")
 	return buf.String(), nil
 }
 
+// fieldRange returns the bounds of field ind inside strct.Data.
+// Fields are laid out back to back after the descriptor, so every field before ind has to be walked.
+func fieldRange(strct CVMObject, ind int) (int, int, error) {
+	ln, err := Len(strct)
+	if err != nil {
+		return 0, 0, err
+	}
+	if ln <= 0 {
+		return 0, 0, fmt.Errorf("%w: struct is empty", ErrIndexOutOfRange)
+	}
+	if ind < 0 || ln <= ind {
+		return 0, 0, fmt.Errorf("%w: %d", ErrIndexOutOfRange, ind)
+	}
+	if 5+ln >= len(strct.Data) {
+		return 0, 0, fmt.Errorf("%w: truncated struct", ErrIndexOutOfRange)
+	}
+	desc, err := SizeAt(strct.Data[5+ln:])
+	if err != nil {
+		return 0, 0, err
+	}
+	off := 5 + ln + desc
+	for i := 0; ; i++ {
+		if off >= len(strct.Data) || strct.Data[off] != strct.Data[5+i] {
+			return 0, 0, fmt.Errorf("corrupted struct field %d", i)
+		}
+		size, err := SizeAt(strct.Data[off:])
+		if err != nil {
+			return 0, 0, err
+		}
+		if i == ind {
+			return off, off + size, nil
+		}
+		off += size
+	}
+}
+
 // actions
 
 func GetStruct(strct, ind CVMObject) (CVMObject, error) {
 	var obj CVMObject
 	if strct.Tag != TAG_STRUCT {
-		return obj, fmt.Errorf("expected struct, got %s", TagsName(strct.Tag))
+		return obj, fmt.Errorf("%w: expected struct, got %s", ErrTypeMismatch, TagsName(strct.Tag))
 	}
-	temp, err := ValueI32(ind)
+	indVal, err := ValueI32(ind)
 	if err != nil {
 		return obj, err
 	}
-	indVal := int(temp)
-	ln, err := Len(strct)
+	start, end, err := fieldRange(strct, int(indVal))
 	if err != nil {
 		return obj, err
 	}
-	if ln <= 0 {
-		return obj, fmt.Errorf("struct is empty")
-	}
-	if ln <= indVal {
-		return obj, fmt.Errorf("index %d out of range", indVal)
-	}
-	offStart := 5 + ln
-	offEnd := 0
-	switch strct.Data[offStart] {
-	case TAG_BOOL, TAG_F32, TAG_I32:
-		size, err := Size(CVMObject{Tag: strct.Data[0]})
-		if err != nil {
-			return strct, err
-		}
-		offStart += indVal * size
-		offEnd = offStart + size
-	case TAG_STRING:
-		size := 0
-		for i := 0; i < indVal+1; i++ {
-			s, err := Size(CVMObject{Tag: TAG_STRING, Data: strct.Data[offStart+1 : offStart+5]})
-			if err != nil {
-				return strct, err
-			}
-			offStart += s
-			size = s
-		}
-		offEnd = offStart
-		offStart -= size
-	case TAG_LIST:
-		size := 0
-		for i := 0; i < indVal+1; i++ {
-			s, err := Size(CVMObject{Tag: TAG_LIST, Data: strct.Data[offStart+1 : offStart+7]})
-			if err != nil {
-				return strct, err
-			}
-			offStart += s
-			size = s
-		}
-		offEnd = offStart
-		offStart -= size
+	obj, err = CreateObject(strct.Data[start:end])
+	if err != nil {
+		return obj, err
 	}
-	obj, err = CreateObject(strct.Data[offStart:offEnd])
-	return obj, err
+	obj.Data = append([]byte(nil), obj.Data...)
+	return obj, nil
 }
 
 func SetStruct(oldStruct, ind, obj CVMObject) (CVMObject, error) {
-	return oldStruct, nil
+	if oldStruct.Tag != TAG_STRUCT {
+		return oldStruct, fmt.Errorf("%w: expected struct, got %s", ErrTypeMismatch, TagsName(oldStruct.Tag))
+	}
+	indVal, err := ValueI32(ind)
+	if err != nil {
+		return oldStruct, err
+	}
+	start, end, err := fieldRange(oldStruct, int(indVal))
+	if err != nil {
+		return oldStruct, err
+	}
+	if tag := oldStruct.Data[5+int(indVal)]; tag != obj.Tag {
+		return oldStruct, fmt.Errorf("%w: struct field %d is %s, got %s", ErrTypeMismatch, indVal, TagsName(tag), TagsName(obj.Tag))
+	}
+	data := make([]byte, 0, len(oldStruct.Data)-(end-start)+1+len(obj.Data))
+	data = append(data, oldStruct.Data[:start]...)
+	data = append(data, Bytes(obj)...)
+	data = append(data, oldStruct.Data[end:]...)
+	return CVMObject{Tag: TAG_STRUCT, Data: data}, nil
 }
@@ -0,0 +1,679 @@
+package cvm
+
+import (
+	"context"
+	"cvm/instruction"
+	"cvm/object"
+	"fmt"
+	"math"
+)
+
+// handler runs the linked instruction o at ip and returns the index of the next instruction.
+type handler func(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error)
+
+// Handler runs a custom opcode, instr carries its operands in the layout the opcode was registered with.
+type Handler func(ctx context.Context, vm *CVM, instr *instruction.Instruction) error
+
+// RegisterOpcode adds a custom opcode to the instruction table and makes h run it.
+// Like instruction.Register it must be called while the program initializes.
+func RegisterOpcode(opcode instruction.Opcode, h Handler) error {
+	if h == nil {
+		return fmt.Errorf("opcode %s has no handler", opcode.Mnemonic)
+	}
+	if err := instruction.Register(opcode); err != nil {
+		return err
+	}
+	handlers[opcode.Kind] = func(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+		return ip + 1, h(ctx, vm, o.instr)
+	}
+	return nil
+}
+
+// halted is returned by halt, it lies past the end of every program.
+const halted = math.MaxUint32
+
+// handlers maps every kind to the code running it, kinds without a handler are unknown instructions.
+var handlers = [256]handler{
+	instruction.OP_NULL:               execNull,
+	instruction.OP_HALT:               execHalt,
+	instruction.OP_I32_LOAD:           execConst,
+	instruction.OP_I32_NEG:            unary(object.NegI32),
+	instruction.OP_I32_ADD:            binaryI32(func(v1, v2 int32) int32 { return v1 + v2 }),
+	instruction.OP_I32_SUB:            binaryI32(func(v1, v2 int32) int32 { return v1 - v2 }),
+	instruction.OP_I32_MUL:            binaryI32(func(v1, v2 int32) int32 { return v1 * v2 }),
+	instruction.OP_I32_DIV:            binary(object.DivI32),
+	instruction.OP_I32_LT:             binary(object.LtI32),
+	instruction.OP_I32_GT:             binary(object.GtI32),
+	instruction.OP_I32_LEQ:            binary(object.LeqI32),
+	instruction.OP_I32_GEQ:            binary(object.GeqI32),
+	instruction.OP_I32_EQ:             binary(object.EqI32),
+	instruction.OP_I32_NEQ:            binary(object.NeqI32),
+	instruction.OP_I32_REM:            binary(object.RemI32),
+	instruction.OP_I32_AND:            binaryI32(func(v1, v2 int32) int32 { return v1 & v2 }),
+	instruction.OP_I32_OR:             binaryI32(func(v1, v2 int32) int32 { return v1 | v2 }),
+	instruction.OP_I32_XOR:            binaryI32(func(v1, v2 int32) int32 { return v1 ^ v2 }),
+	instruction.OP_I32_NOT:            unary(object.NotI32),
+	instruction.OP_I32_SHL:            binary(object.ShlI32),
+	instruction.OP_I32_SHR:            binary(object.ShrI32),
+	instruction.OP_I32_SHRU:           binary(object.ShruI32),
+	instruction.OP_BOOL_LOAD:          execConst,
+	instruction.OP_BOOL_NOT:           unary(object.NotBool),
+	instruction.OP_BOOL_AND:           binary(object.AndBool),
+	instruction.OP_BOOL_OR:            binary(object.OrBool),
+	instruction.OP_BOOL_NAND:          binary(object.NandBool),
+	instruction.OP_BOOL_NOR:           binary(object.NorBool),
+	instruction.OP_BOOL_XOR:           binary(object.XorBool),
+	instruction.OP_F32_LOAD:           execConst,
+	instruction.OP_F32_NEG:            unary(object.NegF32),
+	instruction.OP_F32_ADD:            binary(object.AddF32),
+	instruction.OP_F32_SUB:            binary(object.SubF32),
+	instruction.OP_F32_MUL:            binary(object.MulF32),
+	instruction.OP_F32_DIV:            binary(object.DivF32),
+	instruction.OP_F32_LT:             binary(object.LtF32),
+	instruction.OP_F32_GT:             binary(object.GtF32),
+	instruction.OP_F32_LEQ:            binary(object.LeqF32),
+	instruction.OP_F32_GEQ:            binary(object.GeqF32),
+	instruction.OP_F32_EQ:             binary(object.EqF32),
+	instruction.OP_F32_NEQ:            binary(object.NeqF32),
+	instruction.OP_F32_SQRT:           unary(object.SqrtF32),
+	instruction.OP_F32_POW:            binary(object.PowF32),
+	instruction.OP_F32_FLOOR:          unary(object.FloorF32),
+	instruction.OP_F32_CEIL:           unary(object.CeilF32),
+	instruction.OP_F32_ROUND:          unary(object.RoundF32),
+	instruction.OP_F32_ABS:            unary(object.AbsF32),
+	instruction.OP_F32_MIN:            binary(object.MinF32),
+	instruction.OP_F32_MAX:            binary(object.MaxF32),
+	instruction.OP_F32_SIN:            unary(object.SinF32),
+	instruction.OP_F32_COS:            unary(object.CosF32),
+	instruction.OP_F32_EXP:            unary(object.ExpF32),
+	instruction.OP_F32_LOG:            unary(object.LogF32),
+	instruction.OP_F32_IS_NAN:         unary(object.IsNaNF32),
+	instruction.OP_F32_IS_INF:         unary(object.IsInfF32),
+	instruction.OP_I64_LOAD:           execConst,
+	instruction.OP_I64_NEG:            unary(object.NegI64),
+	instruction.OP_I64_ADD:            binary(object.AddI64),
+	instruction.OP_I64_SUB:            binary(object.SubI64),
+	instruction.OP_I64_MUL:            binary(object.MulI64),
+	instruction.OP_I64_DIV:            binary(object.DivI64),
+	instruction.OP_I64_LT:             binary(object.LtI64),
+	instruction.OP_I64_GT:             binary(object.GtI64),
+	instruction.OP_I64_LEQ:            binary(object.LeqI64),
+	instruction.OP_I64_GEQ:            binary(object.GeqI64),
+	instruction.OP_I64_EQ:             binary(object.EqI64),
+	instruction.OP_I64_NEQ:            binary(object.NeqI64),
+	instruction.OP_F64_LOAD:           execConst,
+	instruction.OP_F64_NEG:            unary(object.NegF64),
+	instruction.OP_F64_ADD:            binary(object.AddF64),
+	instruction.OP_F64_SUB:            binary(object.SubF64),
+	instruction.OP_F64_MUL:            binary(object.MulF64),
+	instruction.OP_F64_DIV:            binary(object.DivF64),
+	instruction.OP_F64_LT:             binary(object.LtF64),
+	instruction.OP_F64_GT:             binary(object.GtF64),
+	instruction.OP_F64_LEQ:            binary(object.LeqF64),
+	instruction.OP_F64_GEQ:            binary(object.GeqF64),
+	instruction.OP_F64_EQ:             binary(object.EqF64),
+	instruction.OP_F64_NEQ:            binary(object.NeqF64),
+	instruction.OP_JUMP:               execJump,
+	instruction.OP_JUMPC:              execJumpIf(true),
+	instruction.OP_JUMPNC:             execJumpIf(false),
+	instruction.OP_BLOCK_START:        execBlockStart,
+	instruction.OP_BLOCK_BR:           execBlockBr,
+	instruction.OP_BLOCK_END:          execBlockEnd,
+	instruction.OP_BLOCK_LOAD:         execBlockLoad,
+	instruction.OP_BLOCK_SAVE:         execBlockSave,
+	instruction.OP_LOAD:               execLoad,
+	instruction.OP_SAVE:               execSave,
+	instruction.OP_FREE:               execFree,
+	instruction.OP_NEW:                execNew,
+	instruction.OP_POP:                execPop,
+	instruction.OP_FUNC_CALL:          execFuncCall,
+	instruction.OP_FUNC_RET:           execFuncRet,
+	instruction.OP_LOCAL_LOAD:         execLocalLoad,
+	instruction.OP_LOCAL_SAVE:         execLocalSave,
+	instruction.OP_LIST_NEW:           execConst,
+	instruction.OP_LIST_LENGTH:        unary(object.LenList),
+	instruction.OP_LIST_GET:           binary(object.GetList),
+	instruction.OP_LIST_INSERT:        ternary(object.InsertList),
+	instruction.OP_LIST_REMOVE:        binary(object.RemoveList),
+	instruction.OP_LIST_REPLACE:       ternary(object.ReplaceList),
+	instruction.OP_LIST_APPEND:        binary(object.AppendList),
+	instruction.OP_LIST_POP:           execListPop,
+	instruction.OP_LIST_SLICE:         ternary(object.SliceList),
+	instruction.OP_LIST_CONCAT:        binary(object.ConcatList),
+	instruction.OP_LIST_REVERSE:       unary(object.ReverseList),
+	instruction.OP_LIST_INDEX:         binary(object.IndexList),
+	instruction.OP_LIST_CONTAINS:      binary(object.ContainsList),
+	instruction.OP_LIST_SORT:          unary(object.SortList),
+	instruction.OP_STRING_LOAD:        execConst,
+	instruction.OP_STRING_CONCAT:      binary(object.ConcatString),
+	instruction.OP_STRING_SPLIT:       binary(object.SplitString),
+	instruction.OP_STRING_FORMAT:      nary(object.FormatString),
+	instruction.OP_STRING_LENGTH:      unary(object.LenString),
+	instruction.OP_STRING_RUNE_LENGTH: unary(object.RuneLenString),
+	instruction.OP_STRING_SLICE:       ternary(object.SliceString),
+	instruction.OP_STRING_RUNE_SLICE:  ternary(object.RuneSliceString),
+	instruction.OP_STRING_INDEX:       binary(object.IndexString),
+	instruction.OP_STRING_RUNE_INDEX:  binary(object.RuneIndexString),
+	instruction.OP_STRING_CHAR_AT:     binary(object.CharAtString),
+	instruction.OP_STRING_RUNE_AT:     binary(object.RuneAtString),
+	instruction.OP_STRING_CONTAINS:    binary(object.ContainsString),
+	instruction.OP_STRING_HAS_PREFIX:  binary(object.HasPrefixString),
+	instruction.OP_STRING_HAS_SUFFIX:  binary(object.HasSuffixString),
+	instruction.OP_STRING_REPLACE:     ternary(object.ReplaceString),
+	instruction.OP_STRING_TRIM:        unary(object.TrimString),
+	instruction.OP_STRING_UPPER:       unary(object.UpperString),
+	instruction.OP_STRING_LOWER:       unary(object.LowerString),
+	instruction.OP_STRING_JOIN:        binary(object.JoinString),
+	instruction.OP_STRING_COMPARE:     binary(object.CompareString),
+	instruction.OP_STRUCT_NEW:         execConst,
+	instruction.OP_STRUCT_GET:         binary(object.GetStruct),
+	instruction.OP_STRUCT_SET:         ternary(object.SetStruct),
+	instruction.OP_MAP_NEW:            execConst,
+	instruction.OP_MAP_GET:            binary(object.GetMap),
+	instruction.OP_MAP_SET:            ternary(object.SetMap),
+	instruction.OP_MAP_DELETE:         binary(object.DeleteMap),
+	instruction.OP_MAP_HAS:            binary(object.HasMap),
+	instruction.OP_MAP_LEN:            unary(object.LenMap),
+	instruction.OP_MAP_KEYS:           unary(object.KeysMap),
+	instruction.OP_TO_STRING:          unary(object.AsString),
+	instruction.OP_TO_I32:             unary(object.AsI32),
+	instruction.OP_TO_F32:             unary(object.AsF32),
+	instruction.OP_TO_I64:             unary(object.AsI64),
+	instruction.OP_TO_F64:             unary(object.AsF64),
+	instruction.OP_TO_BOOL:            unary(object.AsBool),
+	instruction.OP_PRINT:              execPrint,
+	instruction.OP_PRINTF:             execPrintf,
+	instruction.OP_PRINTLN:            execPrintln,
+	instruction.OP_READ:               execRead,
+	instruction.OP_STRUCT_MAKE:        execStructMake,
+	instruction.OP_NATIVE_CALL:        execNativeCall,
+	instruction.OP_REF_NEW:            method((*CVM).refNew),
+	instruction.OP_REF_GET:            method((*CVM).refGet),
+	instruction.OP_REF_SET:            method((*CVM).refSet),
+	instruction.OP_REF_COPY:           method((*CVM).refCopy),
+	instruction.OP_REF_LENGTH:         method((*CVM).refLength),
+	instruction.OP_REF_AT:             method((*CVM).refAt),
+	instruction.OP_REF_APPEND:         method((*CVM).refAppend),
+	instruction.OP_REF_INSERT:         method((*CVM).refInsert),
+	instruction.OP_REF_REMOVE:         method((*CVM).refRemove),
+	instruction.OP_REF_REPLACE:        method((*CVM).refReplace),
+	instruction.OP_GC:                 method((*CVM).Collect),
+	instruction.OP_FUNC_LOAD:          execConst,
+	instruction.OP_FUNC_CALL_INDIRECT: execFuncCallIndirect,
+	instruction.OP_FUNC_CLOSURE:       execFuncClosure,
+	instruction.OP_CLOSURE_LOAD:       execClosureLoad,
+	instruction.OP_CLOSURE_SAVE:       execClosureSave,
+	instruction.OP_FUNC_TAILCALL:      execFuncTailCall,
+}
+
+func unary(fn unaryFunc) handler {
+	return func(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+		res, err := UnaryOperation(ctx, vm, fn)
+		if err != nil {
+			return ip, err
+		}
+		return ip + 1, vm.Push(ctx, res)
+	}
+}
+
+func binary(fn binFunc) handler {
+	return func(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+		res, err := BinaryOperation(ctx, vm, fn)
+		if err != nil {
+			return ip, err
+		}
+		return ip + 1, vm.Push(ctx, res)
+	}
+}
+
+// binaryI32 runs an i32 operation that can't fail on its decoded operands,
+// unlike binary with an object function the result shares its allocation through pushI32.
+func binaryI32(fn func(v1, v2 int32) int32) handler {
+	return func(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+		obj2, err := vm.Pop(ctx)
+		if err != nil {
+			return ip, err
+		}
+		obj1, err := vm.Pop(ctx)
+		if err != nil {
+			return ip, err
+		}
+		v1, err := object.ValueI32(obj1)
+		if err != nil {
+			return ip, err
+		}
+		v2, err := object.ValueI32(obj2)
+		if err != nil {
+			return ip, err
+		}
+		return ip + 1, vm.pushI32(ctx, fn(v1, v2))
+	}
+}
+
+func ternary(fn ternaryFunc) handler {
+	return func(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+		res, err := TernaryOperation(ctx, vm, fn)
+		if err != nil {
+			return ip, err
+		}
+		return ip + 1, vm.Push(ctx, res)
+	}
+}
+
+func nary(fn nFunc) handler {
+	return func(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+		res, err := NOperation(ctx, vm, fn)
+		if err != nil {
+			return ip, err
+		}
+		return ip + 1, vm.Push(ctx, res)
+	}
+}
+
+// method runs a vm method that takes its operands from the stack and pushes its own results.
+func method(fn func(vm *CVM, ctx context.Context) error) handler {
+	return func(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+		return ip + 1, fn(vm, ctx)
+	}
+}
+
+func execNull(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	return ip + 1, nil
+}
+
+func execHalt(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	return halted, nil
+}
+
+// execConst pushes the constant decoded by Link.
+func execConst(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	return ip + 1, vm.Push(ctx, o.obj)
+}
+
+func execJump(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	return uint32(o.arg), nil
+}
+
+// execJumpIf returns the handler of jumpc when cond is true and of jumpnc when it is false.
+func execJumpIf(cond bool) handler {
+	return func(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+		obj, err := vm.Pop(ctx)
+		if err != nil {
+			return ip, err
+		}
+		val, err := object.ValueBool(obj)
+		if err != nil {
+			return ip, err
+		}
+		if val != cond {
+			return ip + 1, nil
+		}
+		return uint32(o.arg), nil
+	}
+}
+
+func execBlockStart(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	return ip + 1, vm.PushFrame(ctx, Frame{
+		StackOffset: int(vm.SP),
+		HeapOffset:  int(vm.HP),
+		ReturnIP:    uint32(o.arg),
+		FrameOffset: -1,
+		Results:     -1,
+	})
+}
+
+func execBlockBr(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	fr, err := vm.LastFrame(ctx)
+	if err != nil {
+		return ip, err
+	}
+	return fr.ReturnIP, nil
+}
+
+func execBlockEnd(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	fr, err := vm.PopFrame(ctx)
+	if err != nil {
+		return ip, err
+	}
+	vm.dropSlots(fr.HeapOffset)
+	vm.SP = uint(fr.StackOffset)
+	return ip + 1, nil
+}
+
+func execBlockLoad(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	fr, err := vm.LastFrame(ctx)
+	if err != nil {
+		return ip, err
+	}
+	obj, err := vm.Load(ctx, uint32(int32(fr.HeapOffset)+o.arg))
+	if err != nil {
+		return ip, err
+	}
+	return ip + 1, vm.Push(ctx, obj)
+}
+
+func execBlockSave(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	obj, err := vm.Pop(ctx)
+	if err != nil {
+		return ip, err
+	}
+	fr, err := vm.LastFrame(ctx)
+	if err != nil {
+		return ip, err
+	}
+	return ip + 1, vm.Save(ctx, uint32(int32(fr.HeapOffset)+o.arg), obj)
+}
+
+func execLoad(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	obj, err := vm.Load(ctx, uint32(o.arg))
+	if err != nil {
+		return ip, err
+	}
+	return ip + 1, vm.Push(ctx, obj)
+}
+
+func execSave(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	obj, err := vm.Pop(ctx)
+	if err != nil {
+		return ip, err
+	}
+	return ip + 1, vm.Save(ctx, uint32(o.arg), obj)
+}
+
+func execFree(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	return ip + 1, vm.Free(ctx, uint32(o.arg))
+}
+
+func execNew(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	obj, err := vm.Pop(ctx)
+	if err != nil {
+		return ip, err
+	}
+	return ip + 1, vm.New(ctx, obj)
+}
+
+func execPop(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	_, err := vm.Pop(ctx)
+	return ip + 1, err
+}
+
+// checkArgs reports an instruction taking argc values the stack doesn't hold, argc comes from the bytecode.
+func (vm *CVM) checkArgs(argc int32) error {
+	if argc < 0 || uint(argc) > vm.SP {
+		return fmt.Errorf("%w: %d values requested, stack holds %d", ErrStackUnderflow, uint32(argc), vm.SP)
+	}
+	return nil
+}
+
+func execFuncCall(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	if err := vm.checkArgs(o.argc); err != nil {
+		return ip, err
+	}
+	err := vm.PushFrame(ctx, Frame{
+		StackOffset: int(vm.SP) - int(o.argc),
+		HeapOffset:  int(vm.HP),
+		ReturnIP:    ip + 1,
+		FrameOffset: int(vm.FP),
+		Results:     -1,
+		Entry:       uint32(o.arg),
+	})
+	if err != nil {
+		return ip, err
+	}
+	return uint32(o.arg), nil
+}
+
+// execFuncTailCall calls the function at arg from the innermost function frame and lets it take over that frame:
+// the argc arguments replace the values and locals of the frame, blocks opened in it are closed,
+// and the called function returns to the caller of the frame. Tail recursion so runs in constant frame space.
+// A closure calling its own entry point keeps its environment, any other function runs without one.
+func execFuncTailCall(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	ind, err := vm.lastFuncFrame()
+	if err != nil {
+		return ip, err
+	}
+	fr := &vm.StackFrame[ind]
+	argc := int(o.argc)
+	if argc < 0 || int(vm.SP)-argc < fr.StackOffset {
+		return ip, fmt.Errorf("%w: func.tailcall needs %d values", ErrStackUnderflow, uint32(o.argc))
+	}
+	copy(vm.Stack[fr.StackOffset:], vm.Stack[int(vm.SP)-argc:vm.SP])
+	vm.SP = uint(fr.StackOffset + argc)
+	vm.dropSlots(fr.HeapOffset)
+	vm.FP = uint(ind + 1)
+	if fr.Entry != uint32(o.arg) {
+		fr.Entry = uint32(o.arg)
+		fr.Env = 0
+	}
+	return uint32(o.arg), nil
+}
+
+// execFuncCallIndirect calls the function value on top of the stack,
+// it has to take argc values and return as many values as the operand says, kept in arg.
+func execFuncCallIndirect(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	callee, err := vm.Pop(ctx)
+	if err != nil {
+		return ip, err
+	}
+	if err := vm.checkArgs(o.argc); err != nil {
+		return ip, err
+	}
+	fn, err := object.ValueFunc(callee)
+	if err != nil {
+		return ip, err
+	}
+	if fn.Addr == object.FUNC_NIL {
+		return ip, ErrNilFunction
+	}
+	if fn.Params != uint32(o.argc) || fn.Results != uint32(o.arg) {
+		return ip, fmt.Errorf("%w: function @%d takes %d and returns %d values, called with %d expecting %d",
+			ErrArityMismatch, fn.Addr, fn.Params, fn.Results, o.argc, o.arg)
+	}
+	err = vm.PushFrame(ctx, Frame{
+		StackOffset: int(vm.SP) - int(o.argc),
+		HeapOffset:  int(vm.HP),
+		ReturnIP:    ip + 1,
+		FrameOffset: int(vm.FP),
+		Results:     int(o.arg),
+		Entry:       fn.Addr,
+		Env:         fn.Env,
+	})
+	if err != nil {
+		return ip, err
+	}
+	return fn.Addr, nil
+}
+
+// execFuncClosure pops argc captured values and the function value below them
+// and pushes a closure whose environment is a new struct holding the values.
+func execFuncClosure(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	if err := vm.checkArgs(o.argc); err != nil {
+		return ip, err
+	}
+	vals := make([]object.CVMObject, o.argc)
+	for n := len(vals) - 1; n >= 0; n-- {
+		val, err := vm.Pop(ctx)
+		if err != nil {
+			return ip, err
+		}
+		vals[n] = val
+	}
+	callee, err := vm.Pop(ctx)
+	if err != nil {
+		return ip, err
+	}
+	fn, err := object.ValueFunc(callee)
+	if err != nil {
+		return ip, err
+	}
+	if fn.Addr == object.FUNC_NIL {
+		return ip, ErrNilFunction
+	}
+	env, err := object.CreateStructOf(vals)
+	if err != nil {
+		return ip, err
+	}
+	ref, err := vm.Alloc(ctx, env)
+	if err != nil {
+		return ip, err
+	}
+	handle, err := object.ValueRef(ref)
+	if err != nil {
+		return ip, err
+	}
+	closure, err := object.CreateClosure(fn.Addr, fn.Params, fn.Results, handle)
+	if err != nil {
+		return ip, err
+	}
+	return ip + 1, vm.Push(ctx, closure)
+}
+
+// closureEnv returns the environment of the closure running in the innermost function frame.
+func (vm *CVM) closureEnv(ctx context.Context) (*object.CVMObject, error) {
+	fr, err := vm.LastFuncFrame(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if fr.Env == 0 {
+		return nil, ErrNoClosure
+	}
+	ref, err := object.CreateRef(fr.Env)
+	if err != nil {
+		return nil, err
+	}
+	return vm.Deref(ref)
+}
+
+func execClosureLoad(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	env, err := vm.closureEnv(ctx)
+	if err != nil {
+		return ip, err
+	}
+	val, err := object.GetStruct(*env, o.obj)
+	if err != nil {
+		return ip, err
+	}
+	return ip + 1, vm.Push(ctx, val)
+}
+
+// execClosureSave stores into the environment, so the value is seen by every later call of the closure.
+func execClosureSave(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	env, err := vm.closureEnv(ctx)
+	if err != nil {
+		return ip, err
+	}
+	val, err := vm.Pop(ctx)
+	if err != nil {
+		return ip, err
+	}
+	res, err := object.SetStruct(*env, o.obj, val)
+	if err != nil {
+		return ip, err
+	}
+	*env = res
+	return ip + 1, nil
+}
+
+func execFuncRet(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	fr, err := vm.LastFuncFrame(ctx)
+	if err != nil {
+		return ip, err
+	}
+	retLenVal := o.arg
+	if fr.Results >= 0 && int(retLenVal) != fr.Results {
+		return ip, fmt.Errorf("%w: func.ret returns %d values, caller expects %d", ErrArityMismatch, retLenVal, fr.Results)
+	}
+	if int(vm.SP)-int(retLenVal) < fr.StackOffset {
+		return ip, fmt.Errorf("%w: func.ret needs %d values", ErrStackUnderflow, retLenVal)
+	}
+	objs := vm.Stack[int(vm.SP)-int(retLenVal) : vm.SP]
+	vm.dropSlots(fr.HeapOffset)
+	vm.SP = uint(fr.StackOffset)
+	vm.FP = uint(fr.FrameOffset)
+	for _, obj := range objs {
+		if err := vm.Push(ctx, obj); err != nil {
+			return ip, err
+		}
+	}
+	return fr.ReturnIP, nil
+}
+
+func execLocalLoad(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	fr, err := vm.LastFuncFrame(ctx)
+	if err != nil {
+		return ip, err
+	}
+	obj, err := vm.Load(ctx, uint32(int32(fr.HeapOffset)+o.arg))
+	if err != nil {
+		return ip, err
+	}
+	return ip + 1, vm.Push(ctx, obj)
+}
+
+func execLocalSave(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	obj, err := vm.Pop(ctx)
+	if err != nil {
+		return ip, err
+	}
+	fr, err := vm.LastFuncFrame(ctx)
+	if err != nil {
+		return ip, err
+	}
+	return ip + 1, vm.Save(ctx, uint32(int32(fr.HeapOffset)+o.arg), obj)
+}
+
+// execListPop pushes the shortened list and then the removed item.
+func execListPop(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	list, err := vm.Pop(ctx)
+	if err != nil {
+		return ip, err
+	}
+	list, item, err := object.PopList(list)
+	if err != nil {
+		return ip, err
+	}
+	if err := vm.Push(ctx, list); err != nil {
+		return ip, err
+	}
+	return ip + 1, vm.Push(ctx, item)
+}
+
+func execPrint(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	_, err := UnaryOperation(ctx, vm, func(obj object.CVMObject) (object.CVMObject, error) {
+		return object.Print(vm.output(), obj)
+	})
+	return ip + 1, err
+}
+
+func execPrintf(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	_, err := NOperation(ctx, vm, func(f object.CVMObject, objs []object.CVMObject) (object.CVMObject, error) {
+		return object.Printf(vm.output(), f, objs)
+	})
+	return ip + 1, err
+}
+
+func execPrintln(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	_, err := UnaryOperation(ctx, vm, func(obj object.CVMObject) (object.CVMObject, error) {
+		return object.Println(vm.output(), obj)
+	})
+	return ip + 1, err
+}
+
+func execRead(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	res, err := object.Read(vm.reader())
+	if err != nil {
+		return ip, err
+	}
+	return ip + 1, vm.Push(ctx, res)
+}
+
+func execStructMake(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	if o.obj.Tag != object.TAG_STRUCT {
+		return ip, fmt.Errorf("%w: %d", ErrUnknownType, o.arg)
+	}
+	return ip + 1, vm.Push(ctx, o.obj)
+}
+
+func execNativeCall(ctx context.Context, vm *CVM, ip uint32, o *op) (uint32, error) {
+	return ip + 1, vm.CallNative(ctx, uint32(o.arg))
+}
@@ -0,0 +1,235 @@
+package cvm
+
+import (
+	"context"
+	"cvm/object"
+	"fmt"
+)
+
+// Objects on the object heap are owned by the heap: ref.new and ref.set store a copy of the value,
+// ref.get and ref.at hand out copies, so the in place operations never change data another object shares.
+
+// Alloc stores a copy of obj on the object heap and returns a reference to it.
+// It runs a collection first when enough objects were allocated since the last one or the heap is full,
+// obj counts as a root so the objects it refers to survive.
+func (vm *CVM) Alloc(ctx context.Context, obj object.CVMObject) (object.CVMObject, error) {
+	if len(vm.Objects) == 0 {
+		vm.Objects = make([]object.CVMObject, 1, 16)
+	}
+	lim := limit(vm.ObjectLimit, OBJECT_HEAP_SIZE)
+	live := uint(len(vm.Objects) - 1 - len(vm.free))
+	full := len(vm.free) == 0 && uint(len(vm.Objects)) > lim
+	if live >= limit(vm.nextGC, limit(vm.GCThreshold, GC_THRESHOLD)) || full {
+		if err := vm.collect(obj); err != nil {
+			return object.CVMObject{}, err
+		}
+	}
+	var handle uint
+	if n := len(vm.free); n > 0 {
+		handle = uint(vm.free[n-1])
+		vm.free = vm.free[:n-1]
+	} else {
+		handle = uint(len(vm.Objects))
+		objects, ok := grow(vm.Objects, handle, lim+1)
+		if !ok {
+			return object.CVMObject{}, fmt.Errorf("%w: object heap is full", ErrHeapOverflow)
+		}
+		vm.Objects = objects
+	}
+	vm.Objects[handle] = object.Clone(obj)
+	return object.CreateRef(uint32(handle))
+}
+
+// Deref returns the heap object ref points to, mutating it changes the object for every reference.
+func (vm *CVM) Deref(ref object.CVMObject) (*object.CVMObject, error) {
+	handle, err := object.ValueRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	if handle == 0 {
+		return nil, fmt.Errorf("%w: nil reference", ErrInvalidSlot)
+	}
+	// freed objects are the only ones without data, Clone gives every stored object some
+	if handle >= uint32(len(vm.Objects)) || vm.Objects[handle].Data == nil {
+		return nil, fmt.Errorf("%w: reference &%d not found", ErrInvalidSlot, handle)
+	}
+	return &vm.Objects[handle], nil
+}
+
+// popRef pops a reference and returns the object it points to.
+func (vm *CVM) popRef(ctx context.Context) (*object.CVMObject, error) {
+	ref, err := vm.Pop(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return vm.Deref(ref)
+}
+
+// popArgs pops n values and then the reference below them, args are returned in push order.
+func (vm *CVM) popArgs(ctx context.Context, n int) (*object.CVMObject, []object.CVMObject, error) {
+	args := make([]object.CVMObject, n)
+	for i := n - 1; i >= 0; i-- {
+		obj, err := vm.Pop(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		args[i] = obj
+	}
+	target, err := vm.popRef(ctx)
+	return target, args, err
+}
+
+func (vm *CVM) refNew(ctx context.Context) error {
+	obj, err := vm.Pop(ctx)
+	if err != nil {
+		return err
+	}
+	ref, err := vm.Alloc(ctx, obj)
+	if err != nil {
+		return err
+	}
+	return vm.Push(ctx, ref)
+}
+
+func (vm *CVM) refGet(ctx context.Context) error {
+	target, err := vm.popRef(ctx)
+	if err != nil {
+		return err
+	}
+	return vm.Push(ctx, object.Clone(*target))
+}
+
+func (vm *CVM) refSet(ctx context.Context) error {
+	target, args, err := vm.popArgs(ctx, 1)
+	if err != nil {
+		return err
+	}
+	if target.Tag != args[0].Tag {
+		return fmt.Errorf("%w: unexpected tag %s, want %s", object.ErrTypeMismatch, object.TagsName(args[0].Tag), object.TagsName(target.Tag))
+	}
+	*target = object.Clone(args[0])
+	return nil
+}
+
+func (vm *CVM) refCopy(ctx context.Context) error {
+	target, err := vm.popRef(ctx)
+	if err != nil {
+		return err
+	}
+	ref, err := vm.Alloc(ctx, *target)
+	if err != nil {
+		return err
+	}
+	return vm.Push(ctx, ref)
+}
+
+func (vm *CVM) refLength(ctx context.Context) error {
+	target, err := vm.popRef(ctx)
+	if err != nil {
+		return err
+	}
+	ln, err := object.Len(*target)
+	if err != nil {
+		return err
+	}
+	res, err := object.CreateI32(int32(ln))
+	if err != nil {
+		return err
+	}
+	return vm.Push(ctx, res)
+}
+
+// refAt reads list item, struct field or map value key of the referenced object.
+func (vm *CVM) refAt(ctx context.Context) error {
+	target, args, err := vm.popArgs(ctx, 1)
+	if err != nil {
+		return err
+	}
+	var res object.CVMObject
+	switch target.Tag {
+	case object.TAG_LIST:
+		res, err = object.GetList(*target, args[0])
+	case object.TAG_STRUCT:
+		res, err = object.GetStruct(*target, args[0])
+	case object.TAG_MAP:
+		res, err = object.GetMap(*target, args[0])
+	default:
+		err = fmt.Errorf("%w: can't index %s", object.ErrTypeMismatch, object.TagsName(target.Tag))
+	}
+	if err != nil {
+		return err
+	}
+	return vm.Push(ctx, object.Clone(res))
+}
+
+// refAppend adds an item to a referenced list or the bytes of a string to a referenced string.
+func (vm *CVM) refAppend(ctx context.Context) error {
+	target, args, err := vm.popArgs(ctx, 1)
+	if err != nil {
+		return err
+	}
+	switch target.Tag {
+	case object.TAG_LIST:
+		return object.AppendListInPlace(target, args[0])
+	case object.TAG_STRING:
+		return object.AppendStringInPlace(target, args[0])
+	default:
+		return fmt.Errorf("%w: can't append to %s", object.ErrTypeMismatch, object.TagsName(target.Tag))
+	}
+}
+
+func (vm *CVM) refInsert(ctx context.Context) error {
+	target, args, err := vm.popArgs(ctx, 2)
+	if err != nil {
+		return err
+	}
+	if target.Tag != object.TAG_LIST {
+		return fmt.Errorf("%w: can't insert into %s", object.ErrTypeMismatch, object.TagsName(target.Tag))
+	}
+	return object.InsertListInPlace(target, args[0], args[1])
+}
+
+// refRemove removes a list item or a map key of the referenced object.
+func (vm *CVM) refRemove(ctx context.Context) error {
+	target, args, err := vm.popArgs(ctx, 1)
+	if err != nil {
+		return err
+	}
+	switch target.Tag {
+	case object.TAG_LIST:
+		return object.RemoveListInPlace(target, args[0])
+	case object.TAG_MAP:
+		res, err := object.DeleteMap(*target, args[0])
+		if err != nil {
+			return err
+		}
+		*target = res
+		return nil
+	default:
+		return fmt.Errorf("%w: can't remove from %s", object.ErrTypeMismatch, object.TagsName(target.Tag))
+	}
+}
+
+// refReplace sets a list item, struct field or map value of the referenced object.
+func (vm *CVM) refReplace(ctx context.Context) error {
+	target, args, err := vm.popArgs(ctx, 2)
+	if err != nil {
+		return err
+	}
+	var res object.CVMObject
+	switch target.Tag {
+	case object.TAG_LIST:
+		return object.ReplaceListInPlace(target, args[0], args[1])
+	case object.TAG_STRUCT:
+		res, err = object.SetStruct(*target, args[0], args[1])
+	case object.TAG_MAP:
+		res, err = object.SetMap(*target, args[0], args[1])
+	default:
+		err = fmt.Errorf("%w: can't replace in %s", object.ErrTypeMismatch, object.TagsName(target.Tag))
+	}
+	if err != nil {
+		return err
+	}
+	*target = res
+	return nil
+}
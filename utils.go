@@ -3,6 +3,7 @@ package cvm
 import (
 	"context"
 	"cvm/object"
+	"fmt"
 )
 
 type binFunc func(obj1, obj2 object.CVMObject) (object.CVMObject, error)
@@ -13,15 +14,15 @@ type nFunc func(obj1 object.CVMObject, objs []object.CVMObject) (object.CVMObjec
 func TernaryOperation(ctx context.Context, vm *CVM, terOperation ternaryFunc) (object.CVMObject, error) {
 	obj3, err := vm.Pop(ctx)
 	if err != nil {
-		return object.CVMObject{}, nil
+		return object.CVMObject{}, err
 	}
 	obj2, err := vm.Pop(ctx)
 	if err != nil {
-		return object.CVMObject{}, nil
+		return object.CVMObject{}, err
 	}
 	obj1, err := vm.Pop(ctx)
 	if err != nil {
-		return object.CVMObject{}, nil
+		return object.CVMObject{}, err
 	}
 	return terOperation(obj1, obj2, obj3)
 }
@@ -29,11 +30,11 @@ func TernaryOperation(ctx context.Context, vm *CVM, terOperation ternaryFunc) (o
 func BinaryOperation(ctx context.Context, vm *CVM, binOperation binFunc) (object.CVMObject, error) {
 	obj2, err := vm.Pop(ctx)
 	if err != nil {
-		return object.CVMObject{}, nil
+		return object.CVMObject{}, err
 	}
 	obj1, err := vm.Pop(ctx)
 	if err != nil {
-		return object.CVMObject{}, nil
+		return object.CVMObject{}, err
 	}
 	return binOperation(obj1, obj2)
 }
@@ -41,7 +42,7 @@ func BinaryOperation(ctx context.Context, vm *CVM, binOperation binFunc) (object
 func UnaryOperation(ctx context.Context, vm *CVM, unaryOperation unaryFunc) (object.CVMObject, error) {
 	obj, err := vm.Pop(ctx)
 	if err != nil {
-		return object.CVMObject{}, nil
+		return object.CVMObject{}, err
 	}
 	return unaryOperation(obj)
 }
@@ -55,6 +56,9 @@ func NOperation(ctx context.Context, vm *CVM, nOperation nFunc) (object.CVMObjec
 	if err != nil {
 		return object.CVMObject{}, err
 	}
+	if nV < 0 || uint(nV) >= vm.SP {
+		return object.CVMObject{}, fmt.Errorf("%w: %d arguments requested, stack holds %d values", ErrStackUnderflow, nV, vm.SP)
+	}
 	objs := make([]object.CVMObject, 0, nV)
 	for i := 0; i < int(nV); i++ {
 		obj, err := vm.Pop(ctx)
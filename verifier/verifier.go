@@ -0,0 +1,491 @@
+package verifier
+
+import (
+	"cvm/instruction"
+	"cvm/object"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const ANY = instruction.ANY
+
+// maxResults bounds the result count of func.call_indirect, which nothing on the stack limits,
+// it is far beyond what a vm stack holds.
+const maxResults = 1 << 16
+
+// conversions lists the tags each to_* instruction converts from, as accepted by the object.As* functions.
+var conversions = map[byte][]byte{
+	instruction.OP_TO_STRING: {
+		object.TAG_STRING, object.TAG_I32, object.TAG_F32, object.TAG_I64, object.TAG_F64, object.TAG_BOOL,
+		object.TAG_LIST, object.TAG_MAP, object.TAG_STRUCT, object.TAG_REF, object.TAG_FUNC,
+	},
+	instruction.OP_TO_I32:  {object.TAG_I32, object.TAG_I64, object.TAG_F32, object.TAG_F64},
+	instruction.OP_TO_F32:  {object.TAG_F32, object.TAG_I32, object.TAG_I64, object.TAG_F64},
+	instruction.OP_TO_I64:  {object.TAG_I64, object.TAG_I32, object.TAG_F32, object.TAG_F64},
+	instruction.OP_TO_F64:  {object.TAG_F64, object.TAG_I32, object.TAG_I64, object.TAG_F32},
+	instruction.OP_TO_BOOL: {object.TAG_BOOL, object.TAG_I32, object.TAG_F32, object.TAG_I64, object.TAG_F64, object.TAG_LIST},
+}
+
+type Diagnostic struct {
+	IP  int
+	Msg string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%04d: %s", d.IP, d.Msg)
+}
+
+type Diagnostics []Diagnostic
+
+func (ds Diagnostics) Error() string {
+	lines := make([]string, 0, len(ds))
+	for _, d := range ds {
+		lines = append(lines, d.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Signature describes a host function reachable with native.call, indexed like CVM.Natives.
+type Signature struct {
+	Params  []byte
+	Results []byte
+}
+
+type value struct {
+	tag   byte
+	known bool
+	val   int32
+}
+
+type block struct {
+	ret   uint32
+	depth int
+}
+
+type state struct {
+	fn     uint32
+	stack  []value
+	blocks []block
+}
+
+func (s *state) clone() *state {
+	return &state{
+		fn:     s.fn,
+		stack:  append([]value(nil), s.stack...),
+		blocks: append([]block(nil), s.blocks...),
+	}
+}
+
+type verifier struct {
+	instrs  []instruction.Instruction
+	states  map[uint32]*state
+	work    []uint32
+	diags   map[Diagnostic]bool
+	entries map[uint32]int
+	rets    map[uint32]int
+	waiting map[uint32][]uint32
+	natives []Signature
+}
+
+// Verify abstractly interprets the program, tracking stack depth, object tags and open blocks
+// along every path from the entry point and every function called with func.call or func.tailcall
+// or loaded with func.load.
+// It returns nil or Diagnostics sorted by instruction index.
+func Verify(instrs []instruction.Instruction, natives ...Signature) error {
+	v := &verifier{
+		natives: natives,
+		instrs:  instrs,
+		states:  map[uint32]*state{},
+		diags:   map[Diagnostic]bool{},
+		entries: map[uint32]int{},
+		rets:    map[uint32]int{},
+		waiting: map[uint32][]uint32{},
+	}
+	v.checkInstructions()
+	if len(instrs) > 0 {
+		v.flow(0, &state{})
+	}
+	for len(v.work) > 0 {
+		ip := v.work[len(v.work)-1]
+		v.work = v.work[:len(v.work)-1]
+		v.step(ip, v.states[ip].clone())
+	}
+	if len(v.diags) == 0 {
+		return nil
+	}
+	res := make(Diagnostics, 0, len(v.diags))
+	for d := range v.diags {
+		res = append(res, d)
+	}
+	sort.Slice(res, func(a, b int) bool {
+		if res[a].IP != res[b].IP {
+			return res[a].IP < res[b].IP
+		}
+		return res[a].Msg < res[b].Msg
+	})
+	return res
+}
+
+func (v *verifier) report(ip uint32, format string, args ...any) {
+	v.diags[Diagnostic{IP: int(ip), Msg: fmt.Sprintf(format, args...)}] = true
+}
+
+// checkInstructions validates operands and targets of every instruction, reachable or not.
+func (v *verifier) checkInstructions() {
+	for ip := range v.instrs {
+		instr := &v.instrs[ip]
+		if _, err := instr.Format(nil); err != nil {
+			v.report(uint32(ip), "%v", err)
+			continue
+		}
+		addr, ok := instr.Target()
+		if !ok {
+			continue
+		}
+		end := uint32(len(v.instrs))
+		if instr.Kind == instruction.OP_FUNC_CALL || instr.Kind == instruction.OP_FUNC_LOAD || instr.Kind == instruction.OP_FUNC_TAILCALL ||
+			instr.Kind == instruction.OP_BLOCK_START {
+			end--
+		}
+		if addr > end || len(v.instrs) == 0 {
+			v.report(uint32(ip), "%s target %d out of range", instruction.Mnemonic(instr.Kind), addr)
+		}
+	}
+}
+
+// flow merges s into the state recorded for ip and schedules ip when the recorded state changed.
+func (v *verifier) flow(ip uint32, s *state) {
+	if ip >= uint32(len(v.instrs)) {
+		return
+	}
+	old, ok := v.states[ip]
+	if !ok {
+		v.states[ip] = s
+		v.work = append(v.work, ip)
+		return
+	}
+	// a function is only entered by a call, never by falling or jumping into it
+	if old.fn != s.fn {
+		v.report(ip, "control flow of function %d joins function %d", s.fn, old.fn)
+		return
+	}
+	if len(old.stack) != len(s.stack) {
+		v.report(ip, "stack depth mismatch at join: %d and %d", len(old.stack), len(s.stack))
+		return
+	}
+	if len(old.blocks) != len(s.blocks) {
+		v.report(ip, "block nesting mismatch at join: %d and %d", len(old.blocks), len(s.blocks))
+		return
+	}
+	changed := false
+	for ind := range old.stack {
+		if old.stack[ind] == s.stack[ind] || old.stack[ind].tag == ANY && !old.stack[ind].known {
+			continue
+		}
+		tag := old.stack[ind].tag
+		if tag != s.stack[ind].tag {
+			tag = ANY
+		}
+		old.stack[ind] = value{tag: tag}
+		changed = true
+	}
+	if changed {
+		v.work = append(v.work, ip)
+	}
+}
+
+func (v *verifier) pop(ip uint32, s *state, tags ...byte) ([]value, bool) {
+	instr := &v.instrs[ip]
+	if len(s.stack) < len(tags) {
+		v.report(ip, "stack underflow: %s needs %d values, have %d", instruction.Mnemonic(instr.Kind), len(tags), len(s.stack))
+		return nil, false
+	}
+	vals := s.stack[len(s.stack)-len(tags):]
+	s.stack = s.stack[:len(s.stack)-len(tags)]
+	ok := true
+	for ind, tag := range tags {
+		if tag != ANY && vals[ind].tag != ANY && vals[ind].tag != tag {
+			v.report(ip, "type mismatch: %s expects %s, got %s", instruction.Mnemonic(instr.Kind), object.TagsName(tag), object.TagsName(vals[ind].tag))
+			ok = false
+		}
+	}
+	return vals, ok
+}
+
+func (v *verifier) step(ip uint32, s *state) {
+	instr := &v.instrs[ip]
+	if _, err := instr.Format(nil); err != nil {
+		return
+	}
+	next := ip + 1
+	switch instr.Kind {
+	case instruction.OP_HALT:
+		return
+	case instruction.OP_STRING_FORMAT, instruction.OP_PRINTF:
+		vals, ok := v.pop(ip, s, object.TAG_I32)
+		if !ok {
+			return
+		}
+		if !vals[0].known {
+			v.report(ip, "%s argument count is not a constant", instruction.Mnemonic(instr.Kind))
+			return
+		}
+		// the format string sits below the arguments
+		if vals[0].val < 0 || int(vals[0].val) >= len(s.stack) {
+			v.report(ip, "%s argument count %d out of range, stack holds %d values", instruction.Mnemonic(instr.Kind), vals[0].val, len(s.stack))
+			return
+		}
+		tags := make([]byte, vals[0].val+1)
+		tags[0] = object.TAG_STRING
+		if _, ok := v.pop(ip, s, tags...); !ok {
+			return
+		}
+		if instr.Kind == instruction.OP_STRING_FORMAT {
+			s.stack = append(s.stack, value{tag: object.TAG_STRING})
+		}
+		v.flow(next, s)
+	case instruction.OP_JUMP:
+		addr, _ := instr.Target()
+		v.flow(addr, s)
+	case instruction.OP_JUMPC, instruction.OP_JUMPNC:
+		if _, ok := v.pop(ip, s, object.TAG_BOOL); !ok {
+			return
+		}
+		addr, _ := instr.Target()
+		v.flow(addr, s.clone())
+		v.flow(next, s)
+	case instruction.OP_BLOCK_START:
+		addr, _ := instr.Target()
+		s.blocks = append(s.blocks, block{ret: addr, depth: len(s.stack)})
+		v.flow(next, s)
+	case instruction.OP_BLOCK_BR:
+		if len(s.blocks) == 0 {
+			v.report(ip, "block.br outside of block")
+			return
+		}
+		v.flow(s.blocks[len(s.blocks)-1].ret, s)
+	case instruction.OP_BLOCK_END:
+		if len(s.blocks) == 0 {
+			v.report(ip, "block.end outside of block")
+			return
+		}
+		b := s.blocks[len(s.blocks)-1]
+		s.blocks = s.blocks[:len(s.blocks)-1]
+		if len(s.stack) < b.depth {
+			v.report(ip, "block consumed %d values pushed before it", b.depth-len(s.stack))
+			return
+		}
+		s.stack = s.stack[:b.depth]
+		v.flow(next, s)
+	case instruction.OP_BLOCK_LOAD, instruction.OP_BLOCK_SAVE:
+		if len(s.blocks) == 0 {
+			v.report(ip, "%s outside of block", instruction.Mnemonic(instr.Kind))
+			return
+		}
+		v.apply(ip, s)
+	case instruction.OP_FUNC_CALL:
+		addr, _ := instr.Target()
+		argsLen, _ := instr.OperandI32(1)
+		argc, ok := v.count(ip, s, argsLen)
+		if !ok {
+			return
+		}
+		if _, ok := v.pop(ip, s, make([]byte, argc)...); !ok {
+			return
+		}
+		if !v.enter(ip, addr, argc) {
+			return
+		}
+		ret, ok := v.rets[addr]
+		if !ok {
+			v.waiting[addr] = append(v.waiting[addr], ip)
+			return
+		}
+		s.stack = append(s.stack, make([]value, ret)...)
+		v.flow(next, s)
+	case instruction.OP_FUNC_LOAD:
+		addr, _ := instr.Target()
+		params, _ := instr.OperandI32(1)
+		results, _ := instr.OperandI32(2)
+		if !v.enter(ip, addr, int(params)) {
+			return
+		}
+		// the returned values are checked once a func.ret of the function was seen
+		if ret, ok := v.rets[addr]; !ok {
+			v.waiting[addr] = append(v.waiting[addr], ip)
+		} else if ret != int(results) {
+			v.report(ip, "func.load declares %d results, function %d returns %d", results, addr, ret)
+		}
+		s.stack = append(s.stack, value{tag: object.TAG_FUNC})
+		v.flow(next, s)
+	case instruction.OP_FUNC_CALL_INDIRECT:
+		params, _ := instr.OperandI32(0)
+		results, _ := instr.OperandI32(1)
+		if _, ok := v.count(ip, s, params); !ok {
+			return
+		}
+		tags := make([]byte, params+1)
+		tags[params] = object.TAG_FUNC
+		if _, ok := v.pop(ip, s, tags...); !ok {
+			return
+		}
+		if results > maxResults {
+			v.report(ip, "func.call_indirect expects %d results, at most %d are supported", results, maxResults)
+			return
+		}
+		s.stack = append(s.stack, make([]value, results)...)
+		v.flow(next, s)
+	case instruction.OP_FUNC_CLOSURE:
+		n, _ := instr.OperandI32(0)
+		if _, ok := v.count(ip, s, n); !ok {
+			return
+		}
+		tags := make([]byte, n+1)
+		tags[0] = object.TAG_FUNC
+		if _, ok := v.pop(ip, s, tags...); !ok {
+			return
+		}
+		s.stack = append(s.stack, value{tag: object.TAG_FUNC})
+		v.flow(next, s)
+	case instruction.OP_CLOSURE_LOAD, instruction.OP_CLOSURE_SAVE:
+		// the captured values are only known at run time, so is whether the function runs as a closure
+		if _, ok := v.entries[s.fn]; !ok {
+			v.report(ip, "%s outside of function", instruction.Mnemonic(instr.Kind))
+			return
+		}
+		v.apply(ip, s)
+	case instruction.OP_NATIVE_CALL:
+		ind, _ := instr.OperandI32(0)
+		if ind >= uint32(len(v.natives)) {
+			v.report(ip, "unknown native %d", ind)
+			return
+		}
+		sig := v.natives[ind]
+		if _, ok := v.pop(ip, s, sig.Params...); !ok {
+			return
+		}
+		for _, tag := range sig.Results {
+			s.stack = append(s.stack, value{tag: tag})
+		}
+		v.flow(next, s)
+	case instruction.OP_FUNC_RET:
+		if _, ok := v.entries[s.fn]; !ok {
+			v.report(ip, "func.ret outside of function")
+			return
+		}
+		retLen, _ := instr.OperandI32(0)
+		ret := int(retLen)
+		if len(s.stack) < ret {
+			v.report(ip, "func.ret returns %d values, have %d", ret, len(s.stack))
+			return
+		}
+		v.returns(ip, s.fn, ret)
+	case instruction.OP_FUNC_TAILCALL:
+		if _, ok := v.entries[s.fn]; !ok {
+			v.report(ip, "func.tailcall outside of function")
+			return
+		}
+		addr, _ := instr.Target()
+		argsLen, _ := instr.OperandI32(1)
+		argc, ok := v.count(ip, s, argsLen)
+		if !ok {
+			return
+		}
+		if _, ok := v.pop(ip, s, make([]byte, argc)...); !ok {
+			return
+		}
+		if !v.enter(ip, addr, argc) {
+			return
+		}
+		// the function returns what the called one returns
+		ret, ok := v.rets[addr]
+		if !ok {
+			v.waiting[addr] = append(v.waiting[addr], ip)
+			return
+		}
+		v.returns(ip, s.fn, ret)
+	case instruction.OP_TO_STRING, instruction.OP_TO_I32, instruction.OP_TO_F32,
+		instruction.OP_TO_I64, instruction.OP_TO_F64, instruction.OP_TO_BOOL:
+		if n := len(s.stack); n > 0 {
+			tag := s.stack[n-1].tag
+			if tag != ANY && !slices.Contains(conversions[instr.Kind], tag) {
+				v.report(ip, "type mismatch: %s can't convert %s", instruction.Mnemonic(instr.Kind), object.TagsName(tag))
+				return
+			}
+		}
+		v.apply(ip, s)
+	default:
+		v.apply(ip, s)
+	}
+}
+
+// count checks that the stack holds the n values an instruction takes by its operand,
+// before tags are allocated for them.
+func (v *verifier) count(ip uint32, s *state, n uint32) (int, bool) {
+	if uint64(n) > uint64(len(s.stack)) {
+		v.report(ip, "stack underflow: %s needs %d values, have %d", instruction.Mnemonic(v.instrs[ip].Kind), n, len(s.stack))
+		return 0, false
+	}
+	return int(n), true
+}
+
+// returns records that the function fn returns ret values at ip and resumes the calls waiting for it.
+func (v *verifier) returns(ip, fn uint32, ret int) {
+	prev, ok := v.rets[fn]
+	if ok && prev != ret {
+		v.report(ip, "%s returns %d values, function %d returns %d elsewhere", instruction.Mnemonic(v.instrs[ip].Kind), ret, fn, prev)
+		return
+	}
+	if ok {
+		return
+	}
+	v.rets[fn] = ret
+	for _, call := range v.waiting[fn] {
+		v.work = append(v.work, call)
+	}
+	delete(v.waiting, fn)
+}
+
+// enter starts the analysis of the function at addr taking argc values, referenced by the call or func.load at ip.
+// It reports false when addr is outside of the program.
+func (v *verifier) enter(ip, addr uint32, argc int) bool {
+	if addr >= uint32(len(v.instrs)) {
+		return false
+	}
+	if prev, ok := v.entries[addr]; ok && prev != argc {
+		v.report(ip, "function %d called with %d arguments, elsewhere with %d", addr, argc, prev)
+	} else if !ok {
+		v.entries[addr] = argc
+		entry := &state{fn: addr, stack: make([]value, argc)}
+		v.flow(addr, entry)
+	}
+	return true
+}
+
+// apply executes a fixed arity instruction on s and continues with the next instruction,
+// its stack effect comes from the opcode table.
+func (v *verifier) apply(ip uint32, s *state) {
+	instr := &v.instrs[ip]
+	op, ok := instruction.Info(instr.Kind)
+	if !ok || op.Variadic {
+		v.report(ip, "%s is not supported by the vm", instruction.Mnemonic(instr.Kind))
+		return
+	}
+	if _, ok := v.pop(ip, s, op.Effect.Pop...); !ok {
+		return
+	}
+	for _, tag := range op.Effect.Push {
+		val := value{tag: tag}
+		if instr.Kind == instruction.OP_I32_LOAD {
+			obj, err := object.CreateObject(instr.Operands)
+			if err == nil {
+				val.val, _ = object.ValueI32(obj)
+				val.known = true
+			}
+		}
+		s.stack = append(s.stack, val)
+	}
+	v.flow(ip+1, s)
+}
@@ -0,0 +1,363 @@
+package verifier
+
+import (
+	i "cvm/instruction"
+	"cvm/object"
+	"errors"
+	"testing"
+)
+
+func fib() []i.Instruction {
+	return []i.Instruction{
+		i.I32Load(10),
+		i.FuncCall(3, 1),
+		i.Halt(),
+		i.New(),
+		i.LocalLoad(0),
+		i.I32Load(2),
+		i.I32Lt(),
+		i.JumpC(18),
+		i.LocalLoad(0),
+		i.I32Load(1),
+		i.I32Sub(),
+		i.FuncCall(3, 1),
+		i.LocalLoad(0),
+		i.I32Load(2),
+		i.I32Sub(),
+		i.FuncCall(3, 1),
+		i.I32Add(),
+		i.FuncRet(1),
+		i.LocalLoad(0),
+		i.FuncRet(1),
+	}
+}
+
+func TestVerifyValid(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		instrs []i.Instruction
+	}{
+		{desc: "test empty", instrs: nil},
+		{desc: "test fib", instrs: fib()},
+		{
+			desc: "test block",
+			instrs: []i.Instruction{
+				i.I32Load(1),
+				i.BlockStart(5),
+				i.I32Load(2),
+				i.New(),
+				i.BlockBr(),
+				i.BlockEnd(),
+				i.I32Load(1),
+				i.I32Add(),
+			},
+		},
+		{
+			desc: "test format",
+			instrs: []i.Instruction{
+				i.StringLoad("%. + %."),
+				i.I32Load(1),
+				i.F32Load(2),
+				i.I32Load(2),
+				i.StringFormat(),
+				i.Println(),
+			},
+		},
+		{
+			desc: "test references",
+			instrs: []i.Instruction{
+				i.ListNew(object.TAG_I32),
+				i.RefNew(),
+				i.New(),
+				i.Load(0),
+				i.I32Load(1),
+				i.RefAppend(),
+				i.Load(0),
+				i.RefLength(),
+				i.Println(),
+			},
+		},
+		{
+			desc: "test loop",
+			instrs: []i.Instruction{
+				i.I32Load(0),
+				i.I32Load(1),
+				i.I32Add(),
+				i.New(),
+				i.Load(0),
+				i.I32Load(10),
+				i.I32Lt(),
+				i.JumpNC(9),
+				i.Jump(0),
+			},
+		},
+		{
+			desc: "test indirect call",
+			instrs: []i.Instruction{
+				i.I32Load(2),
+				i.I32Load(3),
+				i.FuncLoad(6, 2, 1),
+				i.FuncCallIndirect(2, 1),
+				i.Println(),
+				i.Halt(),
+				i.I32Add(),
+				i.FuncRet(1),
+			},
+		},
+		{
+			desc: "test closure",
+			instrs: []i.Instruction{
+				i.I32Load(2),
+				i.FuncLoad(6, 1, 1),
+				i.I32Load(3),
+				i.FuncClosure(1),
+				i.FuncCallIndirect(1, 1),
+				i.Halt(),
+				i.ClosureLoad(0),
+				i.I32Add(),
+				i.FuncRet(1),
+			},
+		},
+		{
+			desc: "test tail call",
+			instrs: []i.Instruction{
+				i.I32Load(10),
+				i.FuncCall(3, 1),
+				i.Halt(),
+				i.New(),
+				i.LocalLoad(0),
+				i.I32Load(1),
+				i.I32Lt(),
+				i.JumpC(13),
+				i.LocalLoad(0),
+				i.I32Load(1),
+				i.I32Sub(),
+				i.FuncTailCall(3, 1),
+				i.Halt(),
+				i.LocalLoad(0),
+				i.FuncRet(1),
+			},
+		},
+		{
+			desc: "test conversions",
+			instrs: []i.Instruction{
+				i.I32Load(1),
+				i.ToF64(),
+				i.ToBool(),
+				i.ToString(),
+				i.Println(),
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			if err := Verify(tC.instrs); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		instrs []i.Instruction
+		ips    []int
+	}{
+		{
+			desc:   "test type mismatch",
+			instrs: []i.Instruction{i.I32Load(1), i.F32Load(2), i.I32Add()},
+			ips:    []int{2},
+		},
+		{
+			desc:   "test stack underflow",
+			instrs: []i.Instruction{i.I32Load(1), i.I32Add(), i.Pop()},
+			ips:    []int{1},
+		},
+		{
+			desc:   "test reference expected",
+			instrs: []i.Instruction{i.ListNew(object.TAG_I32), i.I32Load(1), i.RefAppend()},
+			ips:    []int{2},
+		},
+		{
+			desc:   "test jump out of range",
+			instrs: []i.Instruction{i.Jump(7), i.FuncCall(3, 0), i.BlockStart(3)},
+			ips:    []int{0, 1, 2},
+		},
+		{
+			desc:   "test jump condition",
+			instrs: []i.Instruction{i.I32Load(1), i.JumpC(0)},
+			ips:    []int{1},
+		},
+		{
+			desc: "test depth mismatch",
+			instrs: []i.Instruction{
+				i.BoolLoad(true),
+				i.JumpC(3),
+				i.I32Load(1),
+				i.Halt(),
+			},
+			ips: []int{3},
+		},
+		{
+			desc: "test func.ret counts",
+			instrs: []i.Instruction{
+				i.BoolLoad(true),
+				i.FuncCall(3, 1),
+				i.FuncRet(1),
+				i.JumpC(5),
+				i.FuncRet(0),
+				i.I32Load(1),
+				i.FuncRet(2),
+			},
+			ips: []int{2, 6},
+		},
+		{
+			desc: "test indirect call",
+			instrs: []i.Instruction{
+				i.FuncLoad(4, 1, 2),
+				i.I32Load(1),
+				i.FuncCallIndirect(1, 1),
+				i.Halt(),
+				i.FuncRet(1),
+			},
+			ips: []int{0, 2},
+		},
+		{
+			desc: "test closure",
+			instrs: []i.Instruction{
+				i.I32Load(1),
+				i.I32Load(2),
+				i.FuncClosure(1),
+				i.ClosureLoad(0),
+			},
+			ips: []int{2},
+		},
+		{
+			desc: "test tail call",
+			instrs: []i.Instruction{
+				i.FuncCall(3, 0),
+				i.FuncTailCall(3, 0),
+				i.Halt(),
+				i.BoolLoad(true),
+				i.JumpC(7),
+				i.FuncTailCall(9, 0),
+				i.Halt(),
+				i.I32Load(1),
+				i.FuncRet(1),
+				i.FuncRet(0),
+			},
+			ips: []int{1, 8},
+		},
+		{
+			desc:   "test negative format argument count",
+			instrs: []i.Instruction{i.StringLoad("x"), i.I32Load(-1), i.StringFormat()},
+			ips:    []int{2},
+		},
+		{
+			desc:   "test negative printf argument count",
+			instrs: []i.Instruction{i.StringLoad("x"), i.I32Load(-5), i.Printf()},
+			ips:    []int{2},
+		},
+		{
+			desc:   "test format argument count beyond stack",
+			instrs: []i.Instruction{i.StringLoad("x"), i.I32Load(1 << 30), i.StringFormat()},
+			ips:    []int{2},
+		},
+		{
+			desc:   "test func.call argument count",
+			instrs: []i.Instruction{i.I32Load(1), i.FuncCall(3, 0xFFFFFFFF), i.Halt(), i.FuncRet(0)},
+			ips:    []int{1},
+		},
+		{
+			desc:   "test indirect call parameter count",
+			instrs: []i.Instruction{i.FuncLoad(3, 0, 0), i.FuncCallIndirect(0xFFFFFFFF, 0), i.Halt(), i.FuncRet(0)},
+			ips:    []int{1},
+		},
+		{
+			desc:   "test indirect call result count",
+			instrs: []i.Instruction{i.FuncLoad(3, 0, 0), i.FuncCallIndirect(0, 0xFFFFFFFF), i.Halt(), i.FuncRet(0)},
+			ips:    []int{1},
+		},
+		{
+			desc:   "test closure capture count",
+			instrs: []i.Instruction{i.FuncLoad(3, 0, 0), i.FuncClosure(0xFFFFFFFF), i.Halt(), i.FuncRet(0)},
+			ips:    []int{1},
+		},
+		{
+			desc:   "test tail call argument count",
+			instrs: []i.Instruction{i.FuncCall(2, 0), i.Halt(), i.FuncTailCall(2, 0xFFFFFFFF)},
+			ips:    []int{2},
+		},
+		{
+			desc:   "test fallthrough into function",
+			instrs: []i.Instruction{i.FuncLoad(2, 1, 1), i.ToString(), i.MapLen()},
+			ips:    []int{2},
+		},
+		{
+			desc:   "test jump into function",
+			instrs: []i.Instruction{i.FuncCall(3, 0), i.Jump(4), i.Halt(), i.I32Load(1), i.FuncRet(1)},
+			ips:    []int{4},
+		},
+		{
+			desc:   "test bool to i32",
+			instrs: []i.Instruction{i.BoolLoad(true), i.ToI32()},
+			ips:    []int{1},
+		},
+		{
+			desc:   "test string to f64",
+			instrs: []i.Instruction{i.StringLoad("1.5"), i.ToF64()},
+			ips:    []int{1},
+		},
+		{
+			desc:   "test block.br outside block",
+			instrs: []i.Instruction{i.BlockBr()},
+			ips:    []int{0},
+		},
+		{
+			desc:   "test malformed operands",
+			instrs: []i.Instruction{{Kind: i.OP_LIST_NEW, Operands: []byte{object.TAG_I32, 1}}},
+			ips:    []int{0},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			var diags Diagnostics
+			if !errors.As(Verify(tC.instrs), &diags) {
+				t.Fatal("expected diagnostics")
+			}
+			ips := map[int]bool{}
+			for _, d := range diags {
+				ips[d.IP] = true
+			}
+			for _, ip := range tC.ips {
+				if !ips[ip] {
+					t.Fatalf("missing diagnostic for %04d in\n%v", ip, diags)
+				}
+			}
+			if len(ips) != len(tC.ips) {
+				t.Fatalf("unexpected diagnostics\n%v", diags)
+			}
+		})
+	}
+}
+
+func TestVerifyNatives(t *testing.T) {
+	instrs := []i.Instruction{
+		i.I32Load(1),
+		i.NativeCall(0),
+		i.I32Load(1),
+		i.I32Add(),
+	}
+	if err := Verify(instrs); err == nil {
+		t.Fatal("expected unknown native diagnostic")
+	}
+	err := Verify(instrs, Signature{Params: []byte{object.TAG_I32}, Results: []byte{object.TAG_I32}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Verify(instrs, Signature{Params: []byte{object.TAG_I32}, Results: []byte{object.TAG_STRING}})
+	if err == nil {
+		t.Fatal("expected type mismatch diagnostic")
+	}
+}
@@ -1,105 +1,294 @@
 package cvm
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"cvm/instruction"
 	"cvm/object"
 	"fmt"
+	"io"
+	"os"
 )
 
+// Default limits used for zero Options fields and zero value CVM.
 const (
 	STACK_SIZE       = 2048
 	HEAP_SIZE        = 2048
 	STACK_FRAME_SIZE = 2048
+	OBJECT_HEAP_SIZE = 1 << 16
 )
 
+// CANCEL_CHECK_INTERVAL is the number of instructions executed between two context checks.
+const CANCEL_CHECK_INTERVAL = 1024
+
+// I32_CHUNK is the number of i32 results that share one allocation, see pushI32.
+const I32_CHUNK = 1024
+
+type Options struct {
+	StackLimit uint
+	HeapLimit  uint
+	FrameLimit uint
+	// ObjectLimit is the maximum number of objects ref.new and ref.copy may allocate.
+	ObjectLimit uint
+	// GCThreshold is the number of live objects that triggers the first collection, zero means GC_THRESHOLD.
+	GCThreshold uint
+	// FuelLimit is the maximum number of instructions one Execute may run, zero means unlimited.
+	FuelLimit uint64
+	// Stdout and Stdin are used by print, printf, println and read, nil means os.Stdout and os.Stdin.
+	Stdout io.Writer
+	Stdin  io.Reader
+}
+
+// CVM grows Stack, Heap and StackFrame on demand up to their limits.
+// The zero value is ready to use with the default limits.
 type CVM struct {
-	Stack      [STACK_SIZE]object.CVMObject
-	Heap       [HEAP_SIZE]object.CVMObject
-	StackFrame [STACK_FRAME_SIZE]Frame
+	Stack      []object.CVMObject
+	Heap       []object.CVMObject
+	StackFrame []Frame
 	SP, HP, FP uint
+	// Objects is the object heap references point into, index 0 is never used so the zero handle is nil.
+	Objects []object.CVMObject
+	// GC counts the collections of Objects.
+	GC GCStats
+
+	StackLimit, HeapLimit, FrameLimit, ObjectLimit uint
+	GCThreshold                                    uint
+	FuelLimit                                      uint64
+	// Steps is the number of instructions run by the last Execute.
+	Steps uint64
+
+	Natives []Native
+	// Types are the struct types struct.make refers to, ExecuteModule sets them from the module.
+	Types []instruction.TypeDecl
+
+	Stdout io.Writer
+	// Stdin is wrapped in a buffered reader by the first read, replace it with SetStdin afterwards.
+	Stdin io.Reader
+	stdin *bufio.Reader
+
+	ip uint32
+	// free holds the handles of collected objects, nextGC the live object count that triggers the next collection.
+	free   []uint32
+	nextGC uint
+	// freeSlots holds the heap slots released by Free below HP, New takes them from the end.
+	freeSlots []uint32
+	// i32s is the unused rest of the chunk pushI32 carves i32 data from.
+	i32s []byte
+	// program is the last program linked by Execute.
+	program *Program
+}
+
+func NewVM(opts Options) *CVM {
+	return &CVM{
+		StackLimit:  opts.StackLimit,
+		HeapLimit:   opts.HeapLimit,
+		FrameLimit:  opts.FrameLimit,
+		ObjectLimit: opts.ObjectLimit,
+		GCThreshold: opts.GCThreshold,
+		FuelLimit:   opts.FuelLimit,
+		Stdout:      opts.Stdout,
+		Stdin:       opts.Stdin,
+	}
+}
+
+func (vm *CVM) output() io.Writer {
+	if vm.Stdout == nil {
+		return os.Stdout
+	}
+	return vm.Stdout
 }
 
+// SetStdin replaces Stdin, the next read starts with an empty buffer.
+func (vm *CVM) SetStdin(in io.Reader) {
+	vm.Stdin = in
+	vm.stdin = nil
+}
+
+// reader keeps one buffered reader per Stdin, so input buffered by a read isn't lost for the next one.
+// It is dropped by SetStdin, Stdin itself is never compared because its dynamic type may not be comparable.
+func (vm *CVM) reader() *bufio.Reader {
+	if vm.stdin != nil {
+		return vm.stdin
+	}
+	in := vm.Stdin
+	if in == nil {
+		in = os.Stdin
+	}
+	if r, ok := in.(*bufio.Reader); ok {
+		vm.stdin = r
+	} else {
+		vm.stdin = bufio.NewReader(in)
+	}
+	return vm.stdin
+}
+
+func limit(lim, def uint) uint {
+	if lim == 0 {
+		return def
+	}
+	return lim
+}
+
+// grow makes index n of s addressable, doubling the backing storage without exceeding lim.
+func grow[T any](s []T, n, lim uint) ([]T, bool) {
+	if n < uint(len(s)) {
+		return s, true
+	}
+	if n >= lim {
+		return s, false
+	}
+	size := uint(cap(s)) * 2
+	if size < 16 {
+		size = 16
+	}
+	if size > lim {
+		size = lim
+	}
+	if size > uint(cap(s)) {
+		res := make([]T, n+1, size)
+		copy(res, s)
+		return res, true
+	}
+	return s[:n+1], true
+}
+
+// New stores obj in a heap slot. It reuses the slot freed last that belongs to the innermost frame,
+// so freeing a slot and creating a new one hands back the freed index, and only grows the heap
+// when no such slot is free. Slots of enclosing frames are left alone, frame relative indices stay valid.
 func (vm *CVM) New(ctx context.Context, obj object.CVMObject) error {
-	if vm.HP >= HEAP_SIZE {
-		return fmt.Errorf("heap overflow")
+	base := uint32(0)
+	if vm.FP > 0 {
+		base = uint32(vm.StackFrame[vm.FP-1].HeapOffset)
+	}
+	for n := len(vm.freeSlots) - 1; n >= 0; n-- {
+		if ind := vm.freeSlots[n]; ind >= base {
+			vm.freeSlots = append(vm.freeSlots[:n], vm.freeSlots[n+1:]...)
+			vm.Heap[ind] = obj
+			return nil
+		}
+	}
+	heap, ok := grow(vm.Heap, vm.HP, limit(vm.HeapLimit, HEAP_SIZE))
+	if !ok {
+		return ErrHeapOverflow
 	}
+	vm.Heap = heap
 	vm.Heap[vm.HP] = obj
 	vm.HP++
 	return nil
 }
 func (vm *CVM) Load(ctx context.Context, ind uint32) (object.CVMObject, error) {
-	if uint32(vm.HP) < ind {
-		return object.CVMObject{}, fmt.Errorf("symbol with index %d not found", ind)
+	if uint32(vm.HP) <= ind {
+		return object.CVMObject{}, fmt.Errorf("%w: symbol with index %d not found", ErrInvalidSlot, ind)
 	}
 	obj := vm.Heap[ind]
 	return obj, nil
 }
 func (vm *CVM) Free(ctx context.Context, ind uint32) error {
-	if uint32(vm.HP) < ind {
-		return fmt.Errorf("symbol with index %d not found", ind)
+	if uint32(vm.HP) <= ind {
+		return fmt.Errorf("%w: symbol with index %d not found", ErrInvalidSlot, ind)
+	}
+	// freed slots are the only ones without a tag, freeing one twice must not hand it out twice
+	if vm.Heap[ind].Tag == object.TAG_UNDEFINED {
+		return nil
 	}
 	vm.Heap[ind] = object.CVMObject{}
+	vm.freeSlots = append(vm.freeSlots, ind)
 	return nil
 }
+
+// dropSlots lowers HP to hp when a frame ends, the freed slots above it are gone with the frame.
+func (vm *CVM) dropSlots(hp int) {
+	vm.HP = uint(hp)
+	kept := vm.freeSlots[:0]
+	for _, ind := range vm.freeSlots {
+		if ind < uint32(hp) {
+			kept = append(kept, ind)
+		}
+	}
+	vm.freeSlots = kept
+}
 func (vm *CVM) Save(ctx context.Context, ind uint32, obj object.CVMObject) error {
-	if uint32(vm.HP) < ind {
-		return fmt.Errorf("symbol with index %d not found", ind)
+	if uint32(vm.HP) <= ind {
+		return fmt.Errorf("%w: symbol with index %d not found", ErrInvalidSlot, ind)
 	}
 	if vm.Heap[ind].Tag != obj.Tag {
-		return fmt.Errorf("unexpected tag %d, want %d", obj.Tag, vm.Heap[ind].Tag)
+		return fmt.Errorf("%w: unexpected tag %s, want %s", object.ErrTypeMismatch, object.TagsName(obj.Tag), object.TagsName(vm.Heap[ind].Tag))
 	}
 	vm.Heap[ind] = obj
 	return nil
 }
 
 func (vm *CVM) LastFuncFrame(ctx context.Context) (Frame, error) {
-	var fr Frame
-	for i := vm.FP - 1; i >= 0; i-- {
-		fr = vm.StackFrame[i]
-		if fr.FrameOffset != -1 {
-			break
-		}
+	ind, err := vm.lastFuncFrame()
+	if err != nil {
+		return Frame{}, err
 	}
-	if fr.FrameOffset == -1 {
-		return fr, fmt.Errorf("cant find function frame")
+	return vm.StackFrame[ind], nil
+}
+
+// lastFuncFrame returns the index of the innermost function frame, skipping the frames of blocks.
+func (vm *CVM) lastFuncFrame() (int, error) {
+	for i := int(vm.FP) - 1; i >= 0; i-- {
+		if vm.StackFrame[i].FrameOffset != -1 {
+			return i, nil
+		}
 	}
-	return fr, nil
+	return 0, fmt.Errorf("%w: cant find function frame", ErrFrameUnderflow)
 }
 func (vm *CVM) LastFrame(ctx context.Context) (Frame, error) {
 	if vm.FP == 0 {
-		return Frame{}, fmt.Errorf("empty StackFrame")
+		return Frame{}, ErrFrameUnderflow
 	}
-	return vm.StackFrame[len(vm.StackFrame)-1], nil
+	return vm.StackFrame[vm.FP-1], nil
 }
 func (vm *CVM) PushFrame(ctx context.Context, fr Frame) error {
-	if vm.FP >= STACK_FRAME_SIZE {
-		return fmt.Errorf("stack frame overflow")
+	frames, ok := grow(vm.StackFrame, vm.FP, limit(vm.FrameLimit, STACK_FRAME_SIZE))
+	if !ok {
+		return ErrFrameOverflow
 	}
+	vm.StackFrame = frames
 	vm.StackFrame[vm.FP] = fr
 	vm.FP++
 	return nil
 }
 func (vm *CVM) PopFrame(ctx context.Context) (Frame, error) {
 	if vm.FP == 0 {
-		return Frame{}, fmt.Errorf("cant pop, stackFrame is empty")
+		return Frame{}, ErrFrameUnderflow
 	}
 	vm.FP--
 	fr := vm.StackFrame[vm.FP]
 	return fr, nil
 }
 func (vm *CVM) Push(ctx context.Context, obj object.CVMObject) error {
-	if vm.SP >= STACK_SIZE {
-		return fmt.Errorf("stack overflow")
+	stack, ok := grow(vm.Stack, vm.SP, limit(vm.StackLimit, STACK_SIZE))
+	if !ok {
+		return ErrStackOverflow
 	}
+	vm.Stack = stack
 	vm.Stack[vm.SP] = obj
 	vm.SP++
 	return nil
 }
+
+// pushI32 pushes an i32 without allocating its data on its own, it is carved from a chunk shared
+// with the next I32_CHUNK-1 results. Scalar data is never written in place, so sharing is safe,
+// a chunk is freed once none of its values is referenced.
+func (vm *CVM) pushI32(ctx context.Context, val int32) error {
+	if len(vm.i32s) < 4 {
+		vm.i32s = make([]byte, 4*I32_CHUNK)
+	}
+	data := vm.i32s[:4:4]
+	vm.i32s = vm.i32s[4:]
+	// little endian, like object.CreateI32
+	u := uint32(val)
+	data[0], data[1], data[2], data[3] = byte(u), byte(u>>8), byte(u>>16), byte(u>>24)
+	return vm.Push(ctx, object.CVMObject{Tag: object.TAG_I32, Data: data})
+}
+
 func (vm *CVM) Pop(ctx context.Context) (object.CVMObject, error) {
 	if vm.SP == 0 {
-		return object.CVMObject{}, fmt.Errorf("stack is empty")
+		return object.CVMObject{}, ErrStackUnderflow
 	}
 	vm.SP--
 	obj := vm.Stack[vm.SP]
@@ -117,6 +306,17 @@ func (vm *CVM) Trace() string {
 			fmt.Fprintf(&buf, "\t$%03d -> %s\n", i, str)
 		}
 	}
+	fmt.Fprintf(&buf, "=== Objects (%d collections, %d freed):\n", vm.GC.Collections, vm.GC.Freed)
+	for i := 1; i < len(vm.Objects); i++ {
+		if vm.Objects[i].Data == nil {
+			continue
+		}
+		str, err := object.String(vm.Objects[i])
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(&buf, "\t&%03d -> %s\n", i, str)
+	}
 	fmt.Fprint(&buf, "=== StackFrame:\n")
 	for i := 0; i < int(vm.FP); i++ {
 		fmt.Fprintf(&buf, "\t$%03d -> %s\n", i, vm.StackFrame[i].String())
@@ -132,650 +332,59 @@ func (vm *CVM) Trace() string {
 	return buf.String()
 }
 
+// Execute links instrs and runs them until halt or the end of the program.
+// Running the same instrs and Types again reuses the linked program, unless an instruction was replaced,
+// operands changed in place need Link and Run.
+// Failures are reported as *VMError.
 func (vm *CVM) Execute(ctx context.Context, instrs []instruction.Instruction) error {
-	for ip := uint32(0); ip < uint32(len(instrs)); {
-		instr := instrs[ip]
-		switch instr.Kind {
-		case instruction.OP_NULL:
-			ip++
-		case instruction.OP_HALT:
-			return nil
-		case instruction.OP_I32_LOAD:
-			ip++
-			obj, err := object.CreateObject(instr.Operands)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, obj)
-		case instruction.OP_I32_NEG:
-			ip++
-			resObj, err := UnaryOperation(ctx, vm, object.NegI32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_I32_ADD:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.AddI32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_I32_SUB:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.SubI32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_I32_MUL:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.MulI32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_I32_DIV:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.DivI32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_I32_LT:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.LtI32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_I32_GT:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.GtI32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_I32_LEQ:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.LeqI32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_I32_GEQ:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.GeqI32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_I32_EQ:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.EqI32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_I32_NEQ:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.NeqI32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_BOOL_LOAD:
-			ip++
-			obj, err := object.CreateObject(instr.Operands)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, obj)
-		case instruction.OP_BOOL_NOT:
-			ip++
-			resObj, err := UnaryOperation(ctx, vm, object.NotBool)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_BOOL_AND:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.AndBool)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_BOOL_OR:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.OrBool)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_BOOL_NAND:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.NandBool)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_BOOL_NOR:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.NorBool)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_BOOL_XOR:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.XorBool)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_F32_LOAD:
-			ip++
-			obj, err := object.CreateObject(instr.Operands)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, obj)
-		case instruction.OP_F32_NEG:
-			ip++
-			resObj, err := UnaryOperation(ctx, vm, object.NegF32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_F32_ADD:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.AddF32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_F32_SUB:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.SubF32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_F32_MUL:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.MulF32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_F32_DIV:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.DivF32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_F32_LT:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.LtF32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_F32_GT:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.GtF32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_F32_LEQ:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.LeqF32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_F32_GEQ:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.GeqF32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_F32_EQ:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.EqF32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_F32_NEQ:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.NeqF32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_JUMP:
-			addr, err := object.CreateObject(instr.Operands)
-			if err != nil {
-				return err
-			}
-			v, err := object.ValueI32(addr)
-			if err != nil {
-				return err
-			}
-			ip = uint32(v)
-		case instruction.OP_JUMPC:
-			obj, err := vm.Pop(ctx)
-			if err != nil {
-				return err
-			}
-			val, err := object.ValueBool(obj)
-			if err != nil {
-				return err
-			}
-			if !val {
-				ip++
-				continue
-			}
-			addr, err := object.CreateObject(instr.Operands)
-			if err != nil {
-				return err
-			}
-			v, err := object.ValueI32(addr)
-			if err != nil {
-				return err
-			}
-			ip = uint32(v)
-		case instruction.OP_JUMPNC:
-			obj, err := vm.Pop(ctx)
-			if err != nil {
-				return err
-			}
-			val, err := object.ValueBool(obj)
-			if err != nil {
-				return err
-			}
-			if val {
-				ip++
-				continue
-			}
-			addr, err := object.CreateObject(instr.Operands)
-			if err != nil {
-				return err
-			}
-			v, err := object.ValueI32(addr)
-			if err != nil {
-				return err
-			}
-			ip = uint32(v)
-		case instruction.OP_BLOCK_START:
-			ip++
-			addr, err := object.CreateObject(instr.Operands)
-			if err != nil {
-				return err
-			}
-			retIp, err := object.ValueI32(addr)
-			if err != nil {
-				return err
-			}
-			vm.PushFrame(ctx, Frame{
-				StackOffset: int(vm.SP),
-				HeapOffset:  int(vm.HP),
-				ReturnIP:    uint32(retIp),
-				FrameOffset: -1,
-			})
-		case instruction.OP_BLOCK_BR:
-			fr, err := vm.LastFrame(ctx)
-			if err != nil {
-				return err
-			}
-			ip = fr.ReturnIP
-		case instruction.OP_BLOCK_END:
-			ip++
-			fr, err := vm.PopFrame(ctx)
-			if err != nil {
-				return err
-			}
-			vm.HP = uint(fr.HeapOffset)
-			vm.SP = uint(fr.StackOffset)
-		case instruction.OP_BLOCK_LOAD:
-			ip++
-			ind, err := object.CreateObject(instr.Operands)
-			if err != nil {
-				return err
-			}
-			fr, err := vm.LastFrame(ctx)
-			if err != nil {
-				return err
-			}
-			indVal, err := object.ValueI32(ind)
-			if err != nil {
-				return err
-			}
-			obj, err := vm.Load(ctx, uint32(int32(fr.HeapOffset)+indVal))
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, obj)
-		case instruction.OP_BLOCK_SAVE:
-			ip++
-			ind, err := object.CreateObject(instr.Operands)
-			if err != nil {
-				return err
-			}
-			obj, err := vm.Pop(ctx)
-			if err != nil {
-				return err
-			}
-			fr, err := vm.LastFrame(ctx)
-			if err != nil {
-				return err
-			}
-			indVal, err := object.ValueI32(ind)
-			if err != nil {
-				return err
-			}
-			err = vm.Save(ctx, uint32(int32(fr.HeapOffset)+indVal), obj)
-			if err != nil {
-				return err
-			}
-		case instruction.OP_LOAD:
-			ip++
-			ind, err := object.CreateObject(instr.Operands)
-			if err != nil {
-				return err
-			}
-			indVal, err := object.ValueI32(ind)
-			if err != nil {
-				return err
-			}
-			obj, err := vm.Load(ctx, uint32(indVal))
-			vm.Push(ctx, obj)
-		case instruction.OP_SAVE:
-			ip++
-			ind, err := object.CreateObject(instr.Operands)
-			if err != nil {
-				return err
-			}
-			obj, err := vm.Pop(ctx)
-			if err != nil {
-				return err
-			}
-			indVal, err := object.ValueI32(ind)
-			if err != nil {
-				return err
-			}
-			err = vm.Save(ctx, uint32(indVal), obj)
-			if err != nil {
-				return err
-			}
-		case instruction.OP_FREE:
-			ip++
-			ind, err := object.CreateObject(instr.Operands)
-			if err != nil {
-				return err
-			}
-			indVal, err := object.ValueI32(ind)
-			if err != nil {
-				return err
-			}
-			err = vm.Free(ctx, uint32(indVal))
-			if err != nil {
-				return err
-			}
-		case instruction.OP_NEW:
-			ip++
-			obj, err := vm.Pop(ctx)
-			if err != nil {
-				return err
-			}
-			err = vm.New(ctx, obj)
-			if err != nil {
-				return err
-			}
-		case instruction.OP_POP:
-			ip++
-			_, err := vm.Pop(ctx)
-			if err != nil {
-				return err
-			}
-		case instruction.OP_FUNC_CALL:
-			ip++
-			addr, err := object.CreateObject(instr.Operands[:5])
-			if err != nil {
-				return err
-			}
-			argsLen, err := object.CreateObject(instr.Operands[5:10])
-			if err != nil {
-				return err
-			}
-			argLenVal, err := object.ValueI32(argsLen)
-			if err != nil {
-				return err
-			}
-			vm.PushFrame(ctx, Frame{
-				StackOffset: int(vm.SP) - int(argLenVal),
-				HeapOffset:  int(vm.HP),
-				ReturnIP:    uint32(ip),
-				FrameOffset: int(vm.FP),
-			})
-			addrVal, err := object.ValueI32(addr)
-			if err != nil {
-				return err
-			}
-			ip = uint32(addrVal)
-		case instruction.OP_FUNC_RET:
-			fr, err := vm.LastFuncFrame(ctx)
-			if err != nil {
-				return err
-			}
-			retLen, err := object.CreateObject(instr.Operands[:5])
-			if err != nil {
-				return err
-			}
-			retLenVal, err := object.ValueI32(retLen)
-			if err != nil {
-				return err
-			}
-			ip = fr.ReturnIP
-			objs := vm.Stack[int(vm.SP)-int(retLenVal) : vm.SP]
-			vm.HP = uint(fr.HeapOffset)
-			vm.SP = uint(fr.StackOffset)
-			vm.FP = uint(fr.FrameOffset)
-			for _, obj := range objs {
-				vm.Push(ctx, obj)
-			}
-		case instruction.OP_LOCAL_LOAD:
-			ip++
-			ind, err := object.CreateObject(instr.Operands)
-			if err != nil {
-				return err
-			}
-			fr, err := vm.LastFuncFrame(ctx)
-			if err != nil {
-				return err
-			}
-			indVal, err := object.ValueI32(ind)
-			if err != nil {
-				return err
-			}
-			obj, err := vm.Load(ctx, uint32(int32(fr.HeapOffset)+indVal))
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, obj)
-		case instruction.OP_LOCAL_SAVE:
-			ip++
-			ind, err := object.CreateObject(instr.Operands)
-			if err != nil {
-				return err
-			}
-			obj, err := vm.Pop(ctx)
-			if err != nil {
-				return err
-			}
-			fr, err := vm.LastFuncFrame(ctx)
-			if err != nil {
-				return err
-			}
-			indVal, err := object.ValueI32(ind)
-			if err != nil {
-				return err
-			}
-			err = vm.Save(ctx, uint32(int32(fr.HeapOffset)+indVal), obj)
-			if err != nil {
-				return err
-			}
-		case instruction.OP_LIST_NEW:
-			ip++
-			obj, err := object.CreateList(instr.Operands)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, obj)
-		case instruction.OP_LIST_LENGTH:
-			ip++
-			obj, err := UnaryOperation(ctx, vm, object.LenList)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, obj)
-		case instruction.OP_LIST_GET:
-			ip++
-			obj, err := BinaryOperation(ctx, vm, object.GetList)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, obj)
-		case instruction.OP_LIST_INSERT:
-			ip++
-			list, err := TernaryOperation(ctx, vm, object.InsertList)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, list)
-		case instruction.OP_LIST_REMOVE:
-			ip++
-			list, err := BinaryOperation(ctx, vm, object.RemoveList)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, list)
-		case instruction.OP_LIST_REPLACE:
-			ip++
-			list, err := TernaryOperation(ctx, vm, object.ReplaceList)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, list)
-		case instruction.OP_STRING_LOAD:
-			ip++
-			str, err := object.CreateObject(instr.Operands)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, str)
-		case instruction.OP_STRING_CONCAT:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.ConcatString)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_STRING_SPLIT:
-			ip++
-			list, err := BinaryOperation(ctx, vm, object.SplitString)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, list)
-		case instruction.OP_STRING_FORMAT:
-			ip++
-			resObj, err := NOperation(ctx, vm, object.FormatString)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_STRUCT_NEW:
-			ip++
-			resObj, err := object.CreateStruct(instr.Operands)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_STRUCT_GET:
-			ip++
-			resObj, err := BinaryOperation(ctx, vm, object.GetStruct)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_STRUCT_SET:
-			ip++
-			resObj, err := TernaryOperation(ctx, vm, object.SetStruct)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_TO_STRING:
-			ip++
-			resObj, err := UnaryOperation(ctx, vm, object.AsString)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_TO_I32:
-			ip++
-			resObj, err := UnaryOperation(ctx, vm, object.AsI32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_TO_F32:
-			ip++
-			resObj, err := UnaryOperation(ctx, vm, object.AsF32)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_TO_BOOL:
-			ip++
-			resObj, err := UnaryOperation(ctx, vm, object.AsBool)
-			if err != nil {
-				return err
-			}
-			vm.Push(ctx, resObj)
-		case instruction.OP_PRINT:
-			ip++
-			_, err := UnaryOperation(ctx, vm, object.Print)
-			if err != nil {
-				return err
-			}
-		case instruction.OP_PRINTF:
-			ip++
-			_, err := NOperation(ctx, vm, object.Printf)
-			if err != nil {
-				return err
-			}
-		case instruction.OP_PRINTLN:
-			ip++
-			_, err := UnaryOperation(ctx, vm, object.Println)
-			if err != nil {
-				return err
-			}
-		case instruction.OP_READ:
-			ip++
-			resObj, err := object.Read()
-			if err != nil {
-				return err
+	if vm.program == nil || !vm.program.linkedFrom(instrs, vm.Types) {
+		p, err := Link(instrs, vm.Types)
+		if err != nil {
+			return err
+		}
+		vm.program = p
+	}
+	return vm.Run(ctx, vm.program)
+}
+
+// Run executes a program linked by Link, so repeated runs skip decoding the operands.
+func (vm *CVM) Run(ctx context.Context, p *Program) error {
+	err := vm.execute(ctx, p.ops)
+	if err != nil {
+		return vm.newError(p.Code, vm.ip, err)
+	}
+	return nil
+}
+
+// ExecuteModule runs the code of m with the struct types it declares.
+func (vm *CVM) ExecuteModule(ctx context.Context, m *instruction.Module) error {
+	vm.Types = m.Types
+	return vm.Execute(ctx, m.Code)
+}
+
+func (vm *CVM) execute(ctx context.Context, ops []op) error {
+	vm.Steps = 0
+	for ip := uint32(0); ip < uint32(len(ops)); {
+		vm.ip = ip
+		if vm.Steps%CANCEL_CHECK_INTERVAL == 0 {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("%w: %w", ErrCanceled, err)
 			}
-			vm.Push(ctx, resObj)
-		default:
-			return fmt.Errorf("unknown instruction of kind 0x%02x", instr.Kind)
 		}
+		if vm.FuelLimit != 0 && vm.Steps >= vm.FuelLimit {
+			return fmt.Errorf("%w after %d instructions", ErrFuelExhausted, vm.Steps)
+		}
+		vm.Steps++
+		o := &ops[ip]
+		h := handlers[o.kind]
+		if h == nil {
+			return fmt.Errorf("%w of kind 0x%02x", ErrUnknownInstruction, o.kind)
+		}
+		next, err := h(ctx, vm, ip, o)
+		if err != nil {
+			return err
+		}
+		ip = next
 	}
 	return nil
 }